@@ -3,6 +3,10 @@
 {{- cat "--v=" $logLevel | nospace | quote}}
 {{- end}}
 
+{{- define "log.format"}}
+{{- cat "--logFormat=" (default "text" .Values.logFormat) | nospace | quote}}
+{{- end}}
+
 {{- define "driver.name"}}
 {{- default "minio-csi-s3" .Values.nameOverride | trunc 63 | trimSuffix "-"}}
 {{- end}}
@@ -21,6 +25,14 @@
   {{- printf "%s-secret" (include "driver.name" .) -}}
 {{- end -}}
 
+{{- define "driver.node.secret.name" -}}
+  {{- if and .Values.node (hasKey .Values.node "credentialsSecret") -}}
+    {{- .Values.node.credentialsSecret -}}
+  {{- else -}}
+    {{- printf "%s-node-secret" (include "driver.name" .) -}}
+  {{- end -}}
+{{- end -}}
+
 {{- define "driver.labels"}}
 app.kubernetes.io/managed-by: {{ .Release.Service }}
 app.kubernetes.io/instance: {{ .Release.Name }}
@@ -73,4 +85,95 @@ kubernetes.io/metadata.name: {{ include "driver.namespace.name" . | quote}}
 
 {{- define "driver.role.node.name" -}}
   {{- printf "%s-role" (include "driver.node.name" .) -}}
-{{- end -}}
\ No newline at end of file
+{{- end -}}
+
+{{- define "driver.tls.volumeMounts" -}}
+{{- with .Values.tls }}
+{{- if or .caConfigMap .caSecret }}
+- name: tls-ca
+  mountPath: /etc/csi-s3/tls/ca
+  readOnly: true
+{{- end }}
+{{- if .clientCertSecret }}
+- name: tls-client
+  mountPath: /etc/csi-s3/tls/client
+  readOnly: true
+{{- end }}
+{{- end }}
+{{- end -}}
+
+{{- define "driver.tls.volumes" -}}
+{{- with .Values.tls }}
+{{- if .caConfigMap }}
+- name: tls-ca
+  configMap:
+    name: {{ .caConfigMap }}
+    items:
+      - key: {{ default "ca.crt" .caKey }}
+        path: ca.crt
+{{- else if .caSecret }}
+- name: tls-ca
+  secret:
+    secretName: {{ .caSecret }}
+    items:
+      - key: {{ default "ca.crt" .caKey }}
+        path: ca.crt
+{{- end }}
+{{- if .clientCertSecret }}
+- name: tls-client
+  secret:
+    secretName: {{ .clientCertSecret }}
+{{- end }}
+{{- end }}
+{{- end -}}
+
+
+{{- define "driver.health.probes" -}}
+{{- if and .Values.health .Values.health.enabled }}
+livenessProbe:
+  httpGet:
+    path: /healthz
+    port: health
+  periodSeconds: 30
+  failureThreshold: 3
+readinessProbe:
+  httpGet:
+    path: /readyz
+    port: health
+  periodSeconds: 10
+{{- end }}
+{{- end -}}
+
+
+{{- define "driver.topology.args" -}}
+{{- if and .Values.topology .Values.topology.enabled }}
+- "--topologyKey={{ default "topology.kubernetes.io/zone" .Values.topology.key }}"
+{{- end }}
+{{- end -}}
+
+
+{{- define "driver.gc.args" -}}
+{{- with .Values.gc }}
+{{- if .enabled }}
+- "--gcInterval={{ default "1h" .interval }}"
+- "--gcGracePeriod={{ default "168h" .gracePeriod }}"
+- "--gcAction={{ default "report" .action }}"
+{{- end }}
+{{- end }}
+{{- end -}}
+
+
+{{- define "driver.tracing.args" -}}
+{{- with .Values.tracing }}
+{{- if .endpoint }}
+- "--tracingExporter=otlp"
+- "--tracingEndpoint={{ .endpoint }}"
+{{- if .insecure }}
+- "--tracingInsecure=true"
+{{- end }}
+{{- if .sampleRatio }}
+- "--tracingSampleRatio={{ .sampleRatio }}"
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end -}}
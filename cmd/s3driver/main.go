@@ -24,10 +24,15 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/smou/k8s-csi-s3/pkg/config"
 	"github.com/smou/k8s-csi-s3/pkg/driver"
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"github.com/smou/k8s-csi-s3/pkg/driver/tracing"
 	"k8s.io/klog/v2"
 )
 
@@ -35,18 +40,41 @@ func init() {
 
 }
 
-var (
-	endpoint      = flag.String("endpoint", "unix://csi/csi.sock", "CSI endpoint")
-	nodeID        = flag.String("nodeid", "controller", "kubernetes node id")
-	mountBinaryS3 = flag.String("mountBinaryS3", "/usr/local/bin/mount-s3", "s3 mount binary path")
-	mountBinary   = flag.String("mountBinary", "/usr/bin/mount", "unix mount binary path")
-)
+var flagValues = config.DefaultOptions()
+
+// command runs instead of the driver, e.g. s3driver gc --gcAction=delete
+type command struct {
+	// bindFlags registers the flags of the command, optional
+	bindFlags func(*flag.FlagSet)
+	run       func(*config.DriverConfig, context.Context) error
+	// needsCredentials loads the S3 credentials of the driver, the policy command bootstraps them
+	needsCredentials bool
+}
+
+var commands = map[string]command{
+	"gc":     {run: runGC, needsCredentials: true},
+	"policy": {bindFlags: policyFlags.bind, run: runPolicy},
+}
 
 func main() {
 	klog.InitFlags(nil)
+	flagValues.BindFlags(flag.CommandLine)
 
 	flag.Set("logtostderr", "true")
-	flag.Parse()
+	args := os.Args[1:]
+	var cmd *command
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		c, ok := commands[args[0]]
+		if !ok {
+			log.Fatalf("Unknown command %q", args[0])
+		}
+		if c.bindFlags != nil {
+			c.bindFlags(flag.CommandLine)
+		}
+		cmd = &c
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
 	defer klog.Flush()
 
 	ctx, cancel := signal.NotifyContext(
@@ -56,14 +84,25 @@ func main() {
 	)
 	defer cancel()
 
-	config, err := config.InitConfig(ctx)
+	opts, err := config.LoadOptions(flag.CommandLine, flagValues, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("Error loading options: %v", err)
+	}
+	if err := logging.Setup(opts.LogFormat); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
+	config, err := loadConfig(ctx, cmd, opts)
 	if err != nil {
 		log.Fatalf("Error loading DriverConfig: %v", err)
 	}
-	config.Endpoint = *endpoint
-	config.NodeID = *nodeID
-	config.MountBinaryS3 = *mountBinaryS3
-	config.MountBinary = *mountBinary
+	klog.InfoS("Effective config", "config", config.EffectiveConfig())
+	if cmd != nil {
+		if err := cmd.run(config, ctx); err != nil {
+			klog.Flush()
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
 	if err := preflightChecks(config); err != nil {
 		log.Fatalf("Preflight checks failed: %v", err)
 	}
@@ -71,21 +110,62 @@ func main() {
 	os.Exit(0)
 }
 
+// loadConfig loads the DriverConfig for cmd, nil for the driver itself.
+func loadConfig(ctx context.Context, cmd *command, opts *config.Options) (*config.DriverConfig, error) {
+	if cmd != nil && !cmd.needsCredentials {
+		return config.LoadWithoutCredentials(ctx, opts)
+	}
+	return config.Load(ctx, opts)
+}
+
 func runDriver(config *config.DriverConfig, ctx context.Context) {
 	driver, err := driver.NewDriver(config)
 	if err != nil {
 		log.Fatalf("Error init Driver: %v", err)
 	}
+	shutdownTracing, err := tracing.Setup(ctx, config.Tracing)
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
 	go func() {
-		if err := driver.Run(); err != nil {
+		if err := driver.Run(ctx); err != nil {
 			log.Fatalf("driver error: %v", err)
 		}
 	}()
 	<-ctx.Done()
 	driver.Stop()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		klog.ErrorS(err, "Error flushing traces")
+	}
+}
+
+// runGC runs the garbage collection of orphaned buckets once and prints the orphans.
+func runGC(config *config.DriverConfig, ctx context.Context) error {
+	driver, err := driver.NewDriver(config)
+	if err != nil {
+		return err
+	}
+	orphans, err := driver.CollectOrphans(ctx)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tBUCKET\tVOLUME\tORPHANED SINCE\tACTION")
+	for _, o := range orphans {
+		action := string(o.Action)
+		if action == "" {
+			action = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", o.Backend, o.Bucket, o.Volume, o.Since.Format(time.RFC3339), action)
+	}
+	w.Flush()
+	return err
 }
 
+// preflightChecks verifies the prerequisites of the services of the mode, the controller mounts nothing.
 func preflightChecks(config *config.DriverConfig) error {
+	if !config.Mode.Node() {
+		return nil
+	}
 	if config.MountBinaryS3 != "" {
 		if _, err := os.Stat(config.MountBinaryS3); os.IsNotExist(err) {
 			return fmt.Errorf("s3 mount binary not found in $PATH at %s: %v", config.MountBinaryS3, err)
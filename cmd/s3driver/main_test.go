@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+)
+
+// policyEnv returns the options of a driver in mode all whose only credentials are the admin ones.
+func policyEnv(t *testing.T) *config.Options {
+	env := map[string]string{
+		"MINIO_ENDPOINT":   "http://minio.example.com:9000",
+		var_adminAccessKey: "admin",
+		var_adminSecretKey: "admin-secret",
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	opts, err := config.LoadOptions(flag.NewFlagSet("s3driver", flag.ContinueOnError), config.DefaultOptions(), func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	require.NoError(t, err)
+	return opts
+}
+
+func TestPolicyWithoutDriverCredentials(t *testing.T) {
+	opts := policyEnv(t)
+	policy := commands["policy"]
+	cfg, err := loadConfig(context.Background(), &policy, opts)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	policyFlags = policyOptions{outputDir: dir}
+	t.Cleanup(func() { policyFlags = policyOptions{} })
+	require.NoError(t, policy.run(cfg, context.Background()))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+	for _, f := range files {
+		doc, err := os.ReadFile(f)
+		require.NoError(t, err)
+		assert.Contains(t, string(doc), `"Statement"`)
+	}
+}
+
+func TestGCNeedsDriverCredentials(t *testing.T) {
+	opts := policyEnv(t)
+	gc := commands["gc"]
+	_, err := loadConfig(context.Background(), &gc, opts)
+	assert.ErrorContains(t, err, "MINIO_ACCESSKEY")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver"
+)
+
+const (
+	var_adminAccessKey = "MINIO_ADMIN_ACCESSKEY" // env
+	var_adminSecretKey = "MINIO_ADMIN_SECRETKEY" // env
+)
+
+type policyOptions struct {
+	sharedBuckets string
+	outputDir     string
+	apply         bool
+	createUsers   bool
+	// perVolumeCredentials grants the controller the object permissions it passes on to the service accounts
+	perVolumeCredentials bool
+	accessPolicies       bool
+}
+
+var policyFlags policyOptions
+
+const policyUsage = `Usage: s3driver policy [flags]
+
+Renders the minimal MinIO policies of the controller and the node plugin of every backend and optionally applies them.
+The driver does not manage bucket quotas or versioning, the policies grant neither s3:GetBucketVersioning,
+s3:PutBucketVersioning nor admin:GetBucketQuota, admin:SetBucketQuota. Set them with mc as an administrator.
+
+Flags:
+`
+
+func (o *policyOptions) bind(fs *flag.FlagSet) {
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), policyUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.sharedBuckets, "sharedBuckets", "", "comma separated buckets mounted by static or ephemeral inline volumes with the driver credentials, * as wildcard")
+	fs.StringVar(&o.outputDir, "outputDir", "", "write the policies as <name>.json into this directory instead of printing them")
+	fs.BoolVar(&o.apply, "apply", false, "create the policies through the MinIO admin API with "+var_adminAccessKey+"/"+var_adminSecretKey)
+	fs.BoolVar(&o.createUsers, "createUsers", false, "with --apply, create missing users named like the policies and attach the policies")
+	fs.BoolVar(&o.accessPolicies, "accessPolicies", false, "allow the controller to set the bucket policies of StorageClasses with accessPolicy")
+	fs.BoolVar(&o.perVolumeCredentials, "perVolumeCredentials", false, "allow the controller to create service accounts for StorageClasses with perVolumeCredentials")
+}
+
+// runPolicy renders the minimal controller and node policies of every backend and optionally applies them.
+func runPolicy(config *config.DriverConfig, ctx context.Context) error {
+	d, err := driver.NewDriver(config)
+	if err != nil {
+		return err
+	}
+	var shared []string
+	for _, b := range strings.Split(policyFlags.sharedBuckets, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			shared = append(shared, b)
+		}
+	}
+	specs := d.PolicySpecs(shared)
+	for i := range specs {
+		specs[i].Features.ServiceAccounts = policyFlags.perVolumeCredentials
+		specs[i].Features.AccessPolicies = policyFlags.accessPolicies
+	}
+	for _, spec := range specs {
+		policies := []struct {
+			name string
+			doc  []byte
+		}{
+			{spec.ControllerPolicy(), spec.Controller().JSON()},
+			{spec.NodePolicy(), spec.Node().JSON()},
+		}
+		for _, p := range policies {
+			name, doc := p.name, p.doc
+			if policyFlags.outputDir == "" {
+				fmt.Printf("# %s\n%s\n", name, doc)
+				continue
+			}
+			if err := os.WriteFile(filepath.Join(policyFlags.outputDir, name+".json"), append(doc, '\n'), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	if !policyFlags.apply {
+		return nil
+	}
+	users, err := d.ApplyPolicies(ctx, specs, adminCredentials(), policyFlags.createUsers)
+	// the secret keys of new users cannot be read again, so they are printed even if a later backend failed
+	for _, u := range users {
+		if u.SecretKey != "" {
+			fmt.Fprintf(os.Stderr, "Created user %s on backend %s with secret key %s\n", u.AccessKey, u.Backend, u.SecretKey)
+		}
+	}
+	return err
+}
+
+// adminCredentials are read from the environment only, so they do not show up in the process list.
+func adminCredentials() config.S3Credentials {
+	return config.S3Credentials{
+		AccessKey: os.Getenv(var_adminAccessKey),
+		SecretKey: os.Getenv(var_adminSecretKey),
+	}
+}
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultBackend is configured by the MINIO_* keys of the ConfigMap.
+	DefaultBackend = "default"
+
+	var_backends = "MINIO_BACKENDS"
+)
+
+var backendNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// BackendOptions describe an additional S3 backend profile in the ConfigMap or the config file.
+type BackendOptions struct {
+	S3Options
+	CredentialsSecret string            `json:"credentialsSecret,omitempty"`
+	Credentials       CredentialOptions `json:"credentials,omitempty"`
+	// NodeCredentialsSecret is read instead of CredentialsSecret by processes in node mode
+	NodeCredentialsSecret string `json:"nodeCredentialsSecret,omitempty"`
+}
+
+// BackendSpec is the validated configuration of a backend profile.
+type BackendSpec struct {
+	S3 S3Config
+	// CredentialsSecret is the Secret with the credentials of the backend, empty for the default Secret
+	CredentialsSecret string
+	// NodeCredentialsSecret is the Secret used by the nodes, empty for the default Secret
+	NodeCredentialsSecret string
+
+	// withoutNodeCredentials is set on the nodes for backends with a CredentialsSecret but no
+	// NodeCredentialsSecret, they must not fall back to the credentials of the default backend
+	withoutNodeCredentials bool
+}
+
+// nodeScoped returns specs with the Secrets of the nodes, so the nodes never read the
+// credentials of the controller.
+func nodeScoped(specs map[string]BackendSpec) map[string]BackendSpec {
+	scoped := make(map[string]BackendSpec, len(specs))
+	for name, spec := range specs {
+		if name != DefaultBackend {
+			spec.withoutNodeCredentials = spec.CredentialsSecret != "" && spec.NodeCredentialsSecret == ""
+			spec.CredentialsSecret = spec.NodeCredentialsSecret
+		}
+		scoped[name] = spec
+	}
+	return scoped
+}
+
+// Backend is a named S3 backend profile with its current config and credentials.
+type Backend struct {
+	Name        string
+	S3          *S3ConfigHolder
+	Credentials *CredentialsHolder
+
+	secret string
+}
+
+// SecretName returns the Secret the credentials of the backend are loaded from.
+func (b *Backend) SecretName() string {
+	return b.secret
+}
+
+// Backends is the registry of all S3 backend profiles.
+type Backends struct {
+	mu         sync.RWMutex
+	backends   map[string]*Backend
+	generation uint64
+	lastErr    error
+	listenErrs map[string]error
+	listeners  []func(*Backend)
+	onError    []func(error)
+	node       bool
+}
+
+// NewBackends creates the registry from specs. creds holds the initial credentials per backend,
+// backends without an entry share the credentials of the default backend.
+func NewBackends(specs map[string]BackendSpec, creds map[string]S3Credentials) *Backends {
+	b := &Backends{
+		backends:   make(map[string]*Backend, len(specs)),
+		generation: 1,
+	}
+	def := &Backend{
+		Name:        DefaultBackend,
+		S3:          NewS3ConfigHolder(specs[DefaultBackend].S3),
+		Credentials: NewCredentialsHolder(creds[DefaultBackend]),
+		secret:      specs[DefaultBackend].CredentialsSecret,
+	}
+	b.backends[DefaultBackend] = def
+	for name, spec := range specs {
+		if name == DefaultBackend {
+			continue
+		}
+		backend := &Backend{
+			Name:        name,
+			S3:          NewS3ConfigHolder(spec.S3),
+			Credentials: sharedCredentials(name, spec, def),
+			secret:      spec.CredentialsSecret,
+		}
+		if c, ok := creds[name]; ok {
+			backend.Credentials = NewCredentialsHolder(c)
+		}
+		b.backends[name] = backend
+	}
+	return b
+}
+
+// sharedCredentials returns the credentials of a backend without credentials of its own, the ones of
+// the default backend. On the nodes a backend whose credentialsSecret is not accompanied by a
+// nodeCredentialsSecret gets empty credentials, so it can only mount volumes with their own credentials.
+func sharedCredentials(name string, spec BackendSpec, def *Backend) *CredentialsHolder {
+	if !spec.withoutNodeCredentials {
+		return def.Credentials
+	}
+	klog.Warningf("Backend %s has a credentialsSecret but no nodeCredentialsSecret, its volumes cannot be mounted with the driver credentials", name)
+	return NewCredentialsHolder(S3Credentials{})
+}
+
+// SingleBackend creates a registry with only the default backend.
+func SingleBackend(s3 S3Config, creds S3Credentials) *Backends {
+	return NewBackends(
+		map[string]BackendSpec{DefaultBackend: {S3: s3}},
+		map[string]S3Credentials{DefaultBackend: creds},
+	)
+}
+
+// UseNodeCredentials makes Apply load the nodeCredentialsSecret of backends instead of
+// their credentialsSecret.
+func (b *Backends) UseNodeCredentials() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.node = true
+}
+
+// Get returns the backend name, an empty name selects the default backend.
+func (b *Backends) Get(name string) (*Backend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	backend, ok := b.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return backend, nil
+}
+
+// Default returns the default backend.
+func (b *Backends) Default() *Backend {
+	backend, err := b.Get(DefaultBackend)
+	if err != nil {
+		panic(err)
+	}
+	return backend
+}
+
+// Names returns the sorted names of all backends.
+func (b *Backends) Names() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.backends))
+	for name := range b.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generation is incremented every time a new configuration has been applied.
+func (b *Backends) Generation() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.generation
+}
+
+// LastError returns the reason why the latest update was rejected and the errors of the
+// listeners of the backends, or nil if everything has been applied.
+func (b *Backends) LastError() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.errorLocked()
+}
+
+func (b *Backends) errorLocked() error {
+	keys := make([]string, 0, len(b.listenErrs))
+	for key := range b.listenErrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := []error{b.lastErr}
+	for _, key := range keys {
+		errs = append(errs, b.listenErrs[key])
+	}
+	return errors.Join(errs...)
+}
+
+// SetListenerError records the result of the listener named listener for backend, nil clears
+// a previous error. Listeners registered with Each report their errors here, so they show up in
+// LastError also for backends added at runtime.
+func (b *Backends) SetListenerError(backend, listener string, err error) {
+	b.updateError(func() {
+		key := listener + "/" + backend
+		if err == nil {
+			delete(b.listenErrs, key)
+			return
+		}
+		if b.listenErrs == nil {
+			b.listenErrs = make(map[string]error)
+		}
+		b.listenErrs[key] = fmt.Errorf("backend %s: %s: %w", backend, listener, err)
+	})
+}
+
+func (b *Backends) setLastError(err error) {
+	b.updateError(func() { b.lastErr = err })
+}
+
+// OnError registers fn, which is called with LastError every time it changes.
+func (b *Backends) OnError(fn func(error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onError = append(b.onError, fn)
+}
+
+// updateError runs fn under the lock and notifies the OnError listeners if LastError has changed.
+func (b *Backends) updateError(fn func()) {
+	b.mu.Lock()
+	before := b.errorLocked()
+	fn()
+	after := b.errorLocked()
+	listeners := append([]func(error){}, b.onError...)
+	b.mu.Unlock()
+
+	if errorText(before) == errorText(after) {
+		return
+	}
+	for _, l := range listeners {
+		l(after)
+	}
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Each calls fn for every backend, including the ones added later on.
+func (b *Backends) Each(fn func(*Backend)) {
+	b.mu.Lock()
+	existing := make([]*Backend, 0, len(b.backends))
+	for _, backend := range b.backends {
+		existing = append(existing, backend)
+	}
+	b.listeners = append(b.listeners, fn)
+	b.mu.Unlock()
+
+	for _, backend := range existing {
+		fn(backend)
+	}
+}
+
+// Apply updates the registry to specs. Backends missing in specs are kept, so existing volumes
+// can still be staged and deleted. It returns true if anything has changed.
+func (b *Backends) Apply(specs map[string]BackendSpec) bool {
+	type update struct {
+		backend *Backend
+		cfg     S3Config
+	}
+	var updates []update
+	var added []*Backend
+
+	// a valid config clears the rejection of the previous one
+	b.setLastError(nil)
+
+	b.mu.Lock()
+	if b.node {
+		specs = nodeScoped(specs)
+	}
+	def := b.backends[DefaultBackend]
+	for name, spec := range specs {
+		backend, ok := b.backends[name]
+		if !ok {
+			backend = &Backend{
+				Name:        name,
+				S3:          NewS3ConfigHolder(spec.S3),
+				Credentials: sharedCredentials(name, spec, def),
+				secret:      spec.CredentialsSecret,
+			}
+			if spec.CredentialsSecret != "" {
+				// filled by the watcher of the Secret
+				backend.Credentials = NewCredentialsHolder(S3Credentials{})
+			}
+			b.backends[name] = backend
+			added = append(added, backend)
+			continue
+		}
+		if backend.secret != spec.CredentialsSecret {
+			klog.InfoS("Changing the credentials Secret of a backend requires a restart",
+				"backend", name, "secret", backend.secret, "newSecret", spec.CredentialsSecret)
+		}
+		if backend.S3.Current() != spec.S3 {
+			updates = append(updates, update{backend: backend, cfg: spec.S3})
+		}
+	}
+	for name := range b.backends {
+		if _, ok := specs[name]; !ok {
+			klog.InfoS("Backend removed from config, keeping it for existing volumes", "backend", name)
+		}
+	}
+	changed := len(updates) > 0 || len(added) > 0
+	if changed {
+		b.generation++
+	}
+	listeners := append([]func(*Backend){}, b.listeners...)
+	b.mu.Unlock()
+
+	// listeners of the holders are called synchronously, so they are set without holding the lock
+	for _, u := range updates {
+		u.backend.S3.Set(u.cfg)
+		klog.InfoS("Backend updated", "backend", u.backend.Name, "config", u.cfg)
+	}
+	for _, backend := range added {
+		klog.InfoS("Backend added", "backend", backend.Name, "config", backend.S3.Current())
+		for _, l := range listeners {
+			l(backend)
+		}
+	}
+	return changed
+}
+
+// backendSpecsFromConfigMap reads the default backend from the MINIO_* keys and additional
+// backends from MINIO_BACKENDS. The credentials of the default backend are in defaultSecret.
+func backendSpecsFromConfigMap(cm *corev1.ConfigMap, defaultSecret string) (map[string]BackendSpec, error) {
+	def, err := s3ConfigFromConfigMap(cm)
+	if err != nil {
+		return nil, err
+	}
+	specs := map[string]BackendSpec{
+		DefaultBackend: {S3: *def, CredentialsSecret: defaultSecret},
+	}
+	raw := cm.Data[var_backends]
+	if raw == "" {
+		return specs, nil
+	}
+	backends := map[string]BackendOptions{}
+	if err := yaml.UnmarshalStrict([]byte(raw), &backends); err != nil {
+		return nil, fmt.Errorf("invalid %v: %w", var_backends, err)
+	}
+	for name, opts := range backends {
+		if opts.Credentials != (CredentialOptions{}) {
+			return nil, fmt.Errorf("backend %s: credentials must be referenced by credentialsSecret", name)
+		}
+		spec, err := opts.spec(name)
+		if err != nil {
+			return nil, err
+		}
+		specs[name] = *spec
+	}
+	return specs, nil
+}
+
+func (o *BackendOptions) spec(name string) (*BackendSpec, error) {
+	if name == DefaultBackend {
+		return nil, fmt.Errorf("backend name %q is reserved", DefaultBackend)
+	}
+	if !backendNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid backend name %q: lowercase letters, digits and '-' only, at most 32 characters", name)
+	}
+	cfg := S3Config{
+		Endpoint:     o.Endpoint,
+		Region:       o.Region,
+		BucketPrefix: o.BucketPrefix,
+		TLS:          o.TLS,
+
+		AddressingStyle: o.AddressingStyle,
+		Proxy:           o.Proxy,
+		Zone:            o.Zone,
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("backend %s: %w", name, err)
+	}
+	return &BackendSpec{S3: cfg, CredentialsSecret: o.CredentialsSecret, NodeCredentialsSecret: o.NodeCredentialsSecret}, nil
+}
@@ -0,0 +1,83 @@
+package config_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+)
+
+func TestLoad_NodeBackendWithoutNodeCredentialsSecret(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+mode: node
+s3:
+  endpoint: http://localhost:9000
+credentials:
+  accessKey: access
+  secretKey: secret
+backends:
+  archive:
+    endpoint: https://archive.local
+    credentialsSecret: archive-controller
+  shared:
+    endpoint: https://shared.local
+`)
+	opts, err := loadOptions(t, []string{"--config", path}, nil)
+	require.NoError(t, err)
+
+	cfg, err := config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	backends := cfg.Backends()
+
+	archive, err := backends.Get("archive")
+	require.NoError(t, err)
+	assert.Empty(t, archive.SecretName())
+	assert.NotSame(t, backends.Default().Credentials, archive.Credentials)
+	accessKey, secretKey, _ := archive.Credentials.Current()
+	assert.Empty(t, accessKey)
+	assert.Empty(t, secretKey)
+
+	shared, err := backends.Get("shared")
+	require.NoError(t, err)
+	assert.Same(t, backends.Default().Credentials, shared.Credentials)
+}
+
+func TestBackendsApply_NodeBackendWithoutNodeCredentialsSecret(t *testing.T) {
+	def := config.S3Config{Endpoint: "http://localhost:9000", Region: "us-east-1"}
+	specs := map[string]config.BackendSpec{config.DefaultBackend: {S3: def, CredentialsSecret: "csi-s3-node"}}
+	newBackends := func() *config.Backends {
+		return config.NewBackends(specs, map[string]config.S3Credentials{config.DefaultBackend: {AccessKey: "access", SecretKey: "secret"}})
+	}
+	updated := map[string]config.BackendSpec{
+		config.DefaultBackend: specs[config.DefaultBackend],
+		"archive":             {S3: config.S3Config{Endpoint: "https://archive.local", Region: "us-east-1"}, CredentialsSecret: "archive-controller"},
+		"scoped":              {S3: config.S3Config{Endpoint: "https://scoped.local", Region: "us-east-1"}, CredentialsSecret: "scoped-controller", NodeCredentialsSecret: "scoped-node"},
+		"shared":              {S3: config.S3Config{Endpoint: "https://shared.local", Region: "us-east-1"}},
+	}
+
+	node := newBackends()
+	node.UseNodeCredentials()
+	assert.True(t, node.Apply(updated))
+	archive, err := node.Get("archive")
+	require.NoError(t, err)
+	assert.NotSame(t, node.Default().Credentials, archive.Credentials)
+	accessKey, _, _ := archive.Credentials.Current()
+	assert.Empty(t, accessKey)
+	scoped, err := node.Get("scoped")
+	require.NoError(t, err)
+	assert.Equal(t, "scoped-node", scoped.SecretName())
+	shared, err := node.Get("shared")
+	require.NoError(t, err)
+	assert.Same(t, node.Default().Credentials, shared.Credentials)
+
+	// the controller loads the credentialsSecret
+	controller := newBackends()
+	assert.True(t, controller.Apply(updated))
+	archive, err = controller.Get("archive")
+	require.NoError(t, err)
+	assert.Equal(t, "archive-controller", archive.SecretName())
+	assert.NotSame(t, controller.Default().Credentials, archive.Credentials)
+}
@@ -3,12 +3,19 @@ package config
 import (
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/tracing"
 	"github.com/smou/k8s-csi-s3/pkg/driver/version"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
 
@@ -26,21 +33,55 @@ const (
 )
 
 type DriverConfig struct {
+	Mode              Mode
 	Endpoint          string
 	NodeID            string
 	MountBinaryS3     string
 	MountBinary       string
+	CredentialsDir    string
+	CacheDir          string
+	MetricsAddress    string
+	HealthAddress     string
+	HealthChecks      []string
+	LogFormat         string
+	Tracing           tracing.Config
+	Resilience        store.ResilienceConfig
+	Topology          TopologyConfig
+	GC                GCConfig
 	KubernetesVersion string
 	S3                S3Config
 	S3Credentials     S3Credentials
+	STS               STSConfig
 	Meta              Meta
+
+	// BackendRegistry holds the S3 backend profiles and is updated when the ConfigMap or a Secret changes.
+	// S3 and S3Credentials are the initial settings of the default backend.
+	BackendRegistry *Backends
+	// RemountOnCredentialRotation remounts staged volumes which still use rotated credentials
+	RemountOnCredentialRotation bool
+	// EphemeralDriverCredentials mounts ephemeral inline volumes without node publish secret with the backend credentials
+	EphemeralDriverCredentials bool
+	Kube                       KubeConfig
+}
+
+// KubeConfig references the Kubernetes objects the configuration was loaded from.
+type KubeConfig struct {
+	Client        kubernetes.Interface
+	Namespace     string
+	ConfigMapName string
+	SecretName    string
 }
 
 type S3Config struct {
 	Endpoint     string
-	UseTLS       bool
 	Region       string
 	BucketPrefix string
+	TLS          TLSConfig
+	// AddressingStyle is path (default), virtual or auto
+	AddressingStyle string
+	Proxy           ProxyConfig
+	// Zone binds the volumes of the backend to an availability zone, empty for all zones
+	Zone string
 }
 
 type S3Credentials struct {
@@ -48,66 +89,257 @@ type S3Credentials struct {
 	SecretKey string
 }
 
+// String prints the credentials redacted.
+func (c S3Credentials) String() string {
+	return fmt.Sprintf("{AccessKey:%s SecretKey:%s}", logging.Redact(c.AccessKey), logging.Redact(c.SecretKey))
+}
+
+// LogValue implements slog.LogValuer and redacts the credentials.
+func (c S3Credentials) LogValue() slog.Value {
+	return slog.StringValue(c.String())
+}
+
+type STSConfig struct {
+	Audience string
+	Duration time.Duration
+}
+
 type Meta struct {
 	DriverName    string
 	DriverVersion string
 }
 
-func InitConfig(ctx context.Context) (*DriverConfig, error) {
-	klog.Infof("Initializing Config...")
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, fmt.Errorf("cannot create in-cluster config: %w", err)
+// Load resolves opts into a DriverConfig. S3 settings and credentials are loaded from the ConfigMap
+// and Secret if they are configured, otherwise from opts. The Kubernetes API is only contacted if needed.
+func Load(ctx context.Context, opts *Options) (*DriverConfig, error) {
+	return load(ctx, opts, true)
+}
+
+// LoadWithoutCredentials resolves opts like Load but leaves the credentials of all backends empty,
+// for commands which do not talk to the S3 API with them, e.g. to bootstrap the users of the driver.
+func LoadWithoutCredentials(ctx context.Context, opts *Options) (*DriverConfig, error) {
+	return load(ctx, opts, false)
+}
+
+func load(ctx context.Context, opts *Options, withCredentials bool) (*DriverConfig, error) {
+	klog.InfoS("Initializing Config")
+	v := version.GetVersion()
+	cfg := &DriverConfig{
+		Endpoint:       opts.Endpoint,
+		NodeID:         opts.NodeID,
+		MountBinaryS3:  opts.MountBinaryS3,
+		MountBinary:    opts.MountBinary,
+		CredentialsDir: opts.CredentialsDir,
+		CacheDir:       opts.CacheDir,
+		MetricsAddress: opts.MetricsAddress,
+		HealthAddress:  opts.HealthAddress,
+		LogFormat:      opts.LogFormat,
+		Tracing: tracing.Config{
+			Exporter:    opts.Tracing.Exporter,
+			Endpoint:    opts.Tracing.Endpoint,
+			Insecure:    opts.Tracing.Insecure,
+			SampleRatio: opts.Tracing.SampleRatio,
+		},
+		Resilience:                  opts.Resilience.config(),
+		Topology:                    TopologyConfig{Key: opts.Topology.Key, Zone: opts.Topology.Zone},
+		GC:                          opts.GC.config(),
+		RemountOnCredentialRotation: opts.RemountOnCredentialRotation,
+		EphemeralDriverCredentials:  opts.EphemeralDriverCredentials,
+		STS: STSConfig{
+			Audience: opts.STS.Audience,
+			Duration: opts.STS.Duration.Duration,
+		},
+		Meta: Meta{
+			DriverName:    v.DriverName,
+			DriverVersion: v.DriverVersion,
+		},
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	mode, err := parseMode(opts.Mode)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create kubernetes clientset: %w", err)
+		return nil, err
 	}
-	kubernetesVersion, err := kubernetesVersion(clientset)
+	cfg.Mode = mode
+	checks, err := parseHealthChecks(opts.HealthChecks, mode)
 	if err != nil {
-		klog.Errorf("failed to get kubernetes version: %v", err)
+		return nil, err
+	}
+	cfg.HealthChecks = checks
+	if err := cfg.Resilience.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid resilience options: %w", err)
+	}
+
+	var clientset *kubernetes.Clientset
+	if opts.UsesKubernetes() {
+		var err error
+		clientset, err = newClientset(opts.Kubernetes.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		cfg.KubernetesVersion, err = kubernetesVersion(clientset)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get kubernetes version")
+		}
+		if opts.Kubernetes.Namespace == "" && (opts.Kubernetes.ConfigMapName != "" || opts.Kubernetes.SecretName != "") {
+			return nil, fmt.Errorf("%v not set", var_namespace)
+		}
+		cfg.Kube = KubeConfig{
+			Client:        clientset,
+			Namespace:     opts.Kubernetes.Namespace,
+			ConfigMapName: opts.Kubernetes.ConfigMapName,
+			SecretName:    opts.Kubernetes.SecretName,
+		}
 	}
-	namespace := os.Getenv(var_namespace)
-	if namespace == "" {
-		return nil, fmt.Errorf("%v not set", var_namespace)
+
+	var specs map[string]BackendSpec
+	if name := opts.Kubernetes.ConfigMapName; name != "" {
+		var err error
+		specs, err = LoadBackendsFromConfigMap(ctx, clientset, opts.Kubernetes.Namespace, name, opts.Kubernetes.SecretName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load configmap %v: %w", name, err)
+		}
+	} else {
+		def := S3Config{
+			Endpoint:     opts.S3.Endpoint,
+			Region:       opts.S3.Region,
+			BucketPrefix: opts.S3.BucketPrefix,
+			TLS:          opts.S3.TLS,
+
+			AddressingStyle: opts.S3.AddressingStyle,
+			Proxy:           opts.S3.Proxy,
+			Zone:            opts.S3.Zone,
+		}
+		if def.Region == "" {
+			def.Region = defaultRegion
+		}
+		if err := def.Validate(); err != nil {
+			return nil, err
+		}
+		specs = map[string]BackendSpec{
+			DefaultBackend: {S3: def, CredentialsSecret: opts.Kubernetes.SecretName},
+		}
+		for name, backend := range opts.Backends {
+			spec, err := backend.spec(name)
+			if err != nil {
+				return nil, err
+			}
+			specs[name] = *spec
+		}
 	}
-	configmap_name := os.Getenv(var_configmap_name)
-	if configmap_name == "" {
-		return nil, fmt.Errorf("%v not set", var_configmap_name)
+	cfg.S3 = specs[DefaultBackend].S3
+	if !mode.Controller() {
+		specs = nodeScoped(specs)
 	}
-	secret_name := os.Getenv(var_secret_name)
-	if secret_name == "" {
-		return nil, fmt.Errorf("%v not set", var_secret_name)
+
+	creds := map[string]S3Credentials{}
+	if withCredentials {
+		var err error
+		creds, err = loadCredentials(ctx, clientset, opts, mode, specs)
+		if err != nil {
+			return nil, err
+		}
 	}
-	s3Config, err := LoadControllerConfigMap(ctx, clientset, namespace, configmap_name)
-	if err != nil {
-		return nil, fmt.Errorf("cannot load configmap %v: %w", configmap_name, err)
+	cfg.S3Credentials = creds[DefaultBackend]
+
+	cfg.BackendRegistry = NewBackends(specs, creds)
+	if !mode.Controller() {
+		cfg.BackendRegistry.UseNodeCredentials()
+	}
+	klog.InfoS("Config initialized")
+	return cfg, nil
+}
+
+// loadCredentials loads the credentials of every backend from its Secret or from opts. Backends
+// without an entry share the credentials of the default backend.
+func loadCredentials(ctx context.Context, clientset *kubernetes.Clientset, opts *Options, mode Mode, specs map[string]BackendSpec) (map[string]S3Credentials, error) {
+	creds := make(map[string]S3Credentials, len(specs))
+	for name, spec := range specs {
+		switch {
+		case spec.CredentialsSecret != "":
+			if clientset == nil {
+				return nil, fmt.Errorf("backend %s: credentials Secret %s requires the Kubernetes API", name, spec.CredentialsSecret)
+			}
+			s3Creds, err := LoadControllerCredentialsFromSecret(ctx, *clientset, opts.Kubernetes.Namespace, spec.CredentialsSecret)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load secret %v: %w", spec.CredentialsSecret, err)
+			}
+			creds[name] = *s3Creds
+		case name == DefaultBackend:
+			s3Creds, err := opts.Credentials.resolve()
+			if err != nil && mode.Controller() {
+				return nil, err
+			}
+			if err != nil {
+				// the node only needs them to stage volumes, workload identity works without
+				klog.InfoS("No credentials for the default backend, volumes have to use workload identity", "err", err)
+				continue
+			}
+			creds[name] = *s3Creds
+		case opts.Backends[name].Credentials != (CredentialOptions{}):
+			c := opts.Backends[name].Credentials
+			s3Creds, err := c.resolve()
+			if err != nil {
+				return nil, fmt.Errorf("backend %s: %w", name, err)
+			}
+			creds[name] = *s3Creds
+		}
+	}
+	return creds, nil
+}
+
+func parseHealthChecks(value string, mode Mode) ([]string, error) {
+	var checks []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(HealthCheckNames, name) {
+			return nil, fmt.Errorf("unknown health check %q: expected one of %s", name, strings.Join(HealthCheckNames, ", "))
+		}
+		if !mode.supportsCheck(name) {
+			klog.V(2).InfoS("Skipping health check not applicable to the mode", "check", name, "mode", mode)
+			continue
+		}
+		checks = append(checks, name)
 	}
-	s3Creds, err := LoadControllerCredentialsFromSecret(ctx, *clientset, namespace, secret_name)
+	return checks, nil
+}
+
+func newClientset(kubeconfig string) (*kubernetes.Clientset, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubeconfig %s: %w", kubeconfig, err)
+		}
+	} else {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cannot create in-cluster config: %w", err)
+		}
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load secret %v: %w", secret_name, err)
+		return nil, fmt.Errorf("cannot create kubernetes clientset: %w", err)
 	}
-	v := version.GetVersion()
-	klog.Infof("Config initialized.")
-	klog.V(4).Infof("Namespace: %v", namespace)
-	klog.V(4).Infof("S3: %v", s3Config)
-	klog.V(4).Infof("S3 Cred: %v", s3Creds)
-	return &DriverConfig{
-		KubernetesVersion: kubernetesVersion,
-		S3:                *s3Config,
-		S3Credentials:     *s3Creds,
-		Meta: Meta{
-			DriverName:    v.DriverName,
-			DriverVersion: v.DriverVersion,
-		},
-	}, nil
+	return clientset, nil
+}
+
+// Backends returns the backend registry, creating it from S3 and S3Credentials if necessary.
+func (d *DriverConfig) Backends() *Backends {
+	if d.BackendRegistry == nil {
+		d.BackendRegistry = SingleBackend(d.S3, d.S3Credentials)
+	}
+	return d.BackendRegistry
 }
 
 func (d *DriverConfig) LogVersionInfo() {
 	version := version.GetVersion()
-	klog.Infof("Driver version: %v, Git commit: %v, build date: %v, nodeID: %v, kubernetes version: %v",
-		d.Meta.DriverVersion, version.GitCommit, version.BuildDate, d.NodeID, d.KubernetesVersion)
+	klog.InfoS("Driver version", "version", d.Meta.DriverVersion, "gitCommit", version.GitCommit,
+		"buildDate", version.BuildDate, "nodeID", d.NodeID, "kubernetesVersion", d.KubernetesVersion)
 }
 
 func kubernetesVersion(clientset *kubernetes.Clientset) (string, error) {
@@ -119,49 +351,25 @@ func kubernetesVersion(clientset *kubernetes.Clientset) (string, error) {
 	return version.String(), nil
 }
 
-func LoadControllerConfigMap(ctx context.Context, client *kubernetes.Clientset, namespace, name string) (*S3Config, error) {
-	klog.Infof("Initializing Configmap '%s' in namespace %s", name, namespace)
+// LoadBackendsFromConfigMap reads all backend profiles from the driver ConfigMap.
+func LoadBackendsFromConfigMap(ctx context.Context, client *kubernetes.Clientset, namespace, name, defaultSecret string) (map[string]BackendSpec, error) {
+	klog.InfoS("Loading ConfigMap", "configMap", klog.KRef(namespace, name))
 	cm, err := client.CoreV1().
 		ConfigMaps(namespace).
 		Get(ctx, name, v1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
-	data := cm.Data
-	cfg := &S3Config{
-		Endpoint:     data[var_endpoint],
-		UseTLS:       data[var_endpoint] == "true",
-		Region:       data[var_region],
-		BucketPrefix: data[var_bucketprefix],
-	}
-
-	if cfg.Endpoint == "" {
-		return nil, fmt.Errorf("%v missing in ConfigMap", var_endpoint)
-	}
-	if cfg.Region == "" {
-		klog.Infof("%v missing in ConfigMap. Use Default: %v", var_region, defaultRegion)
-		cfg.Region = defaultRegion
-	}
-	return cfg, nil
+	return backendSpecsFromConfigMap(cm, defaultSecret)
 }
 
 func LoadControllerCredentialsFromSecret(ctx context.Context, client kubernetes.Clientset, namespace, name string) (*S3Credentials, error) {
-	klog.Infof("Initializing Secret '%s' in namespace %s", name, namespace)
+	klog.InfoS("Loading Secret", "secret", klog.KRef(namespace, name))
 	sec, err := client.CoreV1().
 		Secrets(namespace).
 		Get(ctx, name, v1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
-	data := sec.Data
-	cfg := &S3Credentials{
-		AccessKey: string(data[var_accessKey]),
-		SecretKey: string(data[var_secretKey]),
-	}
-
-	if cfg.AccessKey == "" || cfg.SecretKey == "" {
-		return nil, fmt.Errorf("invalid controller credentials secret")
-	}
-
-	return cfg, nil
+	return credentialsFromSecret(sec)
 }
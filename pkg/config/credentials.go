@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CredentialsHolder shares the current S3 credentials between the components of the driver.
+type CredentialsHolder struct {
+	holder[S3Credentials]
+}
+
+func NewCredentialsHolder(creds S3Credentials) *CredentialsHolder {
+	h := &CredentialsHolder{}
+	h.init(creds)
+	return h
+}
+
+// Current implements store.CredentialsSource.
+func (h *CredentialsHolder) Current() (string, string, uint64) {
+	creds, generation := h.Get()
+	return creds.AccessKey, creds.SecretKey, generation
+}
+
+func credentialsFromSecret(sec *corev1.Secret) (*S3Credentials, error) {
+	data := sec.Data
+	cfg := &S3Credentials{
+		AccessKey: string(data[var_accessKey]),
+		SecretKey: string(data[var_secretKey]),
+	}
+
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("invalid controller credentials secret")
+	}
+
+	return cfg, nil
+}
+
+// CredentialsFromSecretData reads the keys of a Secret passed by the CSI sidecars or kubelet,
+// e.g. the node publish secret of an ephemeral volume. It uses the same keys as the driver Secret.
+func CredentialsFromSecretData(data map[string]string) (*S3Credentials, error) {
+	cfg := &S3Credentials{
+		AccessKey: data[var_accessKey],
+		SecretKey: data[var_secretKey],
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("secret must contain %s and %s", var_accessKey, var_secretKey)
+	}
+	return cfg, nil
+}
+
+// SecretData returns the credentials with the keys of the driver Secret, e.g. for a node stage secret.
+func (c S3Credentials) SecretData() map[string][]byte {
+	return map[string][]byte{
+		var_accessKey: []byte(c.AccessKey),
+		var_secretKey: []byte(c.SecretKey),
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"sigs.k8s.io/yaml"
+)
+
+type effectiveConfig struct {
+	Mode                        Mode              `json:"mode"`
+	Endpoint                    string            `json:"endpoint"`
+	NodeID                      string            `json:"nodeID"`
+	MountBinaryS3               string            `json:"mountBinaryS3"`
+	MountBinary                 string            `json:"mountBinary"`
+	CredentialsDir              string            `json:"credentialsDir"`
+	CacheDir                    string            `json:"cacheDir"`
+	MetricsAddress              string            `json:"metricsAddress,omitempty"`
+	HealthAddress               string            `json:"healthAddress,omitempty"`
+	HealthChecks                []string          `json:"healthChecks"`
+	LogFormat                   string            `json:"logFormat,omitempty"`
+	Tracing                     TracingOptions    `json:"tracing,omitempty"`
+	Resilience                  ResilienceOptions `json:"resilience"`
+	Topology                    TopologyOptions   `json:"topology,omitempty"`
+	GC                          GCOptions         `json:"gc"`
+	RemountOnCredentialRotation bool              `json:"remountOnCredentialRotation"`
+	EphemeralDriverCredentials  bool              `json:"ephemeralDriverCredentials"`
+	STS                         STSOptions        `json:"sts"`
+	S3                          S3Options         `json:"s3"`
+	Credentials                 struct {
+		AccessKey string `json:"accessKey"`
+		SecretKey string `json:"secretKey"`
+	} `json:"credentials"`
+	Backends   map[string]effectiveBackend `json:"backends,omitempty"`
+	Kubernetes struct {
+		Enabled       bool   `json:"enabled"`
+		Version       string `json:"version,omitempty"`
+		Namespace     string `json:"namespace,omitempty"`
+		ConfigMapName string `json:"configMapName,omitempty"`
+		SecretName    string `json:"secretName,omitempty"`
+	} `json:"kubernetes"`
+}
+
+type effectiveBackend struct {
+	S3Options
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// EffectiveConfig renders the resolved configuration as YAML with all credentials redacted.
+func (d *DriverConfig) EffectiveConfig() string {
+	e := effectiveConfig{
+		Mode:           d.Mode,
+		Endpoint:       d.Endpoint,
+		NodeID:         d.NodeID,
+		MountBinaryS3:  d.MountBinaryS3,
+		MountBinary:    d.MountBinary,
+		CredentialsDir: d.CredentialsDir,
+		CacheDir:       d.CacheDir,
+		MetricsAddress: d.MetricsAddress,
+		HealthAddress:  d.HealthAddress,
+		HealthChecks:   d.HealthChecks,
+		LogFormat:      d.LogFormat,
+		Tracing: TracingOptions{
+			Exporter:    d.Tracing.Exporter,
+			Endpoint:    d.Tracing.Endpoint,
+			Insecure:    d.Tracing.Insecure,
+			SampleRatio: d.Tracing.SampleRatio,
+		},
+		Resilience:                  resilienceOptions(d.Resilience),
+		Topology:                    TopologyOptions{Key: d.Topology.Key, Zone: d.Topology.Zone},
+		GC:                          gcOptions(d.GC),
+		RemountOnCredentialRotation: d.RemountOnCredentialRotation,
+		EphemeralDriverCredentials:  d.EphemeralDriverCredentials,
+		S3: S3Options{
+			Endpoint:     d.S3.Endpoint,
+			Region:       d.S3.Region,
+			BucketPrefix: d.S3.BucketPrefix,
+			TLS:          d.S3.TLS,
+
+			AddressingStyle: d.S3.AddressingStyle,
+			Proxy:           d.S3.Proxy,
+			Zone:            d.S3.Zone,
+		},
+	}
+	e.Credentials.AccessKey = logging.Redact(d.S3Credentials.AccessKey)
+	e.Credentials.SecretKey = logging.Redact(d.S3Credentials.SecretKey)
+	e.STS.Audience = d.STS.Audience
+	e.STS.Duration.Duration = d.STS.Duration
+	if d.BackendRegistry != nil {
+		for _, name := range d.BackendRegistry.Names() {
+			if name == DefaultBackend {
+				continue
+			}
+			b, _ := d.BackendRegistry.Get(name)
+			cfg := b.S3.Current()
+			if e.Backends == nil {
+				e.Backends = map[string]effectiveBackend{}
+			}
+			e.Backends[name] = effectiveBackend{
+				S3Options: S3Options{
+					Endpoint:     cfg.Endpoint,
+					Region:       cfg.Region,
+					BucketPrefix: cfg.BucketPrefix,
+					TLS:          cfg.TLS,
+
+					AddressingStyle: cfg.AddressingStyle,
+					Proxy:           cfg.Proxy,
+					Zone:            cfg.Zone,
+				},
+				CredentialsSecret: b.SecretName(),
+			}
+		}
+	}
+	e.Kubernetes.Enabled = d.Kube.Client != nil
+	e.Kubernetes.Version = d.KubernetesVersion
+	e.Kubernetes.Namespace = d.Kube.Namespace
+	e.Kubernetes.ConfigMapName = d.Kube.ConfigMapName
+	e.Kubernetes.SecretName = d.Kube.SecretName
+
+	out, err := yaml.Marshal(e)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
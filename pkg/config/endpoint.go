@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	var_addressing_style = "MINIO_ADDRESSING_STYLE"
+	var_http_proxy       = "MINIO_HTTP_PROXY"
+	var_https_proxy      = "MINIO_HTTPS_PROXY"
+	var_no_proxy         = "MINIO_NO_PROXY"
+)
+
+// Addressing styles of bucket requests.
+const (
+	AddressingStylePath    = "path"
+	AddressingStyleVirtual = "virtual"
+	AddressingStyleAuto    = "auto"
+)
+
+// ProxyConfig routes the S3 requests of a backend through an HTTP(S) proxy.
+type ProxyConfig struct {
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	// NoProxy is a comma separated list of hosts, domains and CIDRs reached directly
+	NoProxy string `json:"noProxy,omitempty"`
+}
+
+// Validate checks that the proxies are URLs.
+func (p ProxyConfig) Validate() error {
+	for key, v := range map[string]string{var_http_proxy: p.HTTPProxy, var_https_proxy: p.HTTPSProxy} {
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid %v %q: expected scheme://host[:port]", key, v)
+		}
+	}
+	return nil
+}
+
+// AddressingStyleOrDefault returns the configured addressing style, path-style if none is set.
+func (c *S3Config) AddressingStyleOrDefault() string {
+	if c.AddressingStyle == "" {
+		return AddressingStylePath
+	}
+	return c.AddressingStyle
+}
+
+// VirtualHostedStyle reports whether requests for bucket use virtual-hosted style.
+// "auto" decides like minio-go, so the driver and the mounts address buckets the same way.
+func (c *S3Config) VirtualHostedStyle(bucket string) bool {
+	switch c.AddressingStyleOrDefault() {
+	case AddressingStyleVirtual:
+		return true
+	case AddressingStyleAuto:
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return false
+		}
+		return s3utils.IsVirtualHostSupported(*u, bucket)
+	default:
+		return false
+	}
+}
+
+// Transport returns the HTTP transport with the TLS and proxy settings of the backend.
+func (c *S3Config) Transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.UseTLS() {
+		tlsConfig, err := c.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if c.Proxy != (ProxyConfig{}) {
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  c.Proxy.HTTPProxy,
+			HTTPSProxy: c.Proxy.HTTPSProxy,
+			NoProxy:    c.Proxy.NoProxy,
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+	return transport, nil
+}
@@ -0,0 +1,56 @@
+package config_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Config_VirtualHostedStyle(t *testing.T) {
+	minio := config.S3Config{Endpoint: "https://minio.local"}
+	assert.False(t, minio.VirtualHostedStyle("bucket"))
+	assert.Equal(t, "path", minio.AddressingStyleOrDefault())
+
+	minio.AddressingStyle = config.AddressingStyleVirtual
+	assert.True(t, minio.VirtualHostedStyle("bucket"))
+
+	// auto follows minio-go: virtual-hosted style only for well-known providers
+	minio.AddressingStyle = config.AddressingStyleAuto
+	assert.False(t, minio.VirtualHostedStyle("bucket"))
+	aws := config.S3Config{Endpoint: "https://s3.amazonaws.com", AddressingStyle: config.AddressingStyleAuto}
+	assert.True(t, aws.VirtualHostedStyle("bucket"))
+	assert.False(t, aws.VirtualHostedStyle("bucket.with.dots"))
+
+	minio.AddressingStyle = "dns"
+	assert.Error(t, minio.Validate())
+}
+
+func TestS3Config_TransportProxy(t *testing.T) {
+	cfg := config.S3Config{
+		Endpoint: "https://minio.local",
+		Proxy: config.ProxyConfig{
+			HTTPSProxy: "http://proxy.local:3128",
+			NoProxy:    "minio.internal,10.0.0.0/8",
+		},
+	}
+	require.NoError(t, cfg.Validate())
+	transport, err := cfg.Transport()
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://minio.local/bucket", nil)
+	proxy, err := transport.Proxy(req)
+	require.NoError(t, err)
+	require.NotNil(t, proxy)
+	assert.Equal(t, "proxy.local:3128", proxy.Host)
+
+	req, _ = http.NewRequest(http.MethodGet, "https://minio.internal/bucket", nil)
+	proxy, err = transport.Proxy(req)
+	require.NoError(t, err)
+	assert.Nil(t, proxy)
+
+	cfg.Proxy.HTTPProxy = "proxy.local"
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultGCGracePeriod = 7 * 24 * time.Hour
+
+// GCConfig configures the garbage collection of orphaned buckets, the actions are validated by the gc package.
+type GCConfig struct {
+	// Interval of the background loop in the controller, the loop is disabled if zero
+	Interval time.Duration
+	// GracePeriod a bucket must be orphaned before Action is applied
+	GracePeriod time.Duration
+	// Action is report, archive or delete
+	Action string
+}
+
+// GCOptions are the settings of GCConfig.
+type GCOptions struct {
+	Interval    v1.Duration `json:"interval,omitempty"`
+	GracePeriod v1.Duration `json:"gracePeriod,omitempty"`
+	Action      string      `json:"action,omitempty"`
+}
+
+func (o GCOptions) config() GCConfig {
+	return GCConfig{Interval: o.Interval.Duration, GracePeriod: o.GracePeriod.Duration, Action: o.Action}
+}
+
+func gcOptions(c GCConfig) GCOptions {
+	return GCOptions{
+		Interval:    v1.Duration{Duration: c.Interval},
+		GracePeriod: v1.Duration{Duration: c.GracePeriod},
+		Action:      c.Action,
+	}
+}
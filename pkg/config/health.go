@@ -0,0 +1,13 @@
+package config
+
+// Names of the built-in health checks, selected with --healthChecks. They are implemented by the
+// driver/health package.
+const (
+	HealthCheckS3          = "s3"
+	HealthCheckFUSE        = "fuse"
+	HealthCheckMountBinary = "mountBinary"
+	HealthCheckMountinfo   = "mountinfo"
+)
+
+// HealthCheckNames are all built-in health checks.
+var HealthCheckNames = []string{HealthCheckS3, HealthCheckFUSE, HealthCheckMountBinary, HealthCheckMountinfo}
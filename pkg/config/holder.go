@@ -0,0 +1,61 @@
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type holderState[T comparable] struct {
+	value      T
+	generation uint64
+}
+
+// holder shares a value between the components of the driver. Every change increments
+// the generation, which allows consumers to detect stale copies.
+type holder[T comparable] struct {
+	state atomic.Pointer[holderState[T]]
+
+	mu        sync.Mutex
+	listeners []func(T, uint64)
+}
+
+func (h *holder[T]) init(value T) {
+	h.state.Store(&holderState[T]{value: value, generation: 1})
+}
+
+// Get returns the current value and its generation.
+func (h *holder[T]) Get() (T, uint64) {
+	s := h.state.Load()
+	return s.value, s.generation
+}
+
+// Generation returns the generation of the current value.
+func (h *holder[T]) Generation() uint64 {
+	return h.state.Load().generation
+}
+
+// Set swaps the value and notifies all listeners. Unchanged values are ignored.
+func (h *holder[T]) Set(value T) bool {
+	h.mu.Lock()
+	old := h.state.Load()
+	if old.value == value {
+		h.mu.Unlock()
+		return false
+	}
+	next := &holderState[T]{value: value, generation: old.generation + 1}
+	h.state.Store(next)
+	listeners := append([]func(T, uint64){}, h.listeners...)
+	h.mu.Unlock()
+
+	for _, l := range listeners {
+		l(next.value, next.generation)
+	}
+	return true
+}
+
+// OnChange registers fn to be called after the value has been changed.
+func (h *holder[T]) OnChange(fn func(T, uint64)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners = append(h.listeners, fn)
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Mode selects the CSI services the driver runs.
+type Mode string
+
+const (
+	// ModeController runs the Identity and Controller services, it provisions buckets and mounts nothing.
+	ModeController Mode = "controller"
+	// ModeNode runs the Identity and Node services, it mounts volumes and builds no BucketStore.
+	ModeNode Mode = "node"
+	// ModeAll runs all services in one process, e.g. for local development.
+	ModeAll Mode = "all"
+)
+
+// Modes lists the valid modes.
+var Modes = []Mode{ModeController, ModeNode, ModeAll}
+
+func parseMode(value string) (Mode, error) {
+	if value == "" {
+		return ModeAll, nil
+	}
+	m := Mode(value)
+	if !slices.Contains(Modes, m) {
+		return "", fmt.Errorf("unknown mode %q: expected one of %v", value, Modes)
+	}
+	return m, nil
+}
+
+// Controller reports whether the mode runs the Controller service.
+func (m Mode) Controller() bool {
+	return m == ModeController || m == ModeAll
+}
+
+// Node reports whether the mode runs the Node service.
+func (m Mode) Node() bool {
+	return m == ModeNode || m == ModeAll
+}
+
+// nodeChecks depend on the mounts of the node, the S3 check on the BucketStore of the controller.
+var nodeChecks = []string{HealthCheckFUSE, HealthCheckMountBinary, HealthCheckMountinfo}
+
+// supportsCheck reports whether the health check applies to the services of the mode.
+func (m Mode) supportsCheck(name string) bool {
+	if slices.Contains(nodeChecks, name) {
+		return m.Node()
+	}
+	return m.Controller()
+}
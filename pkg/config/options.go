@@ -0,0 +1,316 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/sts"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	var_config     = "CSI_S3_CONFIG" // env
+	var_kubeconfig = "KUBECONFIG"    // env
+)
+
+// Options are the settings of the driver before they are resolved into a DriverConfig.
+// They are layered as defaults, then the YAML config file, then environment variables, then flags.
+type Options struct {
+	ConfigFile string `json:"-"`
+
+	Mode                        string            `json:"mode,omitempty"`
+	Endpoint                    string            `json:"endpoint,omitempty"`
+	NodeID                      string            `json:"nodeID,omitempty"`
+	MountBinaryS3               string            `json:"mountBinaryS3,omitempty"`
+	MountBinary                 string            `json:"mountBinary,omitempty"`
+	CredentialsDir              string            `json:"credentialsDir,omitempty"`
+	CacheDir                    string            `json:"cacheDir,omitempty"`
+	RemountOnCredentialRotation bool              `json:"remountOnCredentialRotation,omitempty"`
+	EphemeralDriverCredentials  bool              `json:"ephemeralDriverCredentials,omitempty"`
+	MetricsAddress              string            `json:"metricsAddress,omitempty"`
+	HealthAddress               string            `json:"healthAddress,omitempty"`
+	HealthChecks                string            `json:"healthChecks,omitempty"`
+	LogFormat                   string            `json:"logFormat,omitempty"`
+	Tracing                     TracingOptions    `json:"tracing,omitempty"`
+	Resilience                  ResilienceOptions `json:"resilience,omitempty"`
+	Topology                    TopologyOptions   `json:"topology,omitempty"`
+	GC                          GCOptions         `json:"gc,omitempty"`
+	STS                         STSOptions        `json:"sts,omitempty"`
+	S3                          S3Options         `json:"s3,omitempty"`
+	Credentials                 CredentialOptions `json:"credentials,omitempty"`
+	Kubernetes                  KubernetesOptions `json:"kubernetes,omitempty"`
+
+	// Backends are additional S3 backend profiles, if no ConfigMap is used
+	Backends map[string]BackendOptions `json:"backends,omitempty"`
+}
+
+type STSOptions struct {
+	Audience string      `json:"audience,omitempty"`
+	Duration v1.Duration `json:"duration,omitempty"`
+}
+
+// TracingOptions configure the OpenTelemetry export, tracing is disabled without an exporter.
+type TracingOptions struct {
+	Exporter    string  `json:"exporter,omitempty"`
+	Endpoint    string  `json:"endpoint,omitempty"`
+	Insecure    bool    `json:"insecure,omitempty"`
+	SampleRatio float64 `json:"sampleRatio,omitempty"`
+}
+
+// TopologyOptions enable zone-local backends, see TopologyConfig.
+type TopologyOptions struct {
+	Key  string `json:"key,omitempty"`
+	Zone string `json:"zone,omitempty"`
+}
+
+// ResilienceOptions configure retries, timeouts and the circuit breaker of the S3 API calls.
+type ResilienceOptions struct {
+	MaxAttempts         int         `json:"maxAttempts,omitempty"`
+	InitialBackoff      v1.Duration `json:"initialBackoff,omitempty"`
+	MaxBackoff          v1.Duration `json:"maxBackoff,omitempty"`
+	OperationTimeout    v1.Duration `json:"operationTimeout,omitempty"`
+	BreakerThreshold    int         `json:"breakerThreshold,omitempty"`
+	BreakerOpenDuration v1.Duration `json:"breakerOpenDuration,omitempty"`
+}
+
+func (r ResilienceOptions) config() store.ResilienceConfig {
+	return store.ResilienceConfig{
+		MaxAttempts:      r.MaxAttempts,
+		InitialBackoff:   r.InitialBackoff.Duration,
+		MaxBackoff:       r.MaxBackoff.Duration,
+		OperationTimeout: r.OperationTimeout.Duration,
+		FailureThreshold: r.BreakerThreshold,
+		OpenDuration:     r.BreakerOpenDuration.Duration,
+	}
+}
+
+func resilienceOptions(c store.ResilienceConfig) ResilienceOptions {
+	return ResilienceOptions{
+		MaxAttempts:         c.MaxAttempts,
+		InitialBackoff:      v1.Duration{Duration: c.InitialBackoff},
+		MaxBackoff:          v1.Duration{Duration: c.MaxBackoff},
+		OperationTimeout:    v1.Duration{Duration: c.OperationTimeout},
+		BreakerThreshold:    c.FailureThreshold,
+		BreakerOpenDuration: v1.Duration{Duration: c.OpenDuration},
+	}
+}
+
+type S3Options struct {
+	Endpoint     string    `json:"endpoint,omitempty"`
+	Region       string    `json:"region,omitempty"`
+	BucketPrefix string    `json:"bucketPrefix,omitempty"`
+	TLS          TLSConfig `json:"tls,omitempty"`
+
+	AddressingStyle string      `json:"addressingStyle,omitempty"`
+	Proxy           ProxyConfig `json:"proxy,omitempty"`
+	// Zone binds the volumes of the backend to an availability zone
+	Zone string `json:"zone,omitempty"`
+}
+
+// CredentialOptions are used if no Secret is configured. Keys are read from files or the environment,
+// never from flags, so they do not show up in the process list.
+type CredentialOptions struct {
+	AccessKey     string `json:"accessKey,omitempty"`
+	SecretKey     string `json:"secretKey,omitempty"`
+	AccessKeyFile string `json:"accessKeyFile,omitempty"`
+	SecretKeyFile string `json:"secretKeyFile,omitempty"`
+}
+
+// String prints the options with redacted keys, the file paths are kept.
+func (c CredentialOptions) String() string {
+	return fmt.Sprintf("{AccessKey:%s SecretKey:%s AccessKeyFile:%s SecretKeyFile:%s}",
+		logging.Redact(c.AccessKey), logging.Redact(c.SecretKey), c.AccessKeyFile, c.SecretKeyFile)
+}
+
+// LogValue implements slog.LogValuer and redacts the keys.
+func (c CredentialOptions) LogValue() slog.Value {
+	return slog.StringValue(c.String())
+}
+
+// KubernetesOptions reference the ConfigMap and Secret to load the S3 settings from.
+// The Kubernetes API is only used if one of them is set.
+type KubernetesOptions struct {
+	Kubeconfig    string `json:"kubeconfig,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	ConfigMapName string `json:"configMapName,omitempty"`
+	SecretName    string `json:"secretName,omitempty"`
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Mode:           string(ModeAll),
+		Endpoint:       "unix://csi/csi.sock",
+		NodeID:         "controller",
+		MountBinaryS3:  "/usr/local/bin/mount-s3",
+		MountBinary:    "/usr/bin/mount",
+		CredentialsDir: mount.DefaultCredentialsDir,
+		CacheDir:       mount.DefaultCacheDir,
+		HealthChecks:   strings.Join(HealthCheckNames, ","),
+		LogFormat:      logging.FormatText,
+		Resilience:     resilienceOptions(store.DefaultResilienceConfig()),
+		GC:             GCOptions{GracePeriod: v1.Duration{Duration: defaultGCGracePeriod}, Action: "report"},
+		STS: STSOptions{
+			Audience: sts.DefaultAudience,
+			Duration: v1.Duration{Duration: sts.DefaultDuration},
+		},
+	}
+}
+
+// BindFlags registers the flags of o on fs, the current values are used as defaults.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.ConfigFile, "config", o.ConfigFile, "path to a YAML config file")
+	fs.StringVar(&o.Mode, "mode", o.Mode, "CSI services to run: controller, node or all")
+	fs.StringVar(&o.Endpoint, "endpoint", o.Endpoint, "CSI endpoint")
+	fs.StringVar(&o.NodeID, "nodeid", o.NodeID, "kubernetes node id")
+	fs.StringVar(&o.MountBinaryS3, "mountBinaryS3", o.MountBinaryS3, "s3 mount binary path")
+	fs.StringVar(&o.MountBinary, "mountBinary", o.MountBinary, "unix mount binary path")
+	fs.StringVar(&o.CredentialsDir, "credentialsDir", o.CredentialsDir, "tmpfs directory for per-volume s3 credential files")
+	fs.StringVar(&o.CacheDir, "cacheDir", o.CacheDir, "directory for the per-volume caches of volumes with the mount option cache=disk")
+	fs.StringVar(&o.STS.Audience, "stsAudience", o.STS.Audience, "audience of the service account tokens exchanged via STS")
+	fs.DurationVar(&o.STS.Duration.Duration, "stsDuration", o.STS.Duration.Duration, "requested lifetime of temporary STS credentials")
+	fs.BoolVar(&o.RemountOnCredentialRotation, "remountOnCredentialRotation", o.RemountOnCredentialRotation, "remount staged volumes when the credentials secret is rotated")
+	fs.BoolVar(&o.EphemeralDriverCredentials, "ephemeralDriverCredentials", o.EphemeralDriverCredentials, "mount ephemeral inline volumes without node publish secret with the credentials of the driver")
+	fs.StringVar(&o.MetricsAddress, "metricsAddress", o.MetricsAddress, "listen address of the Prometheus metrics endpoint (e.g. :9808), disabled if empty")
+	fs.StringVar(&o.HealthAddress, "healthAddress", o.HealthAddress, "listen address of the /healthz and /readyz endpoints (e.g. :9810), disabled if empty")
+	fs.StringVar(&o.HealthChecks, "healthChecks", o.HealthChecks, "comma separated health checks run by Probe and /readyz ("+strings.Join(HealthCheckNames, ", ")+")")
+	fs.StringVar(&o.LogFormat, "logFormat", o.LogFormat, "log format, text or json")
+	fs.StringVar(&o.Tracing.Exporter, "tracingExporter", o.Tracing.Exporter, "OpenTelemetry trace exporter, otlp or stdout, disabled if empty")
+	fs.StringVar(&o.Tracing.Endpoint, "tracingEndpoint", o.Tracing.Endpoint, "host:port of the OTLP collector, OTEL_EXPORTER_OTLP_ENDPOINT is used if empty")
+	fs.BoolVar(&o.Tracing.Insecure, "tracingInsecure", o.Tracing.Insecure, "connect to the OTLP collector without TLS")
+	fs.Float64Var(&o.Tracing.SampleRatio, "tracingSampleRatio", o.Tracing.SampleRatio, "ratio of sampled traces, all if 0")
+	fs.IntVar(&o.Resilience.MaxAttempts, "s3MaxAttempts", o.Resilience.MaxAttempts, "attempts per S3 call including the first, transient errors are retried")
+	fs.DurationVar(&o.Resilience.InitialBackoff.Duration, "s3InitialBackoff", o.Resilience.InitialBackoff.Duration, "backoff before the first retry of an S3 call, doubled per attempt")
+	fs.DurationVar(&o.Resilience.MaxBackoff.Duration, "s3MaxBackoff", o.Resilience.MaxBackoff.Duration, "maximum backoff between retries of an S3 call")
+	fs.DurationVar(&o.Resilience.OperationTimeout.Duration, "s3OperationTimeout", o.Resilience.OperationTimeout.Duration, "timeout of a single S3 call attempt, 0 to use the deadline of the request only")
+	fs.IntVar(&o.Resilience.BreakerThreshold, "s3BreakerThreshold", o.Resilience.BreakerThreshold, "consecutive transient S3 failures opening the circuit breaker, 0 disables it")
+	fs.DurationVar(&o.Resilience.BreakerOpenDuration.Duration, "s3BreakerOpenDuration", o.Resilience.BreakerOpenDuration.Duration, "time the circuit breaker rejects S3 calls before probing the backend again")
+	fs.StringVar(&o.Topology.Key, "topologyKey", o.Topology.Key, "node label of the zone (e.g. "+DefaultTopologyKey+"), enables zone-local backends if set")
+	fs.StringVar(&o.Topology.Zone, "nodeZone", o.Topology.Zone, "zone of the node, read from the topologyKey label of the node if empty")
+	fs.DurationVar(&o.GC.Interval.Duration, "gcInterval", o.GC.Interval.Duration, "interval of the garbage collection of orphaned buckets in the controller, disabled if 0")
+	fs.DurationVar(&o.GC.GracePeriod.Duration, "gcGracePeriod", o.GC.GracePeriod.Duration, "time a bucket must be orphaned before it is archived or deleted")
+	fs.StringVar(&o.GC.Action, "gcAction", o.GC.Action, "action on orphaned buckets after the grace period: report, archive or delete")
+	fs.StringVar(&o.S3.Endpoint, "s3Endpoint", o.S3.Endpoint, "URL of the S3 endpoint, if no ConfigMap is used")
+	fs.StringVar(&o.S3.Region, "s3Region", o.S3.Region, "S3 region, if no ConfigMap is used")
+	fs.StringVar(&o.S3.BucketPrefix, "s3BucketPrefix", o.S3.BucketPrefix, "prefix of the bucket names, if no ConfigMap is used")
+	fs.StringVar(&o.S3.AddressingStyle, "s3AddressingStyle", o.S3.AddressingStyle, "bucket addressing style (path, virtual or auto), if no ConfigMap is used")
+	fs.StringVar(&o.S3.Zone, "s3Zone", o.S3.Zone, "availability zone of the S3 endpoint, if no ConfigMap is used")
+	fs.StringVar(&o.S3.TLS.CAFile, "s3CAFile", o.S3.TLS.CAFile, "PEM bundle of CAs trusted for the S3 endpoint, if no ConfigMap is used")
+	fs.StringVar(&o.S3.TLS.CertFile, "s3CertFile", o.S3.TLS.CertFile, "TLS client certificate for the S3 endpoint, if no ConfigMap is used")
+	fs.StringVar(&o.S3.TLS.KeyFile, "s3KeyFile", o.S3.TLS.KeyFile, "TLS client key for the S3 endpoint, if no ConfigMap is used")
+	fs.StringVar(&o.S3.TLS.MinVersion, "s3TLSMinVersion", o.S3.TLS.MinVersion, "minimum TLS version (1.2 or 1.3), if no ConfigMap is used")
+	fs.BoolVar(&o.S3.TLS.InsecureSkipVerify, "s3InsecureSkipVerify", o.S3.TLS.InsecureSkipVerify, "do not verify the certificate of the S3 endpoint (labs only), if no ConfigMap is used")
+	fs.StringVar(&o.Credentials.AccessKeyFile, "accessKeyFile", o.Credentials.AccessKeyFile, "file containing the S3 access key, if no Secret is used")
+	fs.StringVar(&o.Credentials.SecretKeyFile, "secretKeyFile", o.Credentials.SecretKeyFile, "file containing the S3 secret key, if no Secret is used")
+	fs.StringVar(&o.Kubernetes.Kubeconfig, "kubeconfig", o.Kubernetes.Kubeconfig, "path to a kubeconfig, in-cluster config is used if empty")
+	fs.StringVar(&o.Kubernetes.Namespace, "namespace", o.Kubernetes.Namespace, "namespace of the ConfigMap and Secret")
+	fs.StringVar(&o.Kubernetes.ConfigMapName, "configMapName", o.Kubernetes.ConfigMapName, "name of the driver ConfigMap")
+	fs.StringVar(&o.Kubernetes.SecretName, "secretName", o.Kubernetes.SecretName, "name of the credentials Secret")
+}
+
+// LoadFile merges the YAML config file at path into o.
+func (o *Options) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, o); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv merges the environment variables into o.
+func (o *Options) ApplyEnv(lookupEnv func(string) (string, bool)) {
+	set := func(target *string, name string) {
+		if v, ok := lookupEnv(name); ok && v != "" {
+			*target = v
+		}
+	}
+	set(&o.S3.Endpoint, var_endpoint)
+	set(&o.S3.Region, var_region)
+	set(&o.S3.BucketPrefix, var_bucketprefix)
+	set(&o.Credentials.AccessKey, var_accessKey)
+	set(&o.Credentials.SecretKey, var_secretKey)
+	set(&o.Kubernetes.Kubeconfig, var_kubeconfig)
+	set(&o.Kubernetes.Namespace, var_namespace)
+	set(&o.Kubernetes.ConfigMapName, var_configmap_name)
+	set(&o.Kubernetes.SecretName, var_secret_name)
+}
+
+// LoadOptions layers defaults, the config file, the environment and all flags explicitly set on fs.
+// flagValues must be bound to fs with BindFlags.
+func LoadOptions(fs *flag.FlagSet, flagValues *Options, lookupEnv func(string) (string, bool)) (*Options, error) {
+	opts := DefaultOptions()
+
+	opts.ConfigFile = flagValues.ConfigFile
+	if opts.ConfigFile == "" {
+		opts.ConfigFile, _ = lookupEnv(var_config)
+	}
+	if opts.ConfigFile != "" {
+		if err := opts.LoadFile(opts.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	opts.ApplyEnv(lookupEnv)
+
+	overlay := flag.NewFlagSet("overlay", flag.ContinueOnError)
+	opts.BindFlags(overlay)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil || overlay.Lookup(f.Name) == nil {
+			return
+		}
+		err = overlay.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// UsesKubernetes reports whether settings have to be loaded from the Kubernetes API.
+func (o *Options) UsesKubernetes() bool {
+	k := o.Kubernetes
+	return k.ConfigMapName != "" || k.SecretName != "" || k.Kubeconfig != ""
+}
+
+func (c *CredentialOptions) resolve() (*S3Credentials, error) {
+	creds := &S3Credentials{
+		AccessKey: c.AccessKey,
+		SecretKey: c.SecretKey,
+	}
+	if c.AccessKeyFile != "" {
+		v, err := readSecretFile(c.AccessKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		creds.AccessKey = v
+	}
+	if c.SecretKeyFile != "" {
+		v, err := readSecretFile(c.SecretKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		creds.SecretKey = v
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("no credentials configured: set a Secret, %v/%v or accessKeyFile/secretKeyFile", var_accessKey, var_secretKey)
+	}
+	return creds, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
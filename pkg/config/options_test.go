@@ -0,0 +1,228 @@
+package config_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func loadOptions(t *testing.T, args []string, env map[string]string) (*config.Options, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagValues := config.DefaultOptions()
+	flagValues.BindFlags(fs)
+	require.NoError(t, fs.Parse(args))
+	return config.LoadOptions(fs, flagValues, envLookup(env))
+}
+
+func TestLoadOptions_Layering(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+nodeID: from-file
+s3:
+  endpoint: http://file:9000
+  region: file-region
+  bucketPrefix: file
+`)
+
+	opts, err := loadOptions(t, []string{"--config", file, "--s3Region", "flag-region"}, map[string]string{
+		"MINIO_ENDPOINT": "http://env:9000",
+	})
+	require.NoError(t, err)
+
+	// defaults
+	assert.Equal(t, "unix://csi/csi.sock", opts.Endpoint)
+	// file
+	assert.Equal(t, "from-file", opts.NodeID)
+	assert.Equal(t, "file", opts.S3.BucketPrefix)
+	// env overrides file
+	assert.Equal(t, "http://env:9000", opts.S3.Endpoint)
+	// flags override env and file
+	assert.Equal(t, "flag-region", opts.S3.Region)
+}
+
+func TestLoadOptions_ConfigFileFromEnv(t *testing.T) {
+	file := writeFile(t, "config.yaml", "nodeID: from-env-file\n")
+
+	opts, err := loadOptions(t, nil, map[string]string{"CSI_S3_CONFIG": file})
+	require.NoError(t, err)
+	assert.Equal(t, "from-env-file", opts.NodeID)
+}
+
+func TestLoadOptions_UnknownField(t *testing.T) {
+	file := writeFile(t, "config.yaml", "unknown: true\n")
+
+	_, err := loadOptions(t, []string{"--config", file}, nil)
+	assert.Error(t, err)
+}
+
+func TestLoad_WithoutKubernetes(t *testing.T) {
+	secretKeyFile := writeFile(t, "secretkey", "file-secret\n")
+
+	opts, err := loadOptions(t, []string{"--s3Endpoint", "http://localhost:9000", "--secretKeyFile", secretKeyFile}, map[string]string{
+		"MINIO_ACCESSKEY": "env-access",
+	})
+	require.NoError(t, err)
+	assert.False(t, opts.UsesKubernetes())
+
+	cfg, err := config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:9000", cfg.S3.Endpoint)
+	assert.Equal(t, "us-east-1", cfg.S3.Region)
+	assert.Equal(t, "env-access", cfg.S3Credentials.AccessKey)
+	assert.Equal(t, "file-secret", cfg.S3Credentials.SecretKey)
+	assert.Nil(t, cfg.Kube.Client)
+
+	effective := cfg.EffectiveConfig()
+	assert.NotContains(t, effective, "env-access")
+	assert.NotContains(t, effective, "file-secret")
+	assert.Contains(t, effective, "http://localhost:9000")
+}
+
+func TestLoad_MissingCredentials(t *testing.T) {
+	opts, err := loadOptions(t, []string{"--s3Endpoint", "http://localhost:9000"}, nil)
+	require.NoError(t, err)
+
+	_, err = config.Load(context.Background(), opts)
+	assert.Error(t, err)
+}
+
+func TestLoad_BackendsFromConfigFile(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+s3:
+  endpoint: http://localhost:9000
+credentials:
+  accessKey: access
+  secretKey: secret
+backends:
+  archive:
+    endpoint: https://archive.local
+    region: eu-central-1
+    credentials:
+      accessKey: archive-access
+      secretKey: archive-secret
+  shared:
+    endpoint: https://shared.local
+`)
+	opts, err := loadOptions(t, []string{"--config", path}, nil)
+	require.NoError(t, err)
+
+	cfg, err := config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	backends := cfg.Backends()
+	assert.Equal(t, []string{"archive", "default", "shared"}, backends.Names())
+
+	archive, err := backends.Get("archive")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-central-1", archive.S3.Current().Region)
+	accessKey, _, _ := archive.Credentials.Current()
+	assert.Equal(t, "archive-access", accessKey)
+
+	shared, err := backends.Get("shared")
+	require.NoError(t, err)
+	accessKey, _, _ = shared.Credentials.Current()
+	assert.Equal(t, "access", accessKey)
+
+	_, err = backends.Get("unknown")
+	assert.Error(t, err)
+
+	effective := cfg.EffectiveConfig()
+	assert.Contains(t, effective, "https://archive.local")
+	assert.NotContains(t, effective, "archive-secret")
+}
+
+func TestLoad_BackendSecretWithoutKubernetes(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+s3:
+  endpoint: http://localhost:9000
+credentials:
+  accessKey: access
+  secretKey: secret
+backends:
+  fast:
+    endpoint: https://fast.local
+    credentialsSecret: fast-credentials
+`)
+	opts, err := loadOptions(t, []string{"--config", path}, nil)
+	require.NoError(t, err)
+
+	_, err = config.Load(context.Background(), opts)
+	assert.Error(t, err)
+}
+
+func TestLoad_HealthChecks(t *testing.T) {
+	args := []string{"--s3Endpoint", "http://localhost:9000", "--healthChecks", "s3, mountBinary"}
+	opts, err := loadOptions(t, args, map[string]string{"MINIO_ACCESSKEY": "access", "MINIO_SECRETKEY": "secret"})
+	require.NoError(t, err)
+	cfg, err := config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s3", "mountBinary"}, cfg.HealthChecks)
+
+	opts.HealthChecks = "s3,disk"
+	_, err = config.Load(context.Background(), opts)
+	assert.ErrorContains(t, err, `unknown health check "disk"`)
+}
+
+func TestLoad_Resilience(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+resilience:
+  maxAttempts: 2
+  operationTimeout: 3s
+`)
+	args := []string{"--config", path, "--s3Endpoint", "http://localhost:9000", "--s3BreakerThreshold", "0"}
+	opts, err := loadOptions(t, args, map[string]string{"MINIO_ACCESSKEY": "access", "MINIO_SECRETKEY": "secret"})
+	require.NoError(t, err)
+	cfg, err := config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cfg.Resilience.MaxAttempts)
+	assert.Equal(t, 3*time.Second, cfg.Resilience.OperationTimeout)
+	assert.Equal(t, 0, cfg.Resilience.FailureThreshold)
+	assert.Equal(t, store.DefaultMaxBackoff, cfg.Resilience.MaxBackoff)
+
+	opts.Resilience.MaxAttempts = 0
+	_, err = config.Load(context.Background(), opts)
+	assert.ErrorContains(t, err, "maxAttempts must be at least 1")
+}
+
+func TestLoad_Mode(t *testing.T) {
+	// the node works without credentials, the controller does not
+	args := []string{"--s3Endpoint", "http://localhost:9000", "--mode", "node"}
+	opts, err := loadOptions(t, args, nil)
+	require.NoError(t, err)
+	cfg, err := config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, config.ModeNode, cfg.Mode)
+	assert.Equal(t, []string{"fuse", "mountBinary", "mountinfo"}, cfg.HealthChecks)
+
+	opts.Mode = "controller"
+	_, err = config.Load(context.Background(), opts)
+	assert.ErrorContains(t, err, "no credentials configured")
+
+	opts.Credentials = config.CredentialOptions{AccessKey: "access", SecretKey: "secret"}
+	cfg, err = config.Load(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s3"}, cfg.HealthChecks)
+
+	opts.Mode = "worker"
+	_, err = config.Load(context.Background(), opts)
+	assert.ErrorContains(t, err, `unknown mode "worker"`)
+}
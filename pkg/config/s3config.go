@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// bucket names are limited to 63 characters, "<prefix>-pvc-<uuid>" leaves 22 for the prefix
+const maxBucketPrefixLength = 22
+
+var bucketPrefixPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+
+// S3ConfigHolder shares the current S3Config of a backend between the components of the driver.
+type S3ConfigHolder struct {
+	holder[S3Config]
+}
+
+func NewS3ConfigHolder(cfg S3Config) *S3ConfigHolder {
+	h := &S3ConfigHolder{}
+	h.init(cfg)
+	return h
+}
+
+// Current returns the S3Config in use.
+func (h *S3ConfigHolder) Current() S3Config {
+	cfg, _ := h.Get()
+	return cfg
+}
+
+func s3ConfigFromConfigMap(cm *corev1.ConfigMap) (*S3Config, error) {
+	data := cm.Data
+	tlsConfig, err := tlsConfigFromConfigMap(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &S3Config{
+		Endpoint:     data[var_endpoint],
+		Region:       data[var_region],
+		BucketPrefix: data[var_bucketprefix],
+		TLS:          tlsConfig,
+
+		AddressingStyle: data[var_addressing_style],
+		Proxy: ProxyConfig{
+			HTTPProxy:  data[var_http_proxy],
+			HTTPSProxy: data[var_https_proxy],
+			NoProxy:    data[var_no_proxy],
+		},
+		Zone: data[var_zone],
+	}
+	if cfg.Region == "" {
+		klog.InfoS("Region missing in ConfigMap, using default", "key", var_region, "region", defaultRegion)
+		cfg.Region = defaultRegion
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// UseTLS reports whether the endpoint is reached via https.
+func (c *S3Config) UseTLS() bool {
+	u, err := url.Parse(c.Endpoint)
+	return err == nil && u.Scheme == "https"
+}
+
+// Validate checks that the configuration can be used to reach S3 and to name buckets.
+func (c *S3Config) Validate() error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("%v missing in ConfigMap", var_endpoint)
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid %v %q: %w", var_endpoint, c.Endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %v %q: expected http(s)://host[:port]", var_endpoint, c.Endpoint)
+	}
+	if u.Scheme != "https" && c.TLS != (TLSConfig{}) {
+		return fmt.Errorf("TLS settings require an https %v, got %q", var_endpoint, c.Endpoint)
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	switch c.AddressingStyle {
+	case "", AddressingStylePath, AddressingStyleVirtual, AddressingStyleAuto:
+	default:
+		return fmt.Errorf("invalid %v %q: expected path, virtual or auto", var_addressing_style, c.AddressingStyle)
+	}
+	if err := c.Proxy.Validate(); err != nil {
+		return err
+	}
+	if c.BucketPrefix != "" && (len(c.BucketPrefix) > maxBucketPrefixLength || !bucketPrefixPattern.MatchString(c.BucketPrefix)) {
+		return fmt.Errorf("invalid %v %q: lowercase letters, digits, '.' and '-' only, at most %d characters",
+			var_bucketprefix, c.BucketPrefix, maxBucketPrefixLength)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+)
+
+func TestS3Config_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.S3Config
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			cfg:  config.S3Config{Endpoint: "https://minio.example.com:9000", BucketPrefix: "csi-s3-"},
+		},
+		{
+			name:    "endpoint missing",
+			cfg:     config.S3Config{},
+			wantErr: true,
+		},
+		{
+			name:    "endpoint without scheme",
+			cfg:     config.S3Config{Endpoint: "minio:9000"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported scheme",
+			cfg:     config.S3Config{Endpoint: "ftp://minio:9000"},
+			wantErr: true,
+		},
+		{
+			name:    "uppercase bucket prefix",
+			cfg:     config.S3Config{Endpoint: "http://minio:9000", BucketPrefix: "CSI"},
+			wantErr: true,
+		},
+		{
+			name:    "bucket prefix too long",
+			cfg:     config.S3Config{Endpoint: "http://minio:9000", BucketPrefix: "a-very-long-bucket-prefix"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	var_tls_ca_file              = "MINIO_TLS_CA_FILE"
+	var_tls_cert_file            = "MINIO_TLS_CERT_FILE"
+	var_tls_key_file             = "MINIO_TLS_KEY_FILE"
+	var_tls_min_version          = "MINIO_TLS_MIN_VERSION"
+	var_tls_insecure_skip_verify = "MINIO_TLS_INSECURE_SKIP_VERIFY"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig configures the connection to an https endpoint. Certificates are read from files,
+// in Kubernetes usually a ConfigMap or Secret mounted into the driver pods.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of additional CAs trusted for the endpoint
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are a client certificate presented to the endpoint
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// MinVersion is the minimum TLS version, "1.2" (default) or "1.3"
+	MinVersion string `json:"minVersion,omitempty"`
+	// InsecureSkipVerify disables the verification of the server certificate. Only for labs.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+func tlsConfigFromConfigMap(data map[string]string) (TLSConfig, error) {
+	cfg := TLSConfig{
+		CAFile:     data[var_tls_ca_file],
+		CertFile:   data[var_tls_cert_file],
+		KeyFile:    data[var_tls_key_file],
+		MinVersion: data[var_tls_min_version],
+	}
+	if v := data[var_tls_insecure_skip_verify]; v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %v %q: %w", var_tls_insecure_skip_verify, v, err)
+		}
+		cfg.InsecureSkipVerify = insecure
+	}
+	return cfg, nil
+}
+
+// Validate checks the settings without reading the certificate files.
+func (t TLSConfig) Validate() error {
+	if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		return fmt.Errorf("invalid TLS min version %q: expected 1.2 or 1.3", t.MinVersion)
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("TLS client certificate requires both certFile and keyFile")
+	}
+	return nil
+}
+
+// ClientConfig builds the tls.Config for an S3 client.
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if v, ok := tlsVersions[t.MinVersion]; ok {
+		cfg.MinVersion = v
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,89 @@
+package config_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig_Validate(t *testing.T) {
+	assert.NoError(t, config.TLSConfig{}.Validate())
+	assert.NoError(t, config.TLSConfig{MinVersion: "1.3"}.Validate())
+	assert.Error(t, config.TLSConfig{MinVersion: "1.1"}.Validate())
+	assert.Error(t, config.TLSConfig{CertFile: "tls.crt"}.Validate())
+
+	// TLS settings for a plain http endpoint are a misconfiguration
+	cfg := config.S3Config{Endpoint: "http://minio:9000", TLS: config.TLSConfig{CAFile: "ca.crt"}}
+	assert.Error(t, cfg.Validate())
+	assert.False(t, cfg.UseTLS())
+	cfg.Endpoint = "https://minio:9000"
+	assert.NoError(t, cfg.Validate())
+	assert.True(t, cfg.UseTLS())
+}
+
+func TestS3Config_TransportTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// the self signed certificate is not trusted by default
+	transport, err := (&config.S3Config{Endpoint: srv.URL}).Transport()
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(srv.URL)
+	assert.Error(t, err)
+
+	caFile := writeFile(t, "ca.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.TLS.Certificates[0].Certificate[0]})))
+	transport, err = (&config.S3Config{Endpoint: srv.URL, TLS: config.TLSConfig{CAFile: caFile, MinVersion: "1.3"}}).Transport()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+	resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	transport, err = (&config.S3Config{Endpoint: srv.URL, TLS: config.TLSConfig{InsecureSkipVerify: true}}).Transport()
+	require.NoError(t, err)
+	resp, err = (&http.Client{Transport: transport}).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	_, err = (&config.S3Config{Endpoint: srv.URL, TLS: config.TLSConfig{CAFile: writeFile(t, "empty.crt", "")}}).Transport()
+	assert.Error(t, err)
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "minio.internal"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,43 @@
+package config
+
+import "sort"
+
+const (
+	var_zone = "MINIO_ZONE"
+
+	// DefaultTopologyKey is the well-known node label of the availability zone.
+	DefaultTopologyKey = "topology.kubernetes.io/zone"
+)
+
+// TopologyConfig enables VOLUME_ACCESSIBILITY_CONSTRAINTS, topology is disabled without a key.
+type TopologyConfig struct {
+	// Key is the node label and topology segment holding the zone
+	Key string
+	// Zone overrides the zone read from the node label, e.g. for runs outside of a cluster
+	Zone string
+}
+
+// Enabled reports whether volumes are bound to the zone of their backend.
+func (t TopologyConfig) Enabled() bool {
+	return t.Key != ""
+}
+
+// ForZone returns the backend serving zone. If several backends share a zone, the first by name is used.
+func (b *Backends) ForZone(zone string) (*Backend, bool) {
+	if zone == "" {
+		return nil, false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.backends))
+	for name, backend := range b.backends {
+		if backend.S3.Current().Zone == zone {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, false
+	}
+	sort.Strings(names)
+	return b.backends[names[0]], true
+}
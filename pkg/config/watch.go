@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// WatchCredentials keeps holder in sync with the controller Secret until ctx is done.
+// Invalid versions of the Secret are reported and the last valid credentials are kept.
+func WatchCredentials(ctx context.Context, client kubernetes.Interface, namespace, name string, holder *CredentialsHolder) error {
+	klog.InfoS("Watching Secret", "secret", klog.KRef(namespace, name))
+	factory := namedInformerFactory(client, namespace, name)
+	informer := factory.Core().V1().Secrets().Informer()
+
+	update := func(obj any) {
+		sec, ok := obj.(*corev1.Secret)
+		if !ok {
+			return
+		}
+		creds, err := credentialsFromSecret(sec)
+		if err != nil {
+			klog.ErrorS(err, "Ignoring update of Secret", "secret", klog.KRef(namespace, name))
+			return
+		}
+		if holder.Set(*creds) {
+			_, generation := holder.Get()
+			klog.InfoS("Credentials rotated", "secret", klog.KRef(namespace, name), "generation", generation)
+		}
+	}
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj any) { update(obj) },
+		DeleteFunc: func(obj any) {
+			klog.InfoS("Secret deleted, keeping current credentials", "secret", klog.KRef(namespace, name))
+		},
+	}); err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("cannot sync Secret %s/%s", namespace, name)
+	}
+	return nil
+}
+
+// WatchBackends applies changes of the driver ConfigMap to backends until ctx is done.
+// Invalid versions of the ConfigMap are rejected, reported and the last valid config is kept.
+func WatchBackends(ctx context.Context, client kubernetes.Interface, namespace, name string, backends *Backends) error {
+	klog.InfoS("Watching ConfigMap", "configMap", klog.KRef(namespace, name))
+	factory := namedInformerFactory(client, namespace, name)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	defaultSecret := backends.Default().SecretName()
+
+	update := func(obj any) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
+		}
+		specs, err := backendSpecsFromConfigMap(cm, defaultSecret)
+		if err != nil {
+			backends.setLastError(err)
+			klog.ErrorS(err, "Rejecting update of ConfigMap", "configMap", klog.KRef(namespace, name),
+				"resourceVersion", cm.ResourceVersion, "keepingGeneration", backends.Generation())
+			return
+		}
+		if backends.Apply(specs) {
+			klog.InfoS("Config applied", "configMap", klog.KRef(namespace, name), "generation", backends.Generation())
+		}
+	}
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj any) { update(obj) },
+		DeleteFunc: func(obj any) {
+			klog.InfoS("ConfigMap deleted, keeping current config", "configMap", klog.KRef(namespace, name))
+		},
+	}); err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("cannot sync ConfigMap %s/%s", namespace, name)
+	}
+	return nil
+}
+
+// namedInformerFactory returns an informer factory restricted to the single object name in namespace.
+func namedInformerFactory(client kubernetes.Interface, namespace, name string) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *v1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+}
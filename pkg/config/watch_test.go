@@ -0,0 +1,206 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newSecret(accessKey, secretKey string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "csi-s3-secret", Namespace: "csi-s3"},
+		Data: map[string][]byte{
+			"MINIO_ACCESSKEY": []byte(accessKey),
+			"MINIO_SECRETKEY": []byte(secretKey),
+		},
+	}
+}
+
+func TestWatchCredentials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(newSecret("access", "secret"))
+	holder := config.NewCredentialsHolder(config.S3Credentials{AccessKey: "access", SecretKey: "secret"})
+
+	var notified atomic.Uint64
+	holder.OnChange(func(_ config.S3Credentials, generation uint64) { notified.Store(generation) })
+
+	require.NoError(t, config.WatchCredentials(ctx, client, "csi-s3", "csi-s3-secret", holder))
+	creds, generation := holder.Get()
+	assert.Equal(t, "access", creds.AccessKey)
+	assert.Equal(t, uint64(1), generation)
+
+	_, err := client.CoreV1().Secrets("csi-s3").Update(ctx, newSecret("rotated", "rotated-secret"), v1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		creds, _ := holder.Get()
+		return creds.AccessKey == "rotated"
+	}, time.Second, 10*time.Millisecond)
+
+	// invalid secrets keep the last valid credentials
+	_, err = client.CoreV1().Secrets("csi-s3").Update(ctx, newSecret("", ""), v1.UpdateOptions{})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	creds, generation = holder.Get()
+	assert.Equal(t, "rotated", creds.AccessKey)
+	assert.Equal(t, uint64(2), generation)
+	assert.Equal(t, uint64(2), notified.Load())
+}
+
+func TestCredentialsHolder_SetUnchanged(t *testing.T) {
+	holder := config.NewCredentialsHolder(config.S3Credentials{AccessKey: "a", SecretKey: "s"})
+	assert.False(t, holder.Set(config.S3Credentials{AccessKey: "a", SecretKey: "s"}))
+	assert.True(t, holder.Set(config.S3Credentials{AccessKey: "b", SecretKey: "s"}))
+
+	accessKey, _, generation := holder.Current()
+	assert.Equal(t, "b", accessKey)
+	assert.Equal(t, uint64(2), generation)
+}
+
+func newConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "csi-s3-config", Namespace: "csi-s3"},
+		Data:       data,
+	}
+}
+
+func TestWatchBackends(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "https://minio.local",
+	}))
+	backends := config.SingleBackend(config.S3Config{Endpoint: "https://minio.local", Region: "us-east-1"}, config.S3Credentials{})
+	holder := backends.Default().S3
+
+	require.NoError(t, config.WatchBackends(ctx, client, "csi-s3", "csi-s3-config", backends))
+	assert.Equal(t, uint64(1), holder.Generation())
+
+	_, err := client.CoreV1().ConfigMaps("csi-s3").Update(ctx, newConfigMap(map[string]string{
+		"MINIO_ENDPOINT":      "https://minio-2.local",
+		"MINIO_BUCKET_PREFIX": "csi",
+	}), v1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return holder.Current().Endpoint == "https://minio-2.local"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "csi", holder.Current().BucketPrefix)
+	assert.Equal(t, "us-east-1", holder.Current().Region)
+	assert.Equal(t, uint64(2), holder.Generation())
+	assert.NoError(t, backends.LastError())
+
+	// a bad edit is rejected and the last good config is kept
+	_, err = client.CoreV1().ConfigMaps("csi-s3").Update(ctx, newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "minio-3.local",
+	}), v1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return backends.LastError() != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "https://minio-2.local", holder.Current().Endpoint)
+	assert.Equal(t, uint64(2), holder.Generation())
+}
+
+func TestWatchBackends_AddBackend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "https://minio.local",
+	}))
+	backends := config.SingleBackend(config.S3Config{Endpoint: "https://minio.local", Region: "us-east-1"},
+		config.S3Credentials{AccessKey: "access", SecretKey: "secret"})
+
+	var added atomic.Value
+	backends.Each(func(b *config.Backend) { added.Store(b.Name) })
+	require.NoError(t, config.WatchBackends(ctx, client, "csi-s3", "csi-s3-config", backends))
+
+	_, err := client.CoreV1().ConfigMaps("csi-s3").Update(ctx, newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "https://minio.local",
+		"MINIO_BACKENDS": "fast:\n  endpoint: https://minio-fast.local\n  bucketPrefix: fast\n",
+	}), v1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return added.Load() == "fast"
+	}, time.Second, 10*time.Millisecond)
+
+	fast, err := backends.Get("fast")
+	require.NoError(t, err)
+	assert.Equal(t, "https://minio-fast.local", fast.S3.Current().Endpoint)
+	assert.Equal(t, "us-east-1", fast.S3.Current().Region)
+	// without credentialsSecret the credentials of the default backend are used
+	assert.Same(t, backends.Default().Credentials, fast.Credentials)
+	assert.Equal(t, []string{"default", "fast"}, backends.Names())
+
+	// invalid backends reject the whole update
+	_, err = client.CoreV1().ConfigMaps("csi-s3").Update(ctx, newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "https://minio.local",
+		"MINIO_BACKENDS": "Fast_1:\n  endpoint: https://minio-fast.local\n",
+	}), v1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return backends.LastError() != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"default", "fast"}, backends.Names())
+}
+
+func TestWatchBackends_NodeCredentials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "https://minio.local",
+	}))
+	backends := config.SingleBackend(config.S3Config{Endpoint: "https://minio.local", Region: "us-east-1"}, config.S3Credentials{})
+	backends.UseNodeCredentials()
+	require.NoError(t, config.WatchBackends(ctx, client, "csi-s3", "csi-s3-config", backends))
+
+	_, err := client.CoreV1().ConfigMaps("csi-s3").Update(ctx, newConfigMap(map[string]string{
+		"MINIO_ENDPOINT": "https://minio.local",
+		"MINIO_BACKENDS": "fast:\n  endpoint: https://minio-fast.local\n  credentialsSecret: fast-secret\n" +
+			"  nodeCredentialsSecret: fast-node-secret\n",
+	}), v1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := backends.Get("fast")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the nodes never read the Secret of the controller
+	fast, err := backends.Get("fast")
+	require.NoError(t, err)
+	assert.Equal(t, "fast-node-secret", fast.SecretName())
+}
+
+func TestBackends_ListenerErrors(t *testing.T) {
+	backends := config.SingleBackend(config.S3Config{Endpoint: "https://minio.local", Region: "us-east-1"}, config.S3Credentials{})
+	var reported []error
+	backends.OnError(func(err error) { reported = append(reported, err) })
+
+	backends.SetListenerError("fast", "store", errors.New("invalid endpoint"))
+	require.Error(t, backends.LastError())
+	assert.Contains(t, backends.LastError().Error(), "backend fast: store: invalid endpoint")
+
+	// a new config does not hide the failed listener
+	backends.Apply(map[string]config.BackendSpec{
+		config.DefaultBackend: {S3: config.S3Config{Endpoint: "https://minio.local", Region: "us-east-1"}},
+	})
+	assert.Error(t, backends.LastError())
+
+	backends.SetListenerError("fast", "store", nil)
+	assert.NoError(t, backends.LastError())
+	require.Len(t, reported, 2, "only changes are reported")
+	assert.Error(t, reported[0])
+	assert.NoError(t, reported[1])
+}
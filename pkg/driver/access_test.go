@@ -0,0 +1,113 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+)
+
+func TestCreateVolume_AccessPolicy(t *testing.T) {
+	srv, stores := newZonedControllerServer("")
+	bucket := stores.Store("default")
+
+	resp, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, map[string]string{policy.AccessParameterKey: "public-read"}))
+	require.NoError(t, err)
+	assert.Equal(t, "public-read", resp.GetVolume().GetVolumeContext()[policy.AccessParameterKey])
+	bucketPolicy, _ := bucket.Policy("pvc-1")
+	assert.Contains(t, bucketPolicy, `"arn:aws:s3:::pvc-1/*"`)
+
+	_, err = srv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	require.NoError(t, err)
+	_, ok := bucket.Policy("pvc-1")
+	assert.False(t, ok)
+	assert.Equal(t, 1, bucket.PolicyCalls(), "the policy is deleted with the bucket")
+}
+
+func TestDeleteVolume_FailedDeleteRemovesPolicy(t *testing.T) {
+	srv, stores := newZonedControllerServer("")
+	bucket := stores.Store("default")
+
+	resp, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, map[string]string{policy.AccessParameterKey: "public-read"}))
+	require.NoError(t, err)
+
+	bucket.FailDelete(errors.New("bucket not empty"))
+	_, err = srv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.True(t, bucket.HasBucket("pvc-1"))
+	_, ok := bucket.Policy("pvc-1")
+	assert.False(t, ok, "a bucket which cannot be deleted must not stay public")
+}
+
+func TestCreateVolume_AccessPolicyConfigMap(t *testing.T) {
+	srv, stores := newZonedControllerServer("")
+	params := map[string]string{policy.AccessParameterKey: "configMap:assets"}
+
+	_, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, params))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "without the Kubernetes API")
+
+	srv.ConfigMaps = fake.NewClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "assets", Namespace: "csi-s3"},
+		Data:       map[string]string{"policy.json": `{"Statement":[{"Resource":["arn:aws:s3:::${bucket}/*"]}]}`},
+	}).CoreV1().ConfigMaps("csi-s3")
+	_, err = srv.CreateVolume(context.Background(), createVolumeRequest(nil, params))
+	require.NoError(t, err)
+	bucketPolicy, _ := stores.Store("default").Policy("pvc-1")
+	assert.Equal(t, `{"Statement":[{"Resource":["arn:aws:s3:::pvc-1/*"]}]}`, bucketPolicy)
+
+	// the bucket is not created with an unknown ConfigMap
+	req := createVolumeRequest(nil, map[string]string{policy.AccessParameterKey: "configMap:missing"})
+	req.Name = "pvc-2"
+	_, err = srv.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.False(t, stores.Store("default").HasBucket("pvc-2"))
+}
+
+func TestCreateVolume_LongNameMatchesBucketPattern(t *testing.T) {
+	srv, _ := newZonedControllerServer("")
+	req := createVolumeRequest(nil, nil)
+	req.Name = "pvc-" + strings.Repeat("a", 70)
+
+	resp, err := srv.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	bucketName := resp.GetVolume().GetVolumeId()
+	assert.LessOrEqual(t, len(bucketName), 63)
+	matched, err := path.Match(policy.Spec{}.BucketPattern(), bucketName)
+	require.NoError(t, err)
+	assert.True(t, matched, "bucket %s is not covered by the generated policies", bucketName)
+}
+
+func TestCreateVolume_LongNameHash(t *testing.T) {
+	name := "pvc-" + strings.Repeat("A", 70)
+	sum := sha1.Sum([]byte(strings.ToLower(name)))
+	hash := hex.EncodeToString(sum[:])
+	req := createVolumeRequest(nil, nil)
+	req.Name = name
+
+	srv, _ := newZonedControllerServer("")
+	resp, err := srv.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "pvc-"+hash, resp.GetVolume().GetVolumeId())
+
+	// buckets created with the former bare hash are reused by retries
+	srv, stores := newZonedControllerServer("")
+	stores.Store("default").AddBucket(hash, nil)
+	resp, err = srv.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, hash, resp.GetVolume().GetVolumeId())
+	assert.False(t, stores.Store("default").HasBucket("pvc-"+hash))
+}
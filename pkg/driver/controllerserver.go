@@ -22,40 +22,69 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/klog/v2"
 
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/gc"
+	"github.com/smou/k8s-csi-s3/pkg/driver/inflight"
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+	"github.com/smou/k8s-csi-s3/pkg/driver/s3err"
 	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/sts"
+	"github.com/smou/k8s-csi-s3/pkg/driver/svcacct"
+	"github.com/smou/k8s-csi-s3/pkg/driver/volume"
 )
 
 type ControllerServer struct {
 	csi.UnimplementedControllerServer
 
-	Store        store.BucketStore
-	Endpoint     string
-	Region       string
-	BucketPrefix string
+	Stores   StoreProvider
+	Backends *config.Backends
+	// Events reports failures on the PVC, nil without the Kubernetes API
+	Events *events.Recorder
+	// TopologyKey is the segment of the zone in AccessibilityRequirements, topology is ignored if empty
+	TopologyKey string
+	// ServiceAccounts creates the credentials of volumes with perVolumeCredentials, nil without the Kubernetes API
+	ServiceAccounts *svcacct.Manager
+	// ConfigMaps of the driver namespace hold custom bucket policies, nil without the Kubernetes API
+	ConfigMaps typedcorev1.ConfigMapInterface
+	// BucketOwner tags new buckets for the garbage collection, buckets stay untagged if the driver name is empty
+	BucketOwner gc.Owner
+
+	// inFlight rejects concurrent operations on the same volume ID
+	inFlight *inflight.Tracker
+}
+
+// StoreProvider returns the BucketStore of a backend profile.
+type StoreProvider interface {
+	Get(backend string) (store.BucketStore, error)
 }
 
-func NewControllerServer(config *config.DriverConfig, store store.BucketStore) *ControllerServer {
-	klog.Infof("Initializing ControllerServer...")
+func NewControllerServer(config *config.DriverConfig, stores StoreProvider) *ControllerServer {
+	klog.InfoS("Initializing ControllerServer")
 	return &ControllerServer{
-		Store:        store,
-		Endpoint:     config.S3.Endpoint,
-		Region:       config.S3.Region,
-		BucketPrefix: config.S3.BucketPrefix,
+		Stores:      stores,
+		Backends:    config.Backends(),
+		TopologyKey: config.Topology.Key,
+		inFlight:    inflight.NewTracker(),
 	}
 }
 
 func (srv *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).Infof("CreateVolume: called with args %#v", req)
-
+	logger := klog.FromContext(ctx)
 	volumeName := req.GetName()
 	if volumeName == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume name missing")
@@ -65,11 +94,55 @@ func (srv *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVo
 		return nil, status.Error(codes.InvalidArgument, "volume capabilities missing")
 	}
 
+	owner, err := mount.ParseOwnership(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	var prefixes []string
+	for _, c := range req.GetVolumeCapabilities() {
+		opts, err := mount.ParseMountOptions(c.GetMount().GetMountFlags())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		prefixes = append(prefixes, opts.Prefix)
+	}
+	perVolumeCredentials := svcacct.Enabled(req.GetParameters())
+	if perVolumeCredentials && srv.ServiceAccounts == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s needs the Kubernetes API", svcacct.ParameterKey)
+	}
+	if perVolumeCredentials && sts.IsServiceAccountVolume(req.GetParameters()) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s cannot be combined with %s", svcacct.ParameterKey, sts.AuthenticationSourceKey)
+	}
+	access, err := policy.ParseAccess(req.GetParameters()[policy.AccessParameterKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if access.ConfigMap != "" && srv.ConfigMaps == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s needs the Kubernetes API", policy.AccessParameterKey, access.Value)
+	}
+
+	backend, err := srv.selectBackend(req)
+	if err != nil {
+		return nil, err
+	}
+	bucketStore, err := srv.Stores.Get(backend.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	s3Config, generation := backend.S3.Get()
 	capacityBytes := int64(req.GetCapacityRange().GetRequiredBytes())
-	volumeID := sanitizeVolumeID(req.GetName())
-	bucketName := volumeID
-	if srv.BucketPrefix != "" {
-		bucketName = fmt.Sprintf("%s-%s", srv.BucketPrefix, volumeID)
+	bucketName := prefixedBucketName(s3Config.BucketPrefix, sanitizeVolumeID(req.GetName()))
+	if legacyID, ok := legacyVolumeID(req.GetName()); ok {
+		// a retry of a volume whose bucket was created before the hashed names kept the prefix
+		legacyBucket := prefixedBucketName(s3Config.BucketPrefix, legacyID)
+		exists, err := bucketStore.BucketExists(ctx, legacyBucket)
+		if err != nil {
+			return nil, s3err.Status(err, codes.Internal, fmt.Sprintf("failed to look up bucket %s", legacyBucket))
+		}
+		if exists {
+			bucketName = legacyBucket
+		}
 	}
 
 	// Check arguments
@@ -77,10 +150,46 @@ func (srv *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVo
 		return nil, status.Error(codes.InvalidArgument, "Name missing in request")
 	}
 
-	klog.Infof("Got a request to create volume %s", bucketName)
+	release, err := srv.inFlight.Acquire("CreateVolume", volume.NewID(backend.Name, bucketName))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	if err := srv.Store.CreateBucket(ctx, bucketName); err != nil {
-		return nil, fmt.Errorf("failed to create bucket %s: %v", bucketName, err)
+	// rendered before the bucket is created, so an invalid custom policy leaves nothing behind
+	bucketPolicy, err := srv.bucketPolicy(ctx, access, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	logger = logger.WithValues("backend", backend.Name, "bucket", bucketName)
+	logger.Info("Creating volume")
+
+	if err := bucketStore.CreateBucket(ctx, bucketName); err != nil {
+		srv.Events.PVCWarning(req.GetParameters(), events.Reason(err, events.ReasonProvisioningFailed),
+			fmt.Sprintf("Cannot create bucket %s on backend %s: %v", bucketName, backend.Name, err))
+		return nil, s3err.Status(err, codes.Internal, fmt.Sprintf("failed to create bucket %s", bucketName))
+	}
+	srv.tagBucket(ctx, bucketStore, backend.Name, bucketName, volumeName)
+	if !access.Private() {
+		if err := bucketStore.SetBucketPolicy(ctx, bucketName, bucketPolicy); err != nil {
+			srv.Events.PVCWarning(req.GetParameters(), events.Reason(err, events.ReasonProvisioningFailed),
+				fmt.Sprintf("Cannot set policy %s of bucket %s on backend %s: %v", access.Value, bucketName, backend.Name, err))
+			return nil, s3err.Status(err, codes.Internal, fmt.Sprintf("failed to set policy of bucket %s", bucketName))
+		}
+		logger.Info("Bucket policy set", "accessPolicy", access.Value)
+	}
+	if perVolumeCredentials {
+		// a prefix of all capabilities limits the account to it, the mount options of the PV must not change it
+		prefix := prefixes[0]
+		if slices.ContainsFunc(prefixes, func(p string) bool { return p != prefix }) {
+			prefix = ""
+		}
+		if err := srv.ServiceAccounts.Ensure(ctx, backend.Name, bucketName, prefix, volumeName); err != nil {
+			srv.Events.PVCWarning(req.GetParameters(), events.Reason(err, events.ReasonProvisioningFailed),
+				fmt.Sprintf("Cannot create service account of bucket %s on backend %s: %v", bucketName, backend.Name, err))
+			return nil, s3err.Status(err, codes.Internal, fmt.Sprintf("failed to create service account of bucket %s", bucketName))
+		}
 	}
 
 	//TODO handle prefixes
@@ -90,20 +199,79 @@ func (srv *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVo
 	// for k, v := range params {
 	// 	context[k] = v
 	// }
-	klog.V(1).Infof("Volume %s created for region %s with capacity %v", volumeID, srv.Region, capacityBytes)
+	logger.V(1).Info("Volume created", "region", s3Config.Region, "capacity", capacityBytes, "configGeneration", generation)
 	context["capacity"] = fmt.Sprintf("%v", capacityBytes)
-	context["region"] = srv.Region
+	context["region"] = s3Config.Region
+	context[volume.BackendKey] = backend.Name
+	if source := req.GetParameters()[sts.AuthenticationSourceKey]; source != "" {
+		context[sts.AuthenticationSourceKey] = source
+	}
+	owner.Context(context)
+	context[policy.AccessParameterKey] = access.Value
+	if perVolumeCredentials {
+		context[svcacct.ParameterKey] = "true"
+	}
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      bucketName,
-			CapacityBytes: capacityBytes,
-			VolumeContext: context,
+			VolumeId:           volume.NewID(backend.Name, bucketName),
+			CapacityBytes:      capacityBytes,
+			VolumeContext:      context,
+			AccessibleTopology: srv.accessibleTopology(backend),
 		},
 	}, nil
 }
 
+// bucketPolicy renders the bucket policy of access for bucketName, empty for private buckets.
+func (srv *ControllerServer) bucketPolicy(ctx context.Context, access policy.Access, bucketName string) (string, error) {
+	if access.Private() {
+		return "", nil
+	}
+	if access.ConfigMap == "" {
+		return string(access.Document(bucketName).JSON()), nil
+	}
+	cm, err := srv.ConfigMaps.Get(ctx, access.ConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", status.Errorf(codes.InvalidArgument, "ConfigMap %s of %s not found", access.ConfigMap, policy.AccessParameterKey)
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "cannot read ConfigMap %s: %v", access.ConfigMap, err)
+	}
+	raw, ok := cm.Data[policy.ConfigMapKey]
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "ConfigMap %s has no key %s", access.ConfigMap, policy.ConfigMapKey)
+	}
+	doc, err := policy.Custom(raw, bucketName)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "ConfigMap %s: %v", access.ConfigMap, err)
+	}
+	return doc, nil
+}
+
+// tagBucket adds the ownership tags to the bucket, without them the garbage collection never touches it.
+// Provisioning does not fail on errors, e.g. if the policy lacks s3:PutBucketTagging.
+func (srv *ControllerServer) tagBucket(ctx context.Context, s store.BucketStore, backend, bucketName, volumeName string) {
+	if srv.BucketOwner.Driver == "" {
+		return
+	}
+	logger := klog.FromContext(ctx)
+	tags, err := s.BucketTags(ctx, bucketName)
+	if err != nil {
+		logger.Error(err, "Cannot read bucket tags, the bucket is ignored by the garbage collection")
+		return
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	maps.Copy(tags, srv.BucketOwner.Tags(backend, volumeName))
+	// a retried CreateVolume of a bucket found orphaned in between
+	delete(tags, gc.TagOrphanedSince)
+	if err := s.SetBucketTags(ctx, bucketName, tags); err != nil {
+		logger.Error(err, "Cannot tag bucket, the bucket is ignored by the garbage collection")
+	}
+}
+
 func (srv *ControllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.V(4).Infof("DeleteVolume: called with args %#v", req)
+	logger := klog.FromContext(ctx)
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return &csi.DeleteVolumeResponse{}, nil
@@ -114,22 +282,41 @@ func (srv *ControllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVo
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
 
-	klog.Infof("Deleting volume %s", volumeID)
+	release, err := srv.inFlight.Acquire("DeleteVolume", volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	backendName, bucketName := volume.ParseID(volumeID)
+	backend, err := srv.Backends.Get(backendName)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", volumeID, err)
+	}
+	bucketStore, err := srv.Stores.Get(backend.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	logger = logger.WithValues("backend", backend.Name, "bucket", bucketName)
+	logger.Info("Deleting volume")
 
-	if err := srv.Store.DeleteBucket(ctx, volumeID); err != nil && err.Error() != "The specified bucket does not exist" {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Failed to delete bucket %s: %v",
-			volumeID,
-			err,
-		)
+	// revoke first, so the credentials do not outlive the volume if the bucket cannot be deleted
+	if err := srv.ServiceAccounts.Revoke(ctx, backend.Name, bucketName); err != nil {
+		return nil, s3err.Status(err, codes.Internal, fmt.Sprintf("failed to revoke service account of bucket %s", bucketName))
 	}
-	klog.Infof("Bucket %s removed", volumeID)
+	// the policy is deleted with the bucket, a bucket which cannot be deleted must not stay public
+	if err := bucketStore.DeleteBucket(ctx, bucketName); err != nil && !s3err.IsNotFound(err) {
+		if err := bucketStore.SetBucketPolicy(ctx, bucketName, ""); err != nil && !s3err.IsNotFound(err) {
+			logger.V(2).Info("Cannot remove bucket policy", "err", err)
+		}
+		return nil, s3err.Status(err, codes.Internal, fmt.Sprintf("Failed to delete bucket %s", bucketName))
+	}
+	logger.Info("Bucket removed")
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
 func (srv *ControllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("ControllerGetCapabilities: called with args %#v", req)
 	caps := []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
@@ -149,14 +336,17 @@ func (srv *ControllerServer) ControllerGetCapabilities(ctx context.Context, req
 }
 
 func (srv *ControllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	klog.V(4).Infof("ValidateVolumeCapabilities: called with args %#v", req)
-
 	for _, cap := range req.VolumeCapabilities {
 		if cap.GetMount() == nil {
 			return &csi.ValidateVolumeCapabilitiesResponse{
 				Message: "only filesystem volumes supported",
 			}, nil
 		}
+		if _, err := mount.ParseMountOptions(cap.GetMount().GetMountFlags()); err != nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Message: err.Error(),
+			}, nil
+		}
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
@@ -169,9 +359,30 @@ func (srv *ControllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 func sanitizeVolumeID(volumeID string) string {
 	volumeID = strings.ToLower(volumeID)
 	if len(volumeID) > 63 {
-		h := sha1.New()
-		io.WriteString(h, volumeID)
-		volumeID = hex.EncodeToString(h.Sum(nil))
+		// keeps the prefix, so the bucket matches policy.Spec.BucketPattern
+		volumeID = policy.VolumePrefix + "-" + hashVolumeID(volumeID)
 	}
 	return volumeID
 }
+
+// legacyVolumeID returns the former volume ID of names longer than 63 characters, the bare hash.
+func legacyVolumeID(volumeID string) (string, bool) {
+	volumeID = strings.ToLower(volumeID)
+	if len(volumeID) <= 63 {
+		return "", false
+	}
+	return hashVolumeID(volumeID), true
+}
+
+func hashVolumeID(volumeID string) string {
+	h := sha1.New()
+	io.WriteString(h, volumeID)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func prefixedBucketName(bucketPrefix, volumeID string) string {
+	if bucketPrefix == "" {
+		return volumeID
+	}
+	return fmt.Sprintf("%s-%s", bucketPrefix, volumeID)
+}
@@ -25,14 +25,24 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/health"
+	"github.com/smou/k8s-csi-s3/pkg/driver/httpserver"
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"github.com/smou/k8s-csi-s3/pkg/driver/metrics"
 	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
 	"github.com/smou/k8s-csi-s3/pkg/driver/nodeserver"
 	"github.com/smou/k8s-csi-s3/pkg/driver/store"
 	"github.com/smou/k8s-csi-s3/pkg/driver/store/minio"
+	"github.com/smou/k8s-csi-s3/pkg/driver/svcacct"
+	"github.com/smou/k8s-csi-s3/pkg/driver/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -47,7 +57,7 @@ type Driver struct {
 }
 
 func NewDriver(config *config.DriverConfig) (*Driver, error) {
-	klog.Infof("Initializing CSI Driver...")
+	klog.InfoS("Initializing CSI Driver")
 	config.LogVersionInfo()
 
 	if config.Endpoint == "" {
@@ -64,8 +74,8 @@ func NewDriver(config *config.DriverConfig) (*Driver, error) {
 	}, nil
 }
 
-func (d *Driver) Run() error {
-	klog.Infof("Starting CSI driver at %s", d.Config.Endpoint)
+func (d *Driver) Run(ctx context.Context) error {
+	klog.InfoS("Starting CSI driver", "endpoint", d.Config.Endpoint)
 	scheme, addr, err := parseEndpoint(d.Config.Endpoint)
 	if err != nil {
 		return err
@@ -94,7 +104,7 @@ func (d *Driver) Run() error {
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", d.Config.Endpoint, err)
 	}
-	klog.Infof("Listening for connections on address: %#v", listener.Addr())
+	klog.InfoS("Listening for connections", "address", listener.Addr().String())
 	// if scheme == "unix" {
 	// 	// Go's `net` package does not support specifying permissions on Unix sockets it creates.
 	// 	// There are two ways to change permissions:
@@ -110,43 +120,222 @@ func (d *Driver) Run() error {
 	// 		return fmt.Errorf("Failed to change permissions on unix socket %s: %v", addr, err)
 	// 	}
 	// }
-	klog.Infof("Initializing components...")
-	s3Mounter := mount.NewS3MountUtil(d.Config.MountBinaryS3)
-	unixMounter := mount.NewUnixMountUtil(d.Config.MountBinary)
-	store, err := minio.NewStore(&store.StoreConfig{
-		EndpointURL: d.Config.S3.Endpoint,
-		Region:      d.Config.S3.Region,
-		AccessKey:   d.Config.S3Credentials.AccessKey,
-		SecretKey:   d.Config.S3Credentials.SecretKey,
-	})
-	if err != nil {
-		return fmt.Errorf("Error creating BucketStore: %w", err)
+	mode := d.Config.Mode
+	if mode == "" {
+		mode = config.ModeAll
 	}
-	identityServer := NewIdentityServer(d.Config.Meta)
-	controllerServer := NewControllerServer(d.Config, store)
-	nodeServer := nodeserver.NewNodeServer(d.Config, unixMounter, s3Mounter)
-
-	logErr := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		resp, err := handler(ctx, req)
+	klog.InfoS("Initializing components", "mode", mode)
+	backends := d.Config.Backends()
+	var recorder *events.Recorder
+	if kube := d.Config.Kube; kube.Client != nil {
+		recorder = events.NewRecorder(ctx, kube.Client, d.Config.Meta.DriverName, d.Config.NodeID)
+	}
+	// errors of later ConfigMap updates and of backends added at runtime only show up here
+	backends.OnError(func(err error) {
+		metrics.SetConfigError(err)
 		if err != nil {
-			klog.Errorf("GRPC error: %v", err)
+			recorder.DriverEvent(d.Config.Meta.DriverName, corev1.EventTypeWarning, events.ReasonConfigError, err.Error())
+		}
+	})
+	// only the controller talks to the S3 API, the node mounts with mount-s3
+	stores, probes := store.NewPool(), store.NewPool()
+	if mode.Controller() {
+		backends.Each(func(b *config.Backend) {
+			err := addBucketStore(stores, probes, b, d.Config.Resilience)
+			if err != nil {
+				klog.ErrorS(err, "Error creating BucketStore", "backend", b.Name)
+			}
+			backends.SetListenerError(b.Name, "store", err)
+		})
+		if err := backends.LastError(); err != nil {
+			return fmt.Errorf("Error creating BucketStore: %w", err)
+		}
+	}
+	if kube := d.Config.Kube; kube.Client != nil {
+		backends.Each(func(b *config.Backend) {
+			if b.SecretName() == "" {
+				return
+			}
+			err := config.WatchCredentials(ctx, kube.Client, kube.Namespace, b.SecretName(), b.Credentials)
+			if err != nil {
+				klog.ErrorS(err, "Error watching credentials", "backend", b.Name)
+			}
+			backends.SetListenerError(b.Name, "credentials", err)
+		})
+		if err := backends.LastError(); err != nil {
+			return fmt.Errorf("Error watching credentials: %w", err)
+		}
+		if kube.ConfigMapName != "" {
+			if err := config.WatchBackends(ctx, kube.Client, kube.Namespace, kube.ConfigMapName, backends); err != nil {
+				return fmt.Errorf("Error watching config: %w", err)
+			}
 		}
-		return resp, err
 	}
+
+	checker := health.NewChecker(d.healthChecks(backends, probes)...)
+	if err := d.startHTTPServers(ctx, checker); err != nil {
+		return err
+	}
+
+	identityServer := NewIdentityServer(d.Config.Meta, checker, mode.Controller())
+	identityServer.Topology = d.Config.Topology.Enabled()
+
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logErr),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor, tracing.UnaryServerInterceptor(d.Config.NodeID), metrics.UnaryServerInterceptor),
 		grpc.MaxRecvMsgSize(grpcServerMaxReceiveMessageSize),
 	}
 	d.Srv = grpc.NewServer(opts...)
 
 	csi.RegisterIdentityServer(d.Srv, identityServer)
-	csi.RegisterControllerServer(d.Srv, controllerServer)
-	csi.RegisterNodeServer(d.Srv, nodeServer)
+	if mode.Controller() {
+		controllerServer := NewControllerServer(d.Config, stores)
+		controllerServer.Events = recorder
+		if kube := d.Config.Kube; kube.Client != nil && kube.Namespace != "" {
+			controllerServer.ServiceAccounts = &svcacct.Manager{Kube: kube.Client, Namespace: kube.Namespace, Admin: adminClient(backends)}
+			controllerServer.ConfigMaps = kube.Client.CoreV1().ConfigMaps(kube.Namespace)
+		}
+		if err := d.startGC(ctx, controllerServer, backends, stores, recorder); err != nil {
+			return err
+		}
+		csi.RegisterControllerServer(d.Srv, controllerServer)
+	}
+	if mode.Node() {
+		s3Mounter := tracing.InstrumentProvider("S3Mount", mount.NewS3MountUtil(d.Config.MountBinaryS3, d.Config.CredentialsDir, d.Config.CacheDir))
+		unixMounter := tracing.InstrumentProvider("BindMount", mount.NewUnixMountUtil(d.Config.MountBinary))
+		nodeServer := nodeserver.NewNodeServer(d.Config, unixMounter, s3Mounter)
+		nodeServer.Events = recorder
+		csi.RegisterNodeServer(d.Srv, nodeServer)
+	}
 
-	klog.Infof("CSI Driver Ready!")
+	klog.InfoS("CSI Driver ready")
 	return d.Srv.Serve(listener)
 }
 
+// healthChecks builds the configured checks of Probe and /readyz.
+func (d *Driver) healthChecks(backends *config.Backends, stores health.StoreProvider) []health.Check {
+	var checks []health.Check
+	for _, name := range d.Config.HealthChecks {
+		switch name {
+		case health.CheckS3:
+			checks = append(checks, health.S3(backends.Names, stores))
+		case health.CheckFUSE:
+			checks = append(checks, health.FUSE(health.FUSEDevice))
+		case health.CheckMountBinary:
+			checks = append(checks, health.MountBinary(d.Config.MountBinaryS3, d.Config.MountBinary))
+		case health.CheckMountinfo:
+			checks = append(checks, health.Mountinfo(health.MountinfoPath))
+		}
+	}
+	return checks
+}
+
+// startHTTPServers serves the metrics and health endpoints, they share a listener if the addresses are equal.
+func (d *Driver) startHTTPServers(ctx context.Context, checker *health.Checker) error {
+	servers := map[string]*httpserver.Server{}
+	serverFor := func(address string) *httpserver.Server {
+		if _, ok := servers[address]; !ok {
+			servers[address] = httpserver.New(address)
+		}
+		return servers[address]
+	}
+	if address := d.Config.MetricsAddress; address != "" {
+		serverFor(address).Handle("/metrics", metrics.Handler())
+	}
+	if address := d.Config.HealthAddress; address != "" {
+		srv := serverFor(address)
+		srv.Handle("/healthz", checker.LivenessHandler())
+		srv.Handle("/readyz", checker.ReadinessHandler())
+	}
+	for address, srv := range servers {
+		if err := srv.Start(ctx); err != nil {
+			return fmt.Errorf("Error starting HTTP server on %s: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// newBucketStore returns the BucketStore of a backend and the store probed by the health check.
+func newBucketStore(backend string, cfg config.S3Config, credentials store.CredentialsSource, resilience store.ResilienceConfig) (store.BucketStore, store.BucketStore, error) {
+	transport, err := cfg.Transport()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := minio.NewStore(&store.StoreConfig{
+		EndpointURL:     cfg.Endpoint,
+		Region:          cfg.Region,
+		Credentials:     credentials,
+		Transport:       transport,
+		AddressingStyle: cfg.AddressingStyleOrDefault(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// the health check probes without retries, its failures must not open the circuit breaker
+	probe := metrics.InstrumentBucketStore(backend, s)
+	// metrics count every attempt, the span covers the call including its retries
+	resilient := store.NewResilientStore(backend, probe, resilience)
+	return tracing.InstrumentBucketStore(backend, resilient), probe, nil
+}
+
+// adminClient returns a MinIO admin client of a backend with the current settings and credentials of the controller.
+func adminClient(backends *config.Backends) func(string) (svcacct.Admin, error) {
+	return func(name string) (svcacct.Admin, error) {
+		b, err := backends.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		cfg := b.S3.Current()
+		transport, err := cfg.Transport()
+		if err != nil {
+			return nil, err
+		}
+		return minio.NewAdminClient(&store.StoreConfig{
+			EndpointURL: cfg.Endpoint,
+			Region:      cfg.Region,
+			Credentials: b.Credentials,
+			Transport:   transport,
+		})
+	}
+}
+
+// addBucketStore adds the BucketStore of backend b to stores and its health probe to probes, and rebuilds
+// both when the backend changes.
+func addBucketStore(stores, probes *store.Pool, b *config.Backend, resilience store.ResilienceConfig) error {
+	cfg := b.S3.Current()
+	s, probe, err := newBucketStore(b.Name, cfg, b.Credentials, resilience)
+	if err != nil {
+		return err
+	}
+	target, probeTarget := stores.Add(b.Name, s), probes.Add(b.Name, probe)
+	b.S3.OnChange(rebuildBucketStoreOnChange(b.Name, cfg, target, probeTarget, b.Credentials, resilience))
+	return nil
+}
+
+// rebuildBucketStoreOnChange swaps the BucketStore if the connection settings of the S3Config change.
+func rebuildBucketStoreOnChange(backend string, initial config.S3Config, target, probeTarget *store.SwappableStore, credentials store.CredentialsSource, resilience store.ResilienceConfig) func(config.S3Config, uint64) {
+	var mu sync.Mutex
+	current := initial
+	return func(cfg config.S3Config, generation uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cfg.Endpoint == current.Endpoint && cfg.Region == current.Region && cfg.TLS == current.TLS &&
+			cfg.AddressingStyle == current.AddressingStyle && cfg.Proxy == current.Proxy {
+			current = cfg
+			return
+		}
+		s, probe, err := newBucketStore(backend, cfg, credentials, resilience)
+		if err != nil {
+			klog.ErrorS(err, "Cannot rebuild BucketStore, keeping the current one", "backend", backend, "generation", generation, "endpoint", current.Endpoint)
+			return
+		}
+		target.Swap(s)
+		probeTarget.Swap(probe)
+		current = cfg
+		klog.InfoS("BucketStore switched", "backend", backend, "endpoint", cfg.Endpoint, "generation", generation)
+	}
+}
+
 func (d *Driver) Stop() {
 	if d.Srv != nil {
 		klog.Info("Stopping CSI driver")
@@ -0,0 +1,128 @@
+// Package events records Kubernetes Events on the PVCs and Pods of failed volumes and on the CSIDriver.
+package events
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/s3err"
+)
+
+// Keys set by the external-provisioner (--extra-create-metadata) and by kubelet (podInfoOnMount).
+const (
+	PVCNameKey      = "csi.storage.k8s.io/pvc/name"
+	PVCNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	PodNameKey      = "csi.storage.k8s.io/pod.name"
+	PodNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	PodUIDKey       = "csi.storage.k8s.io/pod.uid"
+)
+
+// Reasons of the Events.
+const (
+	ReasonAccessDenied       = "AccessDenied"
+	ReasonNoSuchBucket       = "NoSuchBucket"
+	ReasonFUSEUnavailable    = "FUSEUnavailable"
+	ReasonQuotaExceeded      = "QuotaExceeded"
+	ReasonProvisioningFailed = "ProvisioningFailed"
+	ReasonMountFailed        = "MountFailed"
+
+	ReasonOrphanedBucket         = "OrphanedBucket"
+	ReasonOrphanedBucketDeleted  = "OrphanedBucketDeleted"
+	ReasonOrphanedBucketArchived = "OrphanedBucketArchived"
+
+	ReasonConfigError = "ConfigError"
+)
+
+// Events of one object are rate limited to a burst of eventBurst, then one every 1/eventQPS seconds.
+const (
+	eventBurst = 5
+	eventQPS   = 1.0 / 60
+)
+
+// Recorder records Events, a nil Recorder drops them. This allows running without the Kubernetes API.
+type Recorder struct {
+	recorder record.EventRecorder
+}
+
+// NewRecorder sends Events of component through client until ctx is done.
+func NewRecorder(ctx context.Context, client kubernetes.Interface, component, host string) *Recorder {
+	broadcaster := record.NewBroadcaster(
+		record.WithContext(ctx),
+		record.WithCorrelatorOptions(record.CorrelatorOptions{BurstSize: eventBurst, QPS: eventQPS}),
+	)
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return NewRecorderFor(broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component, Host: host}))
+}
+
+// NewRecorderFor wraps an EventRecorder, e.g. a record.FakeRecorder in tests.
+func NewRecorderFor(recorder record.EventRecorder) *Recorder {
+	return &Recorder{recorder: recorder}
+}
+
+// PVCWarning records a warning on the PVC named in the CreateVolume parameters.
+func (r *Recorder) PVCWarning(parameters map[string]string, reason, message string) {
+	name, namespace := parameters[PVCNameKey], parameters[PVCNamespaceKey]
+	if r == nil || name == "" || namespace == "" {
+		return
+	}
+	r.recorder.Event(&v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Namespace:  namespace,
+		Name:       name,
+	}, v1.EventTypeWarning, reason, message)
+}
+
+// PodWarning records a warning on the Pod named in the volume context of NodePublishVolume.
+func (r *Recorder) PodWarning(volumeContext map[string]string, reason, message string) {
+	name, namespace := volumeContext[PodNameKey], volumeContext[PodNamespaceKey]
+	if r == nil || name == "" || namespace == "" {
+		return
+	}
+	r.recorder.Event(&v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  namespace,
+		Name:       name,
+		UID:        types.UID(volumeContext[PodUIDKey]),
+	}, v1.EventTypeWarning, reason, message)
+}
+
+// DriverEvent records an Event on the CSIDriver object, for buckets which belong to no PersistentVolume
+// and for errors of the driver config.
+func (r *Recorder) DriverEvent(driver, eventType, reason, message string) {
+	if r == nil || driver == "" {
+		return
+	}
+	r.recorder.Event(&v1.ObjectReference{
+		APIVersion: "storage.k8s.io/v1",
+		Kind:       "CSIDriver",
+		Name:       driver,
+	}, eventType, reason, message)
+}
+
+// Reason derives a human readable reason from the error of the S3 API or mount-s3, fallback otherwise.
+func Reason(err error, fallback string) string {
+	if err == nil {
+		return fallback
+	}
+	switch s3err.Classify(err) {
+	case s3err.AccessDenied:
+		return ReasonAccessDenied
+	case s3err.NotFound:
+		return ReasonNoSuchBucket
+	case s3err.FUSEUnavailable:
+		return ReasonFUSEUnavailable
+	case s3err.QuotaExceeded:
+		return ReasonQuotaExceeded
+	default:
+		return fallback
+	}
+}
@@ -0,0 +1,41 @@
+package events_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReason(t *testing.T) {
+	for err, reason := range map[error]string{
+		errors.New("Access Denied."): events.ReasonAccessDenied,
+		errors.New("The Access Key Id you provided does not exist (InvalidAccessKeyId)"): events.ReasonAccessDenied,
+		errors.New("The specified bucket does not exist"):                                events.ReasonNoSuchBucket,
+		errors.New("mount failed: fuse: device not found, try 'modprobe fuse'"):          events.ReasonFUSEUnavailable,
+		errors.New("Bucket quota exceeded (XMinioAdminBucketQuotaExceeded)"):             events.ReasonQuotaExceeded,
+		errors.New("connection refused"):                                                 events.ReasonMountFailed,
+		nil:                                                                              events.ReasonMountFailed,
+	} {
+		assert.Equal(t, reason, events.Reason(err, events.ReasonMountFailed), "%v", err)
+	}
+}
+
+func TestPVCWarning(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := events.NewRecorderFor(recorder)
+
+	r.PVCWarning(map[string]string{events.PVCNameKey: "data", events.PVCNamespaceKey: "default"}, events.ReasonAccessDenied, "denied")
+	r.PVCWarning(map[string]string{}, events.ReasonAccessDenied, "without extra-create-metadata")
+
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning AccessDenied denied", <-recorder.Events)
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *events.Recorder
+	r.PodWarning(map[string]string{events.PodNameKey: "app", events.PodNamespaceKey: "default"}, events.ReasonMountFailed, "dropped")
+}
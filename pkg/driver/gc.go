@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/gc"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"k8s.io/klog/v2"
+)
+
+// gcConfig converts and validates the garbage collection settings.
+func (d *Driver) gcConfig() (gc.Config, error) {
+	c := gc.Config{
+		Interval:    d.Config.GC.Interval,
+		GracePeriod: d.Config.GC.GracePeriod,
+		Action:      gc.Action(d.Config.GC.Action),
+	}
+	return c, c.Validate()
+}
+
+// bucketOwner identifies the buckets of this installation, it needs the Kubernetes API for the cluster ID.
+func (d *Driver) bucketOwner(ctx context.Context) (gc.Owner, error) {
+	kube := d.Config.Kube.Client
+	if kube == nil {
+		return gc.Owner{}, errors.New("the garbage collection needs the Kubernetes API")
+	}
+	cluster, err := gc.ClusterID(ctx, kube)
+	if err != nil {
+		return gc.Owner{}, fmt.Errorf("cannot read cluster ID: %w", err)
+	}
+	return gc.Owner{Driver: d.Config.Meta.DriverName, Cluster: cluster}, nil
+}
+
+func (d *Driver) newCollector(owner gc.Owner, backends *config.Backends, stores gc.StoreProvider, recorder *events.Recorder) (*gc.Collector, error) {
+	cfg, err := d.gcConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &gc.Collector{
+		Owner:          owner,
+		BucketPrefixes: bucketPrefixes(backends),
+		Stores:         stores,
+		Kube:           d.Config.Kube.Client,
+		Events:         recorder,
+		Config:         cfg,
+	}, nil
+}
+
+// CollectOrphans runs the garbage collection once with the configured action, e.g. from a CronJob.
+func (d *Driver) CollectOrphans(ctx context.Context) ([]gc.Orphan, error) {
+	owner, err := d.bucketOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backends := d.Config.Backends()
+	stores, probes := store.NewPool(), store.NewPool()
+	var storeErr error
+	backends.Each(func(b *config.Backend) {
+		if err := addBucketStore(stores, probes, b, d.Config.Resilience); err != nil && storeErr == nil {
+			storeErr = fmt.Errorf("backend %s: %w", b.Name, err)
+		}
+	})
+	if storeErr != nil {
+		return nil, fmt.Errorf("Error creating BucketStore: %w", storeErr)
+	}
+	var recorder *events.Recorder
+	if kube := d.Config.Kube.Client; kube != nil {
+		recorder = events.NewRecorder(ctx, kube, d.Config.Meta.DriverName, d.Config.NodeID)
+	}
+	collector, err := d.newCollector(owner, backends, stores, recorder)
+	if err != nil {
+		return nil, err
+	}
+	return collector.Collect(ctx)
+}
+
+// bucketPrefixes returns the current BucketPrefix of every backend.
+func bucketPrefixes(backends *config.Backends) func() map[string]string {
+	return func() map[string]string {
+		prefixes := map[string]string{}
+		// not Each, which would register a listener on every run
+		for _, name := range backends.Names() {
+			if b, err := backends.Get(name); err == nil {
+				prefixes[name] = b.S3.Current().BucketPrefix
+			}
+		}
+		return prefixes
+	}
+}
+
+// startGC tags new buckets with their owner and starts the background loop if an interval is configured.
+// Without the cluster ID the buckets stay untagged and the garbage collection is disabled.
+func (d *Driver) startGC(ctx context.Context, srv *ControllerServer, backends *config.Backends, stores gc.StoreProvider, recorder *events.Recorder) error {
+	owner, err := d.bucketOwner(ctx)
+	if err != nil {
+		if d.Config.GC.Interval > 0 {
+			return fmt.Errorf("Error starting garbage collection: %w", err)
+		}
+		klog.InfoS("New buckets are not tagged for the garbage collection", "reason", err.Error())
+		return nil
+	}
+	srv.BucketOwner = owner
+	if d.Config.GC.Interval <= 0 {
+		return nil
+	}
+	collector, err := d.newCollector(owner, backends, stores, recorder)
+	if err != nil {
+		return fmt.Errorf("Error starting garbage collection: %w", err)
+	}
+	go collector.Run(ctx)
+	return nil
+}
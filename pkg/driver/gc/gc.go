@@ -0,0 +1,321 @@
+// Package gc finds buckets of the driver which belong to no PersistentVolume and removes them after a grace period.
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/metrics"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/volume"
+)
+
+// Tags of the buckets created by the driver. S3 tag keys allow letters, digits, spaces and + - = . _ : / @.
+const (
+	TagDriver  = "csi-s3/driver"
+	TagCluster = "csi-s3/cluster"
+	// TagBackend is the backend profile of the bucket, profiles sharing an endpoint leave each other's buckets alone
+	TagBackend = "csi-s3/backend"
+	TagVolume  = "csi-s3/volume"
+	// TagOrphanedSince is set when the garbage collection finds the bucket without PersistentVolume
+	TagOrphanedSince = "csi-s3/orphaned-since"
+	// TagArchived excludes an orphaned bucket from further runs, its data is kept
+	TagArchived = "csi-s3/archived"
+)
+
+// Action is applied to orphaned buckets after the grace period.
+type Action string
+
+const (
+	// ActionReport only reports orphaned buckets through metrics and Events
+	ActionReport Action = "report"
+	// ActionArchive tags orphaned buckets as archived and keeps their data
+	ActionArchive Action = "archive"
+	// ActionDelete deletes orphaned buckets including all objects
+	ActionDelete Action = "delete"
+)
+
+// Actions lists the valid values of Config.Action.
+var Actions = []Action{ActionReport, ActionArchive, ActionDelete}
+
+// Config of the garbage collection.
+type Config struct {
+	// Interval of the background loop, the loop is disabled if zero
+	Interval    time.Duration
+	GracePeriod time.Duration
+	Action      Action
+}
+
+// Validate checks the action and the grace period.
+func (c Config) Validate() error {
+	switch c.Action {
+	case ActionReport, ActionArchive, ActionDelete:
+	default:
+		return fmt.Errorf("invalid gc action %q, must be one of %v", c.Action, Actions)
+	}
+	if c.Interval < 0 || c.GracePeriod < 0 {
+		return errors.New("gc interval and grace period must not be negative")
+	}
+	if c.Action != ActionReport && c.GracePeriod < time.Hour {
+		return fmt.Errorf("gc grace period %v is too short to %s buckets, at least 1h is required", c.GracePeriod, c.Action)
+	}
+	return nil
+}
+
+// Owner identifies the buckets of one driver installation.
+type Owner struct {
+	Driver string
+	// Cluster is the UID of the kube-system namespace, so clusters sharing a backend leave each other's buckets alone
+	Cluster string
+}
+
+// ClusterID returns the UID of the kube-system namespace, which is stable for the lifetime of a cluster.
+func ClusterID(ctx context.Context, client kubernetes.Interface) (string, error) {
+	ns, err := client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return string(ns.UID), nil
+}
+
+// Tags returns the ownership tags of the bucket of volumeName on backend.
+func (o Owner) Tags(backend, volumeName string) map[string]string {
+	tags := map[string]string{TagDriver: o.Driver, TagBackend: backend, TagVolume: volumeName}
+	if o.Cluster != "" {
+		tags[TagCluster] = o.Cluster
+	}
+	return tags
+}
+
+// Owns reports whether tags mark a bucket of this installation on backend. Buckets tagged before the backend
+// was recorded are owned by every backend.
+func (o Owner) Owns(backend string, tags map[string]string) bool {
+	if b := tags[TagBackend]; b != "" && b != backend {
+		return false
+	}
+	return o.Driver != "" && tags[TagDriver] == o.Driver && tags[TagCluster] == o.Cluster
+}
+
+// StoreProvider returns the BucketStore of a backend profile.
+type StoreProvider interface {
+	Get(backend string) (store.BucketStore, error)
+}
+
+// Orphan is a bucket of the driver without PersistentVolume.
+type Orphan struct {
+	Backend string
+	Bucket  string
+	Volume  string
+	Since   time.Time
+	// Action applied in this run, empty while the grace period is running
+	Action Action
+}
+
+// Collector compares the buckets of all backends with the PersistentVolumes of the driver.
+type Collector struct {
+	Owner Owner
+	// BucketPrefixes returns the BucketPrefix of every backend by name
+	BucketPrefixes func() map[string]string
+	Stores         StoreProvider
+	Kube           kubernetes.Interface
+	// Events reports orphaned buckets on the CSIDriver, nil drops them
+	Events *events.Recorder
+	Config Config
+
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// Run collects every Config.Interval until ctx is done.
+func (c *Collector) Run(ctx context.Context) {
+	logger := klog.FromContext(ctx).WithName("gc")
+	ctx = klog.NewContext(ctx, logger)
+	logger.Info("Starting garbage collection of orphaned buckets", "interval", c.Config.Interval,
+		"gracePeriod", c.Config.GracePeriod, "action", c.Config.Action)
+	ticker := time.NewTicker(c.Config.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Collect(ctx); err != nil {
+			logger.Error(err, "Garbage collection failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect runs the garbage collection once on all backends and returns the orphaned buckets.
+func (c *Collector) Collect(ctx context.Context) ([]Orphan, error) {
+	if c.Owner.Driver == "" {
+		return nil, errors.New("driver name missing")
+	}
+	// without the PersistentVolumes every bucket would look orphaned
+	volumes, err := c.persistentVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list PersistentVolumes: %w", err)
+	}
+	var orphans []Orphan
+	var errs []error
+	prefixes := c.BucketPrefixes()
+	for _, name := range slices.Sorted(maps.Keys(prefixes)) {
+		found, err := c.collectBackend(ctx, name, prefixes[name], volumes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", name, err))
+			continue
+		}
+		// deleted and archived buckets are no longer orphans
+		metrics.SetOrphanedBuckets(name, len(found)-countRemoved(found))
+		orphans = append(orphans, found...)
+	}
+	return orphans, errors.Join(errs...)
+}
+
+func (c *Collector) collectBackend(ctx context.Context, backendName, prefix string, volumes persistentVolumes) ([]Orphan, error) {
+	logger := klog.FromContext(ctx).WithValues("backend", backendName)
+	s, err := c.Stores.Get(backendName)
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := s.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Orphan
+	for _, bucket := range buckets {
+		if prefix != "" && !strings.HasPrefix(bucket.Name, prefix+"-") {
+			continue
+		}
+		tags, err := s.BucketTags(ctx, bucket.Name)
+		if err != nil {
+			logger.Error(err, "Cannot read tags", "bucket", bucket.Name)
+			continue
+		}
+		if !c.Owner.Owns(backendName, tags) || tags[TagArchived] != "" {
+			continue
+		}
+		// the volume name catches buckets seen through another backend profile of the same endpoint
+		if volumes.handles[volume.NewID(backendName, bucket.Name)] || volumes.names[tags[TagVolume]] {
+			// the PersistentVolume is back, e.g. restored from a backup
+			if _, ok := tags[TagOrphanedSince]; ok {
+				delete(tags, TagOrphanedSince)
+				if err := s.SetBucketTags(ctx, bucket.Name, tags); err != nil {
+					logger.Error(err, "Cannot reset orphaned tag", "bucket", bucket.Name)
+				}
+			}
+			continue
+		}
+		orphan, err := c.handleOrphan(ctx, s, backendName, bucket.Name, tags)
+		if err != nil {
+			logger.Error(err, "Cannot handle orphaned bucket", "bucket", bucket.Name)
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// handleOrphan marks a newly found orphan and applies the action once the grace period is over.
+func (c *Collector) handleOrphan(ctx context.Context, s store.BucketStore, backend, bucket string, tags map[string]string) (Orphan, error) {
+	logger := klog.FromContext(ctx).WithValues("backend", backend, "bucket", bucket)
+	now := c.now()
+	orphan := Orphan{Backend: backend, Bucket: bucket, Volume: tags[TagVolume], Since: now}
+	since, err := time.Parse(time.RFC3339, tags[TagOrphanedSince])
+	if err != nil {
+		// found for the first time, the grace period starts now
+		tags = maps.Clone(tags)
+		tags[TagOrphanedSince] = now.UTC().Format(time.RFC3339)
+		if err := s.SetBucketTags(ctx, bucket, tags); err != nil {
+			return orphan, fmt.Errorf("cannot tag bucket: %w", err)
+		}
+		logger.Info("Found orphaned bucket", "volume", orphan.Volume)
+		c.Events.DriverEvent(c.Owner.Driver, corev1.EventTypeWarning, events.ReasonOrphanedBucket,
+			fmt.Sprintf("Bucket %s on backend %s belongs to no PersistentVolume (volume %s)", bucket, backend, orphan.Volume))
+		return orphan, nil
+	}
+	orphan.Since = since
+	if c.Config.Action == ActionReport || now.Sub(since) < c.Config.GracePeriod {
+		return orphan, nil
+	}
+
+	switch c.Config.Action {
+	case ActionDelete:
+		if err := s.DeleteBucket(ctx, bucket); err != nil {
+			return orphan, fmt.Errorf("cannot delete bucket: %w", err)
+		}
+		logger.Info("Deleted orphaned bucket", "orphanedSince", since)
+		c.Events.DriverEvent(c.Owner.Driver, corev1.EventTypeNormal, events.ReasonOrphanedBucketDeleted,
+			fmt.Sprintf("Deleted bucket %s on backend %s, orphaned since %s", bucket, backend, since.Format(time.RFC3339)))
+	case ActionArchive:
+		tags = maps.Clone(tags)
+		tags[TagArchived] = now.UTC().Format(time.RFC3339)
+		if err := s.SetBucketTags(ctx, bucket, tags); err != nil {
+			return orphan, fmt.Errorf("cannot archive bucket: %w", err)
+		}
+		logger.Info("Archived orphaned bucket", "orphanedSince", since)
+		c.Events.DriverEvent(c.Owner.Driver, corev1.EventTypeNormal, events.ReasonOrphanedBucketArchived,
+			fmt.Sprintf("Archived bucket %s on backend %s, orphaned since %s", bucket, backend, since.Format(time.RFC3339)))
+	}
+	orphan.Action = c.Config.Action
+	metrics.OrphanedBucketRemoved(backend, string(c.Config.Action))
+	return orphan, nil
+}
+
+// persistentVolumes are the volume IDs and names of the PersistentVolumes of the driver.
+type persistentVolumes struct {
+	handles map[string]bool
+	names   map[string]bool
+}
+
+// persistentVolumes returns all PersistentVolumes of the driver, in any phase.
+func (c *Collector) persistentVolumes(ctx context.Context) (persistentVolumes, error) {
+	volumes := persistentVolumes{handles: map[string]bool{}, names: map[string]bool{}}
+	if c.Kube == nil {
+		return volumes, errors.New("kubernetes client missing")
+	}
+	opts := metav1.ListOptions{Limit: 500}
+	for {
+		pvs, err := c.Kube.CoreV1().PersistentVolumes().List(ctx, opts)
+		if err != nil {
+			return volumes, err
+		}
+		for _, pv := range pvs.Items {
+			if csi := pv.Spec.CSI; csi != nil && csi.Driver == c.Owner.Driver {
+				volumes.handles[csi.VolumeHandle] = true
+				volumes.names[pv.Name] = true
+			}
+		}
+		if pvs.Continue == "" {
+			return volumes, nil
+		}
+		opts.Continue = pvs.Continue
+	}
+}
+
+func countRemoved(orphans []Orphan) int {
+	n := 0
+	for _, o := range orphans {
+		if o.Action != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Collector) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,181 @@
+package gc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/gc"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/storetest"
+)
+
+const driverName = "s3.csi.k8s.io"
+
+var owner = gc.Owner{Driver: driverName, Cluster: "cluster-1"}
+
+func persistentVolume(driver, handle string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: handle},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: handle},
+			},
+		},
+	}
+}
+
+func newCollector(s *storetest.Store, action gc.Action, pvs ...*corev1.PersistentVolume) (*gc.Collector, *record.FakeRecorder, *time.Time) {
+	objects := []runtime.Object{}
+	for _, pv := range pvs {
+		objects = append(objects, pv)
+	}
+	stores := storetest.NewStores()
+	stores.Set("default", s)
+	recorder := record.NewFakeRecorder(10)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return &gc.Collector{
+		Owner:          owner,
+		BucketPrefixes: func() map[string]string { return map[string]string{"default": "csi"} },
+		Stores:         stores,
+		Kube:           fake.NewClientset(objects...),
+		Events:         events.NewRecorderFor(recorder),
+		Config:         gc.Config{GracePeriod: 24 * time.Hour, Action: action},
+		Now:            func() time.Time { return now },
+	}, recorder, &now
+}
+
+func TestCollect_ReportsOrphans(t *testing.T) {
+	s := storetest.NewStore()
+	s.AddBucket("csi-pvc-1", owner.Tags("default", "pvc-1"))
+	s.AddBucket("csi-pvc-2", owner.Tags("default", "pvc-2"))
+	// buckets of other installations, without tags or outside of the prefix are ignored
+	s.AddBucket("csi-pvc-3", gc.Owner{Driver: driverName, Cluster: "cluster-2"}.Tags("default", "pvc-3"))
+	s.AddBucket("csi-manual", map[string]string{})
+	s.AddBucket("other-pvc-4", owner.Tags("default", "pvc-4"))
+
+	c, recorder, _ := newCollector(s, gc.ActionDelete,
+		persistentVolume(driverName, "csi-pvc-1"), persistentVolume("other.csi.k8s.io", "csi-pvc-2"))
+	orphans, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, "csi-pvc-2", orphans[0].Bucket)
+	assert.Equal(t, "pvc-2", orphans[0].Volume)
+	assert.Empty(t, orphans[0].Action)
+	assert.Equal(t, "2026-01-01T12:00:00Z", s.Tags("csi-pvc-2")[gc.TagOrphanedSince])
+	assert.Contains(t, <-recorder.Events, events.ReasonOrphanedBucket)
+	assert.Empty(t, s.Deleted())
+}
+
+func TestCollect_DeletesAfterGracePeriod(t *testing.T) {
+	s := storetest.NewStore()
+	s.AddBucket("csi-pvc-1", owner.Tags("default", "pvc-1"))
+	c, recorder, now := newCollector(s, gc.ActionDelete)
+
+	_, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	<-recorder.Events
+
+	*now = now.Add(23 * time.Hour)
+	orphans, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Empty(t, s.Deleted())
+
+	*now = now.Add(time.Hour)
+	orphans, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, gc.ActionDelete, orphans[0].Action)
+	assert.Equal(t, []string{"csi-pvc-1"}, s.Deleted())
+	assert.Contains(t, <-recorder.Events, events.ReasonOrphanedBucketDeleted)
+}
+
+func TestCollect_Archive(t *testing.T) {
+	s := storetest.NewStore()
+	tags := owner.Tags("default", "pvc-1")
+	tags[gc.TagOrphanedSince] = "2025-12-01T00:00:00Z"
+	s.AddBucket("csi-pvc-1", tags)
+	c, _, _ := newCollector(s, gc.ActionArchive)
+
+	orphans, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, gc.ActionArchive, orphans[0].Action)
+	assert.NotEmpty(t, s.Tags("csi-pvc-1")[gc.TagArchived])
+	assert.Empty(t, s.Deleted())
+
+	// archived buckets are left alone
+	orphans, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+}
+
+func TestCollect_ReportOnlyKeepsBuckets(t *testing.T) {
+	s := storetest.NewStore()
+	tags := owner.Tags("default", "pvc-1")
+	tags[gc.TagOrphanedSince] = "2025-01-01T00:00:00Z"
+	s.AddBucket("csi-pvc-1", tags)
+	c, _, _ := newCollector(s, gc.ActionReport)
+
+	orphans, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Empty(t, orphans[0].Action)
+	assert.True(t, s.HasBucket("csi-pvc-1"))
+}
+
+func TestCollect_ResetsRestoredVolume(t *testing.T) {
+	s := storetest.NewStore()
+	tags := owner.Tags("default", "pvc-1")
+	tags[gc.TagOrphanedSince] = "2025-01-01T00:00:00Z"
+	s.AddBucket("csi-pvc-1", tags)
+	c, _, _ := newCollector(s, gc.ActionDelete, persistentVolume(driverName, "csi-pvc-1"))
+
+	orphans, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.NotContains(t, s.Tags("csi-pvc-1"), gc.TagOrphanedSince)
+	assert.Empty(t, s.Deleted())
+}
+
+func TestCollect_SharedEndpoint(t *testing.T) {
+	// two backend profiles on the same MinIO with the same prefix see all buckets
+	s := storetest.NewStore()
+	tags := owner.Tags("fast", "pvc-1")
+	tags[gc.TagOrphanedSince] = "2025-01-01T00:00:00Z"
+	s.AddBucket("csi-pvc-1", tags)
+	// tagged before the backend was recorded
+	legacy := owner.Tags("", "pvc-2")
+	delete(legacy, gc.TagBackend)
+	legacy[gc.TagOrphanedSince] = "2025-01-01T00:00:00Z"
+	s.AddBucket("csi-pvc-2", legacy)
+	pv := persistentVolume(driverName, "fast/csi-pvc-2")
+	pv.Name = "pvc-2"
+	c, _, _ := newCollector(s, gc.ActionDelete, persistentVolume(driverName, "fast/csi-pvc-1"), pv)
+	c.BucketPrefixes = func() map[string]string { return map[string]string{"default": "csi", "fast": "csi"} }
+	stores := storetest.NewStores()
+	stores.Set("default", s)
+	stores.Set("fast", s)
+	c.Stores = stores
+
+	orphans, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.Empty(t, s.Deleted())
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, gc.Config{Action: gc.ActionReport}.Validate())
+	assert.NoError(t, gc.Config{Action: gc.ActionDelete, GracePeriod: 24 * time.Hour}.Validate())
+	assert.Error(t, gc.Config{Action: "purge"}.Validate())
+	assert.Error(t, gc.Config{Action: gc.ActionDelete}.Validate())
+}
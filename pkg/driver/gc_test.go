@@ -0,0 +1,28 @@
+package driver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/gc"
+)
+
+func TestCreateVolume_TagsBucketOwner(t *testing.T) {
+	srv, stores := newZonedControllerServer("")
+	srv.BucketOwner = gc.Owner{Driver: "s3.csi.k8s.io", Cluster: "cluster-1"}
+	bucket := stores.Store("default")
+	bucket.AddBucket("pvc-1", map[string]string{"team": "a", gc.TagOrphanedSince: "2026-01-01T00:00:00Z"})
+
+	_, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"team":        "a",
+		gc.TagDriver:  "s3.csi.k8s.io",
+		gc.TagCluster: "cluster-1",
+		gc.TagBackend: "default",
+		gc.TagVolume:  "pvc-1",
+	}, bucket.Tags("pvc-1"))
+}
@@ -0,0 +1,115 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"google.golang.org/grpc/codes"
+)
+
+// Names of the built-in checks, selected with --healthChecks.
+const (
+	CheckS3          = config.HealthCheckS3
+	CheckFUSE        = config.HealthCheckFUSE
+	CheckMountBinary = config.HealthCheckMountBinary
+	CheckMountinfo   = config.HealthCheckMountinfo
+)
+
+const (
+	// ProbeBucket is looked up to test the S3 connection, it does not have to exist
+	ProbeBucket = "csi-s3-health-probe"
+
+	// FUSEDevice and MountinfoPath are the default paths of the node checks
+	FUSEDevice    = "/dev/fuse"
+	MountinfoPath = "/proc/self/mountinfo"
+)
+
+// StoreProvider returns the BucketStore of a backend.
+type StoreProvider interface {
+	Get(backend string) (store.BucketStore, error)
+}
+
+// S3 checks that every backend is reachable and accepts its credentials. A BucketExists
+// on a bucket which does not exist is answered with 404 for valid and 403 for invalid credentials.
+func S3(backends func() []string, stores StoreProvider) Check {
+	return Check{
+		Name: CheckS3,
+		Code: codes.Unavailable,
+		Run: func(ctx context.Context) error {
+			var errs []error
+			for _, backend := range backends() {
+				s, err := stores.Get(backend)
+				if err == nil {
+					_, err = s.BucketExists(ctx, ProbeBucket)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Errorf("backend %s: %w", backend, err))
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+}
+
+// FUSE checks that the FUSE device at path is available.
+func FUSE(path string) Check {
+	return Check{
+		Name:     CheckFUSE,
+		Code:     codes.FailedPrecondition,
+		Liveness: true,
+		Run: func(ctx context.Context) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeCharDevice == 0 {
+				return fmt.Errorf("%s is not a character device", path)
+			}
+			return nil
+		},
+	}
+}
+
+// MountBinary checks that the mount binaries exist and are executable.
+func MountBinary(paths ...string) Check {
+	return Check{
+		Name:     CheckMountBinary,
+		Code:     codes.FailedPrecondition,
+		Liveness: true,
+		Run: func(ctx context.Context) error {
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				if err != nil {
+					return err
+				}
+				if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+					return fmt.Errorf("%s is not executable", path)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// Mountinfo checks that the mount table at path can be read, it is needed to detect mount points.
+func Mountinfo(path string) Check {
+	return Check{
+		Name:     CheckMountinfo,
+		Code:     codes.FailedPrecondition,
+		Liveness: true,
+		Run: func(ctx context.Context) error {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.ReadAll(f)
+			return err
+		},
+	}
+}
@@ -0,0 +1,148 @@
+// Package health runs the checks behind the CSI Probe and the HTTP health endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultCacheTTL is how long results are reused, kubelet and the sidecars probe every few seconds
+	DefaultCacheTTL = 10 * time.Second
+	// DefaultTimeout bounds a single check
+	DefaultTimeout = 5 * time.Second
+)
+
+// Check is a single health check.
+type Check struct {
+	Name string
+	// Code is returned by Probe if the check fails, FailedPrecondition for local
+	// prerequisites and Unavailable for remote dependencies
+	Code codes.Code
+	// Liveness checks are also run by /healthz, a failure restarts the container.
+	// Checks of remote dependencies should only affect readiness.
+	Liveness bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of a Check.
+type Result struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+
+	code     codes.Code
+	liveness bool
+}
+
+// Report holds the results of all checks of one run.
+type Report struct {
+	Time    time.Time `json:"time"`
+	Results []Result  `json:"results"`
+}
+
+// Checker runs the checks and caches the report for TTL.
+type Checker struct {
+	TTL     time.Duration
+	Timeout time.Duration
+
+	checks []Check
+	now    func() time.Time
+
+	mu     sync.Mutex
+	report *Report
+}
+
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{
+		TTL:     DefaultCacheTTL,
+		Timeout: DefaultTimeout,
+		checks:  checks,
+		now:     time.Now,
+	}
+}
+
+// Run returns the cached report or runs all checks. Concurrent callers wait for the same run.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.report != nil && c.now().Sub(c.report.Time) < c.TTL {
+		return *c.report
+	}
+	report := Report{Time: c.now()}
+	for _, check := range c.checks {
+		res := Result{Name: check.Name, code: check.Code, liveness: check.Liveness}
+		if err := c.run(ctx, check); err != nil {
+			klog.ErrorS(err, "Health check failed", "check", check.Name)
+			res.Error = err.Error()
+		}
+		report.Results = append(report.Results, res)
+	}
+	c.report = &report
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	return check.Run(ctx)
+}
+
+// Err returns a gRPC status error with the code of the first failed check, nil if all passed.
+func (r Report) Err() error {
+	return r.err(false)
+}
+
+// LivenessErr is Err restricted to the liveness checks.
+func (r Report) LivenessErr() error {
+	return r.err(true)
+}
+
+func (r Report) err(livenessOnly bool) error {
+	var failed []error
+	code := codes.OK
+	for _, res := range r.Results {
+		if res.Error == "" || (livenessOnly && !res.liveness) {
+			continue
+		}
+		if code == codes.OK {
+			code = res.code
+		}
+		failed = append(failed, fmt.Errorf("%s: %s", res.Name, res.Error))
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return status.Error(code, errors.Join(failed...).Error())
+}
+
+// LivenessHandler serves /healthz, only the liveness checks must pass.
+func (c *Checker) LivenessHandler() http.Handler {
+	return c.handler(Report.LivenessErr)
+}
+
+// ReadinessHandler serves /readyz, all checks must pass.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return c.handler(Report.Err)
+}
+
+func (c *Checker) handler(evaluate func(Report) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if evaluate(report) != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			klog.ErrorS(err, "Cannot write health report")
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/health"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeStore struct {
+	err   error
+	calls int
+}
+
+func (f *fakeStore) BucketExists(ctx context.Context, name string) (bool, error) {
+	f.calls++
+	return false, f.err
+}
+func (f *fakeStore) CreateBucket(ctx context.Context, name string) error     { return nil }
+func (f *fakeStore) DeleteBucket(ctx context.Context, name string) error     { return nil }
+func (f *fakeStore) ListBuckets(ctx context.Context) ([]store.Bucket, error) { return nil, nil }
+func (f *fakeStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	return nil, nil
+}
+func (f *fakeStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	return nil
+}
+func (f *fakeStore) SetBucketPolicy(ctx context.Context, name, policy string) error { return nil }
+
+type fakeStores map[string]store.BucketStore
+
+func (f fakeStores) Get(backend string) (store.BucketStore, error) {
+	s, ok := f[backend]
+	if !ok {
+		return nil, errors.New("unknown backend")
+	}
+	return s, nil
+}
+
+func countingCheck(name string, code codes.Code, liveness bool, err *error, calls *int) health.Check {
+	return health.Check{
+		Name:     name,
+		Code:     code,
+		Liveness: liveness,
+		Run: func(ctx context.Context) error {
+			*calls++
+			return *err
+		},
+	}
+}
+
+func TestChecker_CachesResults(t *testing.T) {
+	var err error
+	calls := 0
+	c := health.NewChecker(countingCheck("local", codes.FailedPrecondition, true, &err, &calls))
+
+	require.NoError(t, c.Run(context.Background()).Err())
+	err = errors.New("broken")
+	require.NoError(t, c.Run(context.Background()).Err(), "cached result expected")
+	assert.Equal(t, 1, calls)
+
+	c.TTL = 0
+	assert.Equal(t, codes.FailedPrecondition, status.Code(c.Run(context.Background()).Err()))
+	assert.Equal(t, 2, calls)
+}
+
+func TestReport_Codes(t *testing.T) {
+	localErr, remoteErr := error(nil), errors.New("connection refused")
+	var calls int
+	c := health.NewChecker(
+		countingCheck("local", codes.FailedPrecondition, true, &localErr, &calls),
+		countingCheck("remote", codes.Unavailable, false, &remoteErr, &calls),
+	)
+	report := c.Run(context.Background())
+	err := report.Err()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "remote: connection refused")
+	assert.NoError(t, report.LivenessErr(), "remote checks must not fail liveness")
+}
+
+func TestHandlers(t *testing.T) {
+	remoteErr := errors.New("connection refused")
+	var calls int
+	c := health.NewChecker(countingCheck("remote", codes.Unavailable, false, &remoteErr, &calls))
+
+	rec := httptest.NewRecorder()
+	c.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	c.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error":"connection refused"`)
+}
+
+func TestS3Check(t *testing.T) {
+	good, denied := &fakeStore{}, &fakeStore{err: errors.New("Access Denied.")}
+	stores := fakeStores{"default": good, "eu": denied}
+	backends := func() []string { return []string{"default", "eu"} }
+
+	err := health.S3(backends, stores).Run(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backend eu: Access Denied.")
+	assert.NotContains(t, err.Error(), "backend default")
+	assert.Equal(t, 1, good.calls)
+
+	denied.err = nil
+	assert.NoError(t, health.S3(backends, stores).Run(context.Background()))
+}
+
+func TestNodeChecks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("36 35 98:0 / /mnt rw - ext3 /dev/root rw\n"), 0644))
+	binary := filepath.Join(dir, "mount-s3")
+	require.NoError(t, os.WriteFile(binary, nil, 0755))
+
+	ctx := context.Background()
+	assert.ErrorContains(t, health.FUSE(file).Run(ctx), "not a character device")
+	assert.Error(t, health.FUSE(filepath.Join(dir, "missing")).Run(ctx))
+	assert.NoError(t, health.Mountinfo(file).Run(ctx))
+	assert.Error(t, health.Mountinfo(filepath.Join(dir, "missing")).Run(ctx))
+	assert.NoError(t, health.MountBinary(binary).Run(ctx))
+	assert.ErrorContains(t, health.MountBinary(binary, file).Run(ctx), "not executable")
+}
@@ -0,0 +1,60 @@
+// Package httpserver serves the HTTP endpoints of the driver, e.g. metrics and health checks.
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Server is an HTTP listener which is shut down with its context.
+type Server struct {
+	mux *http.ServeMux
+	srv *http.Server
+}
+
+func New(address string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		mux: mux,
+		srv: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Handle registers handler for pattern.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start listens on the address of s and serves until ctx is done.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Serving HTTP", "address", listener.Addr().String())
+	go func() {
+		if err := s.srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.ErrorS(err, "HTTP server failed")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.srv.Shutdown(shutdownCtx); err != nil {
+			klog.ErrorS(err, "HTTP server shutdown failed")
+		}
+	}()
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/health"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	"k8s.io/klog/v2"
 )
@@ -30,13 +31,21 @@ type IdentityServer struct {
 
 	DriverName    string
 	DriverVersion string
+	// Health runs the checks of Probe, the driver is always ready without it
+	Health *health.Checker
+	// ControllerService is announced in GetPluginCapabilities, node plugins leave it unset
+	ControllerService bool
+	// Topology announces VOLUME_ACCESSIBILITY_CONSTRAINTS
+	Topology bool
 }
 
-func NewIdentityServer(meta config.Meta) *IdentityServer {
-	klog.Infof("Initializing IdentityServer...")
+func NewIdentityServer(meta config.Meta, checker *health.Checker, controllerService bool) *IdentityServer {
+	klog.InfoS("Initializing IdentityServer", "controllerService", controllerService)
 	return &IdentityServer{
-		DriverName:    meta.DriverName,
-		DriverVersion: meta.DriverVersion,
+		DriverName:        meta.DriverName,
+		DriverVersion:     meta.DriverVersion,
+		Health:            checker,
+		ControllerService: controllerService,
 	}
 }
 
@@ -50,8 +59,12 @@ func (srv *IdentityServer) GetPluginInfo(ctx context.Context, req *csi.GetPlugin
 }
 
 func (srv *IdentityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	caps := []csi.PluginCapability_Service_Type{
-		csi.PluginCapability_Service_CONTROLLER_SERVICE,
+	var caps []csi.PluginCapability_Service_Type
+	if srv.ControllerService {
+		caps = append(caps, csi.PluginCapability_Service_CONTROLLER_SERVICE)
+	}
+	if srv.Topology {
+		caps = append(caps, csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS)
 	}
 	var capsResponse []*csi.PluginCapability
 	for _, cap := range caps {
@@ -67,7 +80,14 @@ func (srv *IdentityServer) GetPluginCapabilities(ctx context.Context, req *csi.G
 	return &csi.GetPluginCapabilitiesResponse{Capabilities: capsResponse}, nil
 }
 
+// Probe returns FailedPrecondition if a local prerequisite like FUSE is missing and
+// Unavailable if an S3 backend cannot be reached. The results are cached by the Checker.
 func (srv *IdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if srv.Health != nil {
+		if err := srv.Health.Run(ctx).Err(); err != nil {
+			return nil, err
+		}
+	}
 	return &csi.ProbeResponse{
 		Ready: wrapperspb.Bool(true),
 	}, nil
@@ -0,0 +1,46 @@
+// Package inflight rejects concurrent operations on the same volume.
+package inflight
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tracker holds the keys of the operations in progress, e.g. volume IDs or target paths.
+type Tracker struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{keys: make(map[string]struct{})}
+}
+
+// TryAcquire marks key as in progress, false if it already is.
+func (t *Tracker) TryAcquire(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.keys[key]; ok {
+		return false
+	}
+	t.keys[key] = struct{}{}
+	return true
+}
+
+// Release ends the operation on key.
+func (t *Tracker) Release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, key)
+}
+
+// Acquire marks key as in progress and returns the function to release it. A concurrent operation
+// on key gets codes.Aborted, which the CSI sidecars and kubelet retry with backoff.
+func (t *Tracker) Acquire(operation, key string) (func(), error) {
+	if !t.TryAcquire(key) {
+		return nil, status.Errorf(codes.Aborted, "%s: an operation for %s is already in progress", operation, key)
+	}
+	return func() { t.Release(key) }, nil
+}
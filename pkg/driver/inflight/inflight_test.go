@@ -0,0 +1,49 @@
+package inflight_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/inflight"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAcquire(t *testing.T) {
+	tracker := inflight.NewTracker()
+
+	release, err := tracker.Acquire("NodeStageVolume", "vol-1")
+	require.NoError(t, err)
+
+	_, err = tracker.Acquire("NodeStageVolume", "vol-1")
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	other, err := tracker.Acquire("NodeStageVolume", "vol-2")
+	require.NoError(t, err, "other keys are independent")
+	other()
+
+	release()
+	release, err = tracker.Acquire("NodeUnstageVolume", "vol-1")
+	require.NoError(t, err, "key is free again after release")
+	release()
+}
+
+func TestTryAcquire_Concurrent(t *testing.T) {
+	tracker := inflight.NewTracker()
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tracker.TryAcquire("vol") {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), acquired.Load())
+}
@@ -0,0 +1,86 @@
+// Package logging sets up structured logging and keeps secrets out of the logs.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Formats of --logFormat.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Setup switches klog to JSON output if format is FormatJSON. The verbosity is taken from the klog -v flag.
+func Setup(format string) error {
+	switch format {
+	case "", FormatText:
+		return nil
+	case FormatJSON:
+		verbosity := 0
+		if f := flag.Lookup("v"); f != nil {
+			verbosity, _ = strconv.Atoi(f.Value.String())
+		}
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(os.Stderr, obj)
+		}, funcr.Options{
+			LogCaller:    funcr.Error,
+			LogTimestamp: true,
+			Verbosity:    verbosity,
+		}))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q: expected %s or %s", format, FormatText, FormatJSON)
+	}
+}
+
+type volumeIDGetter interface {
+	GetVolumeId() string
+}
+
+type nameGetter interface {
+	GetName() string
+}
+
+// UnaryServerInterceptor attaches a logger with a request ID and the volume of the request to the
+// context, see klog.FromContext. Requests and responses are logged at V(4) without their secrets.
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	method := path.Base(info.FullMethod)
+	logger := klog.FromContext(ctx).WithValues("method", method, "requestID", newRequestID())
+	switch r := req.(type) {
+	case volumeIDGetter:
+		logger = logger.WithValues("volumeID", r.GetVolumeId())
+	case nameGetter:
+		logger = logger.WithValues("volumeName", r.GetName())
+	}
+	ctx = klog.NewContext(ctx, logger)
+
+	logger.V(4).Info("GRPC call", "request", StripSecrets(req))
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if err != nil {
+		logger.Error(err, "GRPC error", "code", status.Code(err).String(), "duration", time.Since(start))
+		return resp, err
+	}
+	logger.V(4).Info("GRPC response", "response", StripSecrets(resp), "duration", time.Since(start))
+	return resp, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
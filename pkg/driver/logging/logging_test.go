@@ -0,0 +1,72 @@
+package logging_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr/funcr"
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+func TestStripSecrets(t *testing.T) {
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "bucket",
+		Secrets:  map[string]string{"accessKey": "AKIA", "secretKey": "s3cr3t"},
+		VolumeContext: map[string]string{
+			"region": "us-east-1",
+			"csi.storage.k8s.io/serviceAccount.tokens": `{"sts.min.io":{"token":"jwt"}}`,
+		},
+	}
+
+	stripped, ok := logging.StripSecrets(req).(*csi.NodeStageVolumeRequest)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"accessKey": logging.Redacted, "secretKey": logging.Redacted}, stripped.Secrets)
+	assert.Equal(t, logging.Redacted, stripped.VolumeContext["csi.storage.k8s.io/serviceAccount.tokens"])
+	assert.Equal(t, "us-east-1", stripped.VolumeContext["region"])
+	assert.Equal(t, "s3cr3t", req.Secrets["secretKey"], "the request itself must not be modified")
+}
+
+func TestStripSecrets_NonProto(t *testing.T) {
+	assert.Equal(t, "plain", logging.StripSecrets("plain"))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var lines []string
+	logger := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{Verbosity: 4})
+	ctx := klog.NewContext(context.Background(), logger)
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "backend/bucket",
+		Secrets:  map[string]string{"secretKey": "s3cr3t"},
+	}
+
+	_, err := logging.UnaryServerInterceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		klog.FromContext(ctx).Info("inside")
+		return nil, status.Error(codes.Internal, "mount failed")
+	})
+	require.Error(t, err)
+
+	output := strings.Join(lines, "\n")
+	assert.NotContains(t, output, "s3cr3t")
+	for _, line := range lines {
+		assert.Contains(t, line, `"volumeID"="backend/bucket"`)
+		assert.Contains(t, line, `"requestID"=`)
+	}
+	assert.Contains(t, output, `"msg"="inside"`)
+	assert.Contains(t, output, `"code"="Internal"`)
+}
+
+func TestSetup(t *testing.T) {
+	assert.NoError(t, logging.Setup(logging.FormatText))
+	assert.Error(t, logging.Setup("xml"))
+}
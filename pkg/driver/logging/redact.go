@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted replaces secrets in log output.
+const Redacted = "<redacted>"
+
+// sensitiveContextKeys are entries of string maps like the volume context which hold secrets
+// without being marked as csi_secret.
+var sensitiveContextKeys = map[string]bool{
+	"csi.storage.k8s.io/serviceAccount.tokens": true,
+}
+
+// Redact returns Redacted for non-empty values, empty values stay visible for debugging.
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// RedactContext returns a copy of a volume context with the values of sensitive keys redacted.
+func RedactContext(context map[string]string) map[string]string {
+	if context == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(context))
+	for k, v := range context {
+		if sensitiveContextKeys[k] {
+			v = Redact(v)
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// StripSecrets returns a copy of a CSI message with all fields marked as csi_secret redacted,
+// e.g. the Secrets map of CreateVolumeRequest and NodeStageVolumeRequest. Other values are returned as is.
+func StripSecrets(msg any) any {
+	m, ok := msg.(proto.Message)
+	if !ok || m == nil {
+		return msg
+	}
+	clone := proto.Clone(m)
+	stripSecrets(clone.ProtoReflect())
+	return clone
+}
+
+func stripSecrets(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case isSecret(fd):
+			redactField(m, fd, v)
+		case fd.IsMap() && fd.MapKey().Kind() == protoreflect.StringKind && fd.MapValue().Kind() == protoreflect.StringKind:
+			entries := v.Map()
+			entries.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				if sensitiveContextKeys[k.String()] {
+					entries.Set(k, protoreflect.ValueOfString(Redacted))
+				}
+				return true
+			})
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				stripSecrets(mv.Message())
+				return true
+			})
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				stripSecrets(list.Get(i).Message())
+			}
+		case fd.Message() != nil && !fd.IsMap() && !fd.IsList():
+			stripSecrets(v.Message())
+		}
+		return true
+	})
+}
+
+func isSecret(fd protoreflect.FieldDescriptor) bool {
+	opts := fd.Options()
+	if opts == nil || !proto.HasExtension(opts, csi.E_CsiSecret) {
+		return false
+	}
+	secret, _ := proto.GetExtension(opts, csi.E_CsiSecret).(bool)
+	return secret
+}
+
+// redactField keeps the keys of a secret map, so the log still shows which secrets were passed.
+func redactField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	if fd.IsMap() && fd.MapValue().Kind() == protoreflect.StringKind {
+		secrets := v.Map()
+		secrets.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			secrets.Set(k, protoreflect.ValueOfString(Redacted))
+			return true
+		})
+		return
+	}
+	if fd.Kind() == protoreflect.StringKind && !fd.IsList() {
+		m.Set(fd, protoreflect.ValueOfString(Redacted))
+		return
+	}
+	m.Clear(fd)
+}
@@ -0,0 +1,174 @@
+// Package metrics exports the Prometheus metrics of the driver.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/smou/k8s-csi-s3/pkg/driver/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "csi_s3"
+
+// Operations of the mount metrics.
+const (
+	OperationMount   = "mount"
+	OperationUnmount = "unmount"
+)
+
+// States of the volume gauge.
+const (
+	VolumeStaged    = "staged"
+	VolumePublished = "published"
+)
+
+var (
+	// Registry holds all metrics of the driver.
+	Registry = prometheus.NewRegistry()
+
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "CSI gRPC requests by method.",
+	}, []string{"method"})
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Failed CSI gRPC requests by method and gRPC code.",
+	}, []string{"method", "code"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of CSI gRPC requests by method.",
+		Buckets:   []float64{.005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method"})
+
+	s3Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_request_duration_seconds",
+		Help:      "Latency of S3 API calls of the BucketStore by backend and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+	s3Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_request_errors_total",
+		Help:      "Failed S3 API calls of the BucketStore by backend and operation.",
+	}, []string{"backend", "operation"})
+
+	mountDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mount_duration_seconds",
+		Help:      "Duration of mount-s3 mounts and unmounts by backend.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"backend", "operation"})
+	mountFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mount_failures_total",
+		Help:      "Failed mount-s3 mounts and unmounts by backend.",
+	}, []string{"backend", "operation"})
+
+	volumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "volumes",
+		Help:      "Active volumes on the node by backend and state (staged or published).",
+	}, []string{"node", "backend", "state"})
+
+	orphanedBuckets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orphaned_buckets",
+		Help:      "Buckets of the driver without PersistentVolume found by the last garbage collection by backend.",
+	}, []string{"backend"})
+	orphanedBucketsRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orphaned_buckets_removed_total",
+		Help:      "Orphaned buckets deleted or archived by the garbage collection by backend and action.",
+	}, []string{"backend", "action"})
+
+	configError = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_error",
+		Help:      "1 while the latest config update was rejected or a backend could not be set up, 0 otherwise.",
+	})
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build information of the driver, the value is always 1.",
+	}, []string{"driver_name", "driver_version", "git_commit", "build_date", "go_version", "platform"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		rpcRequests, rpcErrors, rpcDuration,
+		s3Duration, s3Errors,
+		mountDuration, mountFailures,
+		volumes,
+		orphanedBuckets, orphanedBucketsRemoved,
+		configError,
+		buildInfo,
+	)
+	v := version.GetVersion()
+	buildInfo.WithLabelValues(v.DriverName, v.DriverVersion, v.GitCommit, v.BuildDate, v.GoVersion, v.Platform).Set(1)
+}
+
+// Handler serves the metrics of Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// UnaryServerInterceptor counts the CSI requests and measures their latency.
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	method := path.Base(info.FullMethod)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	rpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	rpcRequests.WithLabelValues(method).Inc()
+	if err != nil {
+		rpcErrors.WithLabelValues(method, status.Code(err).String()).Inc()
+	}
+	return resp, err
+}
+
+// ObserveMount records a mount or unmount of a volume of backend which started at start.
+func ObserveMount(backend, operation string, start time.Time, err error) {
+	mountDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		mountFailures.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// SetVolumes replaces the number of active volumes of node in state by backend.
+func SetVolumes(node, state string, counts map[string]int) {
+	volumes.DeletePartialMatch(prometheus.Labels{"node": node, "state": state})
+	for backend, n := range counts {
+		volumes.WithLabelValues(node, backend, state).Set(float64(n))
+	}
+}
+
+// SetOrphanedBuckets sets the number of orphaned buckets of backend.
+func SetOrphanedBuckets(backend string, n int) {
+	orphanedBuckets.WithLabelValues(backend).Set(float64(n))
+}
+
+// OrphanedBucketRemoved counts an orphaned bucket of backend which was deleted or archived.
+func OrphanedBucketRemoved(backend, action string) {
+	orphanedBucketsRemoved.WithLabelValues(backend, action).Inc()
+}
+
+// SetConfigError reports whether the config of the driver is currently failing.
+func SetConfigError(err error) {
+	if err != nil {
+		configError.Set(1)
+	} else {
+		configError.Set(0)
+	}
+}
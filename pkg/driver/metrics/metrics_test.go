@@ -0,0 +1,105 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/smou/k8s-csi-s3/pkg/driver/metrics"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeStore struct {
+	err error
+}
+
+func (f *fakeStore) BucketExists(ctx context.Context, name string) (bool, error) { return true, f.err }
+func (f *fakeStore) CreateBucket(ctx context.Context, name string) error         { return f.err }
+func (f *fakeStore) DeleteBucket(ctx context.Context, name string) error         { return f.err }
+func (f *fakeStore) ListBuckets(ctx context.Context) ([]store.Bucket, error)     { return nil, f.err }
+func (f *fakeStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	return nil, f.err
+}
+func (f *fakeStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	return f.err
+}
+func (f *fakeStore) SetBucketPolicy(ctx context.Context, name, policy string) error { return f.err }
+
+func scrape(t *testing.T) string {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	_, err := metrics.UnaryServerInterceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	_, err = metrics.UnaryServerInterceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.InvalidArgument, "bad")
+	})
+	require.Error(t, err)
+
+	out := scrape(t)
+	assert.Contains(t, out, `csi_s3_rpc_requests_total{method="CreateVolume"} 2`)
+	assert.Contains(t, out, `csi_s3_rpc_errors_total{code="InvalidArgument",method="CreateVolume"} 1`)
+	assert.Contains(t, out, `csi_s3_rpc_duration_seconds_count{method="CreateVolume"} 2`)
+}
+
+func TestInstrumentBucketStore(t *testing.T) {
+	failing := metrics.InstrumentBucketStore("fast", &fakeStore{err: errors.New("AccessDenied")})
+	assert.Error(t, failing.CreateBucket(context.Background(), "bucket"))
+	ok := metrics.InstrumentBucketStore("fast", &fakeStore{})
+	assert.NoError(t, ok.DeleteBucket(context.Background(), "bucket"))
+
+	out := scrape(t)
+	assert.Contains(t, out, `csi_s3_s3_request_errors_total{backend="fast",operation="CreateBucket"} 1`)
+	assert.Contains(t, out, `csi_s3_s3_request_duration_seconds_count{backend="fast",operation="DeleteBucket"} 1`)
+	assert.NotContains(t, out, `csi_s3_s3_request_errors_total{backend="fast",operation="DeleteBucket"}`)
+}
+
+func TestObserveMount(t *testing.T) {
+	metrics.ObserveMount("default", metrics.OperationMount, time.Now(), errors.New("fuse"))
+	metrics.ObserveMount("default", metrics.OperationUnmount, time.Now(), nil)
+
+	out := scrape(t)
+	assert.Contains(t, out, `csi_s3_mount_failures_total{backend="default",operation="mount"} 1`)
+	assert.Contains(t, out, `csi_s3_mount_duration_seconds_count{backend="default",operation="unmount"} 1`)
+}
+
+func TestSetVolumes(t *testing.T) {
+	metrics.SetVolumes("node-1", metrics.VolumeStaged, map[string]int{"default": 2, "fast": 1})
+	metrics.SetVolumes("node-1", metrics.VolumeStaged, map[string]int{"default": 1})
+
+	out := scrape(t)
+	assert.Contains(t, out, `csi_s3_volumes{backend="default",node="node-1",state="staged"} 1`)
+	assert.NotContains(t, out, `backend="fast",node="node-1",state="staged"`)
+}
+
+func TestBuildInfo(t *testing.T) {
+	count, err := testutil.GatherAndCount(metrics.Registry, "csi_s3_build_info")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.True(t, strings.Contains(scrape(t), "csi_s3_build_info{"))
+}
+
+func TestSetConfigError(t *testing.T) {
+	metrics.SetConfigError(errors.New("invalid MINIO_BACKENDS"))
+	assert.Contains(t, scrape(t), "csi_s3_config_error 1")
+
+	metrics.SetConfigError(nil)
+	assert.Contains(t, scrape(t), "csi_s3_config_error 0")
+}
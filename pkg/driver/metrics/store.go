@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+)
+
+// instrumentedStore measures the S3 API calls of a BucketStore.
+type instrumentedStore struct {
+	backend string
+	next    store.BucketStore
+}
+
+// InstrumentBucketStore wraps s to record latency and errors of its calls for backend.
+func InstrumentBucketStore(backend string, s store.BucketStore) store.BucketStore {
+	return &instrumentedStore{backend: backend, next: s}
+}
+
+func (s *instrumentedStore) observe(operation string, start time.Time, err error) {
+	s3Duration.WithLabelValues(s.backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s3Errors.WithLabelValues(s.backend, operation).Inc()
+	}
+}
+
+func (s *instrumentedStore) BucketExists(ctx context.Context, name string) (bool, error) {
+	start := time.Now()
+	exists, err := s.next.BucketExists(ctx, name)
+	s.observe("BucketExists", start, err)
+	return exists, err
+}
+
+func (s *instrumentedStore) CreateBucket(ctx context.Context, name string) error {
+	start := time.Now()
+	err := s.next.CreateBucket(ctx, name)
+	s.observe("CreateBucket", start, err)
+	return err
+}
+
+func (s *instrumentedStore) DeleteBucket(ctx context.Context, name string) error {
+	start := time.Now()
+	err := s.next.DeleteBucket(ctx, name)
+	s.observe("DeleteBucket", start, err)
+	return err
+}
+
+func (s *instrumentedStore) ListBuckets(ctx context.Context) ([]store.Bucket, error) {
+	start := time.Now()
+	buckets, err := s.next.ListBuckets(ctx)
+	s.observe("ListBuckets", start, err)
+	return buckets, err
+}
+
+func (s *instrumentedStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	start := time.Now()
+	tags, err := s.next.BucketTags(ctx, name)
+	s.observe("BucketTags", start, err)
+	return tags, err
+}
+
+func (s *instrumentedStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	start := time.Now()
+	err := s.next.SetBucketTags(ctx, name, tags)
+	s.observe("SetBucketTags", start, err)
+	return err
+}
+
+func (s *instrumentedStore) SetBucketPolicy(ctx context.Context, name, policy string) error {
+	start := time.Now()
+	err := s.next.SetBucketPolicy(ctx, name, policy)
+	s.observe("SetBucketPolicy", start, err)
+	return err
+}
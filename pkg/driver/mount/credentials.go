@@ -0,0 +1,118 @@
+package mount
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultCredentialsDir should be backed by a tmpfs so credentials never hit a disk.
+	DefaultCredentialsDir = "/run/csi-s3/credentials"
+
+	credentialsProfile = "csi-s3"
+	credentialsFile    = "credentials"
+	configFile         = "config"
+)
+
+// CredentialFiles points to the AWS shared credentials and config file of a single mount.
+type CredentialFiles struct {
+	Dir             string
+	CredentialsFile string
+	ConfigFile      string
+	Profile         string
+}
+
+// Env returns the environment which lets mount-s3 pick up the credential files.
+func (f *CredentialFiles) Env() []string {
+	return []string{
+		"AWS_SHARED_CREDENTIALS_FILE=" + f.CredentialsFile,
+		"AWS_CONFIG_FILE=" + f.ConfigFile,
+		"AWS_PROFILE=" + f.Profile,
+	}
+}
+
+// credentialFilesFor returns the location of the credential files for targetPath below baseDir.
+// The directory name is derived from the target path, so Unmount can find it again without
+// any further state.
+func credentialFilesFor(baseDir, targetPath string) *CredentialFiles {
+	sum := sha256.Sum256([]byte(targetPath))
+	dir := filepath.Join(baseDir, hex.EncodeToString(sum[:16]))
+	return &CredentialFiles{
+		Dir:             dir,
+		CredentialsFile: filepath.Join(dir, credentialsFile),
+		ConfigFile:      filepath.Join(dir, configFile),
+		Profile:         credentialsProfile,
+	}
+}
+
+// WriteCredentialFiles writes the credentials of req into a per-mount directory below baseDir.
+// Existing files are replaced atomically.
+func WriteCredentialFiles(baseDir string, req MountRequest) (*CredentialFiles, error) {
+	if req.AccessKey == "" || req.SecretKey == "" {
+		return nil, fmt.Errorf("credentials missing for %s", req.TargetPath)
+	}
+	files := credentialFilesFor(baseDir, req.TargetPath)
+	if err := os.MkdirAll(files.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create credentials dir: %w", err)
+	}
+
+	var creds strings.Builder
+	fmt.Fprintf(&creds, "[%s]\n", files.Profile)
+	fmt.Fprintf(&creds, "aws_access_key_id = %s\n", req.AccessKey)
+	fmt.Fprintf(&creds, "aws_secret_access_key = %s\n", req.SecretKey)
+	if req.SessionToken != "" {
+		fmt.Fprintf(&creds, "aws_session_token = %s\n", req.SessionToken)
+	}
+	if err := writeFileAtomic(files.CredentialsFile, creds.String()); err != nil {
+		return nil, err
+	}
+
+	var cfg strings.Builder
+	fmt.Fprintf(&cfg, "[profile %s]\n", files.Profile)
+	if req.Region != "" {
+		fmt.Fprintf(&cfg, "region = %s\n", req.Region)
+	}
+	if err := writeFileAtomic(files.ConfigFile, cfg.String()); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// HasCredentialFiles reports whether credential files were written for targetPath and not removed yet.
+func HasCredentialFiles(baseDir, targetPath string) bool {
+	_, err := os.Stat(credentialFilesFor(baseDir, targetPath).Dir)
+	return err == nil
+}
+
+// RemoveCredentialFiles deletes the credential files written for targetPath.
+func RemoveCredentialFiles(baseDir, targetPath string) error {
+	files := credentialFilesFor(baseDir, targetPath)
+	if err := os.RemoveAll(files.Dir); err != nil {
+		return fmt.Errorf("cannot remove credentials of %s: %w", targetPath, err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path, content string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,8 +2,12 @@ package mount
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
 )
 
 var ExecCommand = exec.CommandContext
@@ -19,23 +23,69 @@ type Provider interface {
 	IsMounted(targetPath string) (bool, error)
 }
 
+// TargetTracker is implemented by providers which keep the state of their mounts on disk, so it survives
+// a restart of the plugin.
+type TargetTracker interface {
+	// Manages reports whether the provider mounted targetPath and has not unmounted it yet
+	Manages(targetPath string) bool
+}
+
+// CredentialRefresher is implemented by providers which can swap the credentials of an active mount.
+type CredentialRefresher interface {
+	RefreshCredentials(req MountRequest) error
+}
+
 type MountRequest struct {
 	StagingTargetPath string
 	TargetPath        string
 
-	Bucket   string
+	Bucket string
+	// Prefix restricts the mount to the keys below this prefix, it ends with a slash
+	Prefix   string
 	Endpoint string
 	Region   string
+	// CABundle is a PEM file with additional CAs for the endpoint
+	CABundle string
+	// VirtualHostedStyle addresses the bucket as <bucket>.<host> instead of <host>/<bucket>
+	VirtualHostedStyle bool
+
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
 
-	AccessKey string
-	SecretKey string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
 
-	ReadOnly bool
-	GID      string
+	ReadOnly  bool
+	Ownership Ownership
+	Tuning    Tuning
 
 	Options map[string]string
 }
 
+// String prints the request with redacted credentials.
+func (r MountRequest) String() string {
+	return fmt.Sprintf("%+v", r.redacted())
+}
+
+// LogValue implements slog.LogValuer and redacts the credentials.
+func (r MountRequest) LogValue() slog.Value {
+	return slog.AnyValue(r.redacted())
+}
+
+// redactedMountRequest has no methods, so it is printed field by field.
+type redactedMountRequest MountRequest
+
+func (r MountRequest) redacted() redactedMountRequest {
+	r.AccessKey = logging.Redact(r.AccessKey)
+	r.SecretKey = logging.Redact(r.SecretKey)
+	r.SessionToken = logging.Redact(r.SessionToken)
+	// the volume context of workload identity volumes holds the service account tokens of the pod
+	r.Options = logging.RedactContext(r.Options)
+	return redactedMountRequest(r)
+}
+
 func ensureDir(path string) error {
 	return os.MkdirAll(path, 0755)
 }
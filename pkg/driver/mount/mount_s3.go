@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
@@ -13,20 +15,31 @@ type S3MountUtil struct {
 	Mounter mount.Interface
 	// Pfad zum S3-Mount Binary (z. B. mountpoint-s3)
 	Binary string
+	// directory (tmpfs) of the credential files of the mounts
+	CredentialsDir string
+	// directory of the caches of mounts with the mount option cache=disk
+	CacheDir string
 }
 
-func NewS3MountUtil(binary string) *S3MountUtil {
-	klog.Infof("Init S3 Mounter at %s", binary)
+func NewS3MountUtil(binary, credentialsDir, cacheDir string) *S3MountUtil {
+	klog.InfoS("Init S3 Mounter", "binary", binary)
+	if credentialsDir == "" {
+		credentialsDir = DefaultCredentialsDir
+	}
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
 	return &S3MountUtil{
-		Mounter: mount.New(""),
-		Binary:  binary,
+		Mounter:        mount.New(""),
+		Binary:         binary,
+		CredentialsDir: credentialsDir,
+		CacheDir:       cacheDir,
 	}
 }
 
 func (p *S3MountUtil) IsMounted(targetPath string) (bool, error) {
-	klog.V(4).Infof("S3 Mountutil IsMounted: called with targetPath %s", targetPath)
 	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		klog.ErrorS(err, "S3 Mountutil IsMounted: targetPath %s does not exist", targetPath)
+		klog.V(4).InfoS("S3 Mountutil IsMounted: targetPath does not exist", "targetPath", targetPath)
 		return false, nil
 	}
 
@@ -39,7 +52,8 @@ func (p *S3MountUtil) IsMounted(targetPath string) (bool, error) {
 }
 
 func (p *S3MountUtil) Mount(ctx context.Context, req MountRequest) error {
-	klog.V(4).Infof("S3 Mountutil Mount: called with args %+v", req)
+	logger := klog.FromContext(ctx)
+	logger.V(4).Info("S3 Mountutil Mount", "request", req)
 	if err := ensureDir(req.TargetPath); err != nil {
 		return err
 	}
@@ -55,19 +69,25 @@ func (p *S3MountUtil) Mount(ctx context.Context, req MountRequest) error {
 	options := []string{
 		"--endpoint-url", req.Endpoint,
 		"--region", req.Region,
-		"--force-path-style",   // Force path-style addressing
 		"--incremental-upload", // Enable incremental uploads and support for appending to existing objects
 		"--allow-other",        // FUSE option to Allow other users, including root, to access file system
 		"--allow-delete",
 		"--allow-overwrite",
 	}
-	if req.GID != "" {
-		options = append(options,
-			"--gid", req.GID, // Owner GID [default: current user's GID]
-			"--dir-mode", "0775", // Set the permissions for directories (default: 0775)
-			"--file-mode", "0664", // Set the permissions for files (default: 0664)
-		)
+	if !req.VirtualHostedStyle {
+		options = append(options, "--force-path-style") // Force path-style addressing
+	}
+	if req.Prefix != "" {
+		options = append(options, "--prefix", req.Prefix) // Prefix for object keys, must end with a slash
 	}
+	// --uid, --gid, --dir-mode and --file-mode, the defaults of mount-s3 apply without them
+	options = append(options, req.Ownership.args()...)
+	if req.Tuning.Cache {
+		if err := os.MkdirAll(cacheDirFor(p.cacheDir(), req.TargetPath), 0700); err != nil {
+			return fmt.Errorf("cannot create cache dir: %w", err)
+		}
+	}
+	options = append(options, req.Tuning.args(cacheDirFor(p.cacheDir(), req.TargetPath))...)
 	// --allow-delete Allow delete operations on file system
 	// --allow-overwrite Allow overwrite operations on file system
 	if req.ReadOnly {
@@ -78,37 +98,97 @@ func (p *S3MountUtil) Mount(ctx context.Context, req MountRequest) error {
 		req.TargetPath,
 	}
 	options = append(options, args...)
-	klog.Infof("Mount options: %+v", options)
+	logger.Info("Mounting", "binary", p.Binary, "options", options)
+	// credentials are passed in a shared credentials file, so they do not show up in /proc/<pid>/environ
+	files, err := WriteCredentialFiles(p.credentialsDir(), req)
+	if err != nil {
+		return err
+	}
 	cmd := ExecCommand(ctx, p.Binary, options...)
-
-	// Credentials über ENV (best practice)
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", req.AccessKey),
-		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", req.SecretKey),
-	)
+	// the environment of the driver is not inherited on purpose
+	cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, files.Env()...)
+	// mount-s3 supports neither client certificates nor a minimum TLS version, only the CA bundle is passed on
+	if req.CABundle != "" {
+		cmd.Env = append(cmd.Env, "AWS_CA_BUNDLE="+req.CABundle)
+	}
+	cmd.Env = append(cmd.Env, proxyEnv(req)...)
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		if rmErr := RemoveCredentialFiles(p.credentialsDir(), req.TargetPath); rmErr != nil {
+			logger.Error(rmErr, "S3 Mountutil Mount: cannot remove credential files")
+		}
 		return fmt.Errorf(
 			"mount failed: %w output=%s",
 			err,
 			string(out),
 		)
 	}
-	klog.Infof("%s", string(out))
+	logger.Info("Mounted", "targetPath", req.TargetPath, "output", string(out))
 
 	return nil
 }
 
 func (p *S3MountUtil) Unmount(ctx context.Context, targetPath string) error {
-	klog.V(4).Infof("S3 Mountutil Unmount: called with targetPath %s", targetPath)
+	klog.FromContext(ctx).V(4).Info("S3 Mountutil Unmount", "targetPath", targetPath)
 	mounted, err := p.IsMounted(targetPath)
 	if err != nil {
 		return err
 	}
-	if !mounted {
-		return nil
+	if mounted {
+		if err := p.Mounter.Unmount(targetPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(cacheDirFor(p.cacheDir(), targetPath)); err != nil {
+		return fmt.Errorf("cannot remove cache of %s: %w", targetPath, err)
 	}
+	return RemoveCredentialFiles(p.credentialsDir(), targetPath)
+}
+
+// Manages reports whether targetPath has credential files, which are written by Mount and removed by Unmount.
+func (p *S3MountUtil) Manages(targetPath string) bool {
+	return HasCredentialFiles(p.credentialsDir(), targetPath)
+}
+
+// RefreshCredentials rewrites the credential files of the mount at req.TargetPath.
+func (p *S3MountUtil) RefreshCredentials(req MountRequest) error {
+	klog.V(4).InfoS("S3 Mountutil RefreshCredentials", "targetPath", req.TargetPath)
+	_, err := WriteCredentialFiles(p.credentialsDir(), req)
+	return err
+}
+
+// proxyEnv returns the proxy settings in both spellings, since tools differ in which one they read.
+func proxyEnv(req MountRequest) []string {
+	var env []string
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", req.HTTPProxy},
+		{"HTTPS_PROXY", req.HTTPSProxy},
+		{"NO_PROXY", req.NoProxy},
+	} {
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value, strings.ToLower(v.name)+"="+v.value)
+		}
+	}
+	return env
+}
+
+func (p *S3MountUtil) credentialsDir() string {
+	if p.CredentialsDir == "" {
+		return DefaultCredentialsDir
+	}
+	return p.CredentialsDir
+}
+
+func (p *S3MountUtil) cacheDir() string {
+	if p.CacheDir == "" {
+		return DefaultCacheDir
+	}
+	return p.CacheDir
+}
 
-	return p.Mounter.Unmount(targetPath)
+// cacheDirFor returns the cache directory of the mount at targetPath, named like its credentials directory.
+func cacheDirFor(baseDir, targetPath string) string {
+	return filepath.Join(baseDir, filepath.Base(credentialFilesFor(baseDir, targetPath).Dir))
 }
@@ -1,15 +1,19 @@
 package mount_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	provider "github.com/smou/k8s-csi-s3/pkg/driver/mount"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"k8s.io/klog/v2/textlogger"
 )
 
 func fakeExecCommand(success bool) func(context.Context, string, ...string) *exec.Cmd {
@@ -52,8 +56,9 @@ func TestMount_Success(t *testing.T) {
 
 	mounter := NewFakeMounter()
 	p := &provider.S3MountUtil{
-		Mounter: mounter,
-		Binary:  "mountpoint-s3",
+		Mounter:        mounter,
+		Binary:         "mountpoint-s3",
+		CredentialsDir: t.TempDir(),
 	}
 
 	req := provider.MountRequest{
@@ -95,13 +100,16 @@ func TestMount_Failure(t *testing.T) {
 
 	mounter := NewFakeMounter()
 	p := &provider.S3MountUtil{
-		Mounter: mounter,
-		Binary:  "mountpoint-s3",
+		Mounter:        mounter,
+		Binary:         "mountpoint-s3",
+		CredentialsDir: t.TempDir(),
 	}
 
 	err := p.Mount(context.Background(), provider.MountRequest{
 		TargetPath: "/tmp/mnt",
 		Bucket:     "bucket",
+		AccessKey:  "ak",
+		SecretKey:  "sk",
 	})
 	assert.Error(t, err)
 }
@@ -111,7 +119,8 @@ func TestUnmount(t *testing.T) {
 	mounter.mounted["/mnt/test"] = true
 
 	p := &provider.S3MountUtil{
-		Mounter: mounter,
+		Mounter:        mounter,
+		CredentialsDir: t.TempDir(),
 	}
 
 	err := p.Unmount(context.Background(), "/mnt/test")
@@ -120,3 +129,236 @@ func TestUnmount(t *testing.T) {
 	mounted, _ := p.IsMounted("/mnt/test")
 	assert.False(t, mounted)
 }
+
+func TestMount_CredentialsFile(t *testing.T) {
+	var cmd *exec.Cmd
+	oldExec := provider.ExecCommand
+	defer func() { provider.ExecCommand = oldExec }()
+	provider.ExecCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd = exec.CommandContext(ctx, "true")
+		return cmd
+	}
+
+	credsDir := t.TempDir()
+	target := filepath.Join(t.TempDir(), "mnt")
+	mounter := NewFakeMounter()
+	p := &provider.S3MountUtil{
+		Mounter:        mounter,
+		Binary:         "mountpoint-s3",
+		CredentialsDir: credsDir,
+	}
+
+	err := p.Mount(context.Background(), provider.MountRequest{
+		TargetPath: target,
+		Bucket:     "bucket",
+		Region:     "us-east-1",
+		AccessKey:  "ak",
+		SecretKey:  "sk",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, cmd)
+
+	env := map[string]string{}
+	for _, e := range cmd.Env {
+		k, v, _ := strings.Cut(e, "=")
+		env[k] = v
+	}
+	assert.NotContains(t, env, "AWS_ACCESS_KEY_ID")
+	assert.NotContains(t, env, "AWS_SECRET_ACCESS_KEY")
+	assert.Equal(t, "csi-s3", env["AWS_PROFILE"])
+
+	credsFile := env["AWS_SHARED_CREDENTIALS_FILE"]
+	require.True(t, strings.HasPrefix(credsFile, credsDir))
+	info, err := os.Stat(credsFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	content, err := os.ReadFile(credsFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "aws_access_key_id = ak")
+	assert.Contains(t, string(content), "aws_secret_access_key = sk")
+
+	assert.True(t, p.Manages(target))
+
+	mounter.mounted[target] = true
+	require.NoError(t, p.Unmount(context.Background(), target))
+
+	_, err = os.Stat(credsFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.False(t, p.Manages(target))
+}
+
+func TestMount_EndpointSettings(t *testing.T) {
+	var cmd *exec.Cmd
+	var args []string
+	oldExec := provider.ExecCommand
+	defer func() { provider.ExecCommand = oldExec }()
+	provider.ExecCommand = func(ctx context.Context, name string, a ...string) *exec.Cmd {
+		args = a
+		cmd = exec.CommandContext(ctx, "true")
+		return cmd
+	}
+
+	p := &provider.S3MountUtil{
+		Mounter:        NewFakeMounter(),
+		Binary:         "mountpoint-s3",
+		CredentialsDir: t.TempDir(),
+	}
+	req := provider.MountRequest{
+		TargetPath: filepath.Join(t.TempDir(), "mnt"),
+		Bucket:     "bucket",
+		Endpoint:   "https://minio.internal",
+		AccessKey:  "ak",
+		SecretKey:  "sk",
+		CABundle:   "/etc/csi-s3/tls/ca/ca.crt",
+	}
+	require.NoError(t, p.Mount(context.Background(), req))
+	require.NotNil(t, cmd)
+	assert.Contains(t, cmd.Env, "AWS_CA_BUNDLE=/etc/csi-s3/tls/ca/ca.crt")
+	assert.Contains(t, args, "--force-path-style")
+
+	req.TargetPath = filepath.Join(t.TempDir(), "mnt")
+	req.VirtualHostedStyle = true
+	req.HTTPSProxy = "http://proxy.local:3128"
+	req.NoProxy = "minio.internal"
+	require.NoError(t, p.Mount(context.Background(), req))
+	assert.NotContains(t, args, "--force-path-style")
+	assert.Contains(t, cmd.Env, "HTTPS_PROXY=http://proxy.local:3128")
+	assert.Contains(t, cmd.Env, "https_proxy=http://proxy.local:3128")
+	assert.Contains(t, cmd.Env, "NO_PROXY=minio.internal")
+	assert.NotContains(t, cmd.Env, "HTTP_PROXY=")
+}
+
+func TestMount_Prefix(t *testing.T) {
+	var args []string
+	oldExec := provider.ExecCommand
+	defer func() { provider.ExecCommand = oldExec }()
+	provider.ExecCommand = func(ctx context.Context, name string, a ...string) *exec.Cmd {
+		args = a
+		return exec.CommandContext(ctx, "true")
+	}
+
+	p := &provider.S3MountUtil{
+		Mounter:        NewFakeMounter(),
+		Binary:         "mountpoint-s3",
+		CredentialsDir: t.TempDir(),
+	}
+	target := filepath.Join(t.TempDir(), "mnt")
+	req := provider.MountRequest{
+		TargetPath: target,
+		Bucket:     "bucket",
+		Prefix:     "jobs/42/",
+		AccessKey:  "ak",
+		SecretKey:  "sk",
+	}
+	require.NoError(t, p.Mount(context.Background(), req))
+	assert.Contains(t, strings.Join(args, " "), "--prefix jobs/42/")
+	assert.Equal(t, []string{"bucket", target}, args[len(args)-2:])
+}
+
+func TestMount_Ownership(t *testing.T) {
+	var args []string
+	oldExec := provider.ExecCommand
+	defer func() { provider.ExecCommand = oldExec }()
+	provider.ExecCommand = func(ctx context.Context, name string, a ...string) *exec.Cmd {
+		args = a
+		return exec.CommandContext(ctx, "true")
+	}
+
+	p := &provider.S3MountUtil{
+		Mounter:        NewFakeMounter(),
+		Binary:         "mountpoint-s3",
+		CredentialsDir: t.TempDir(),
+	}
+	mountWith := func(o provider.Ownership) string {
+		req := provider.MountRequest{
+			TargetPath: filepath.Join(t.TempDir(), "mnt"),
+			Bucket:     "bucket",
+			AccessKey:  "ak",
+			SecretKey:  "sk",
+			Ownership:  o,
+		}
+		require.NoError(t, p.Mount(context.Background(), req))
+		return strings.Join(args, " ")
+	}
+
+	// without ownership the defaults of mount-s3 apply
+	cmdline := mountWith(provider.Ownership{})
+	assert.NotContains(t, cmdline, "--uid")
+	assert.NotContains(t, cmdline, "--dir-mode")
+
+	// a group owner gets group writable permissions
+	cmdline = mountWith(provider.Ownership{GID: "2000"})
+	assert.Contains(t, cmdline, "--gid 2000 --dir-mode 0775 --file-mode 0664")
+
+	cmdline = mountWith(provider.Ownership{UID: "1000", GID: "2000", DirMode: "0750", FileMode: "0640"})
+	assert.Contains(t, cmdline, "--uid 1000 --gid 2000 --dir-mode 0750 --file-mode 0640")
+}
+
+func TestMount_Cache(t *testing.T) {
+	var args []string
+	oldExec := provider.ExecCommand
+	defer func() { provider.ExecCommand = oldExec }()
+	provider.ExecCommand = func(ctx context.Context, name string, a ...string) *exec.Cmd {
+		args = a
+		return exec.CommandContext(ctx, "true")
+	}
+
+	cacheDir := t.TempDir()
+	p := &provider.S3MountUtil{
+		Mounter:        NewFakeMounter(),
+		Binary:         "mountpoint-s3",
+		CredentialsDir: t.TempDir(),
+		CacheDir:       cacheDir,
+	}
+	target := filepath.Join(t.TempDir(), "mnt")
+	req := provider.MountRequest{
+		TargetPath: target,
+		Bucket:     "bucket",
+		AccessKey:  "ak",
+		SecretKey:  "sk",
+		Tuning:     provider.Tuning{Cache: true, MaxCacheSize: 256, MaxThreads: 8},
+	}
+	require.NoError(t, p.Mount(context.Background(), req))
+
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	dir := filepath.Join(cacheDir, entries[0].Name())
+	assert.Contains(t, strings.Join(args, " "), "--cache "+dir+" --max-cache-size 256 --max-threads 8")
+
+	require.NoError(t, p.Unmount(context.Background(), target))
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMountRequest_RedactsCredentials(t *testing.T) {
+	req := provider.MountRequest{
+		Bucket:       "bucket",
+		AccessKey:    "AKIA",
+		SecretKey:    "s3cr3t",
+		SessionToken: "token",
+	}
+	for _, out := range []string{req.String(), fmt.Sprintf("%v", req), fmt.Sprintf("%+v", req), req.LogValue().String()} {
+		assert.Contains(t, out, "bucket")
+		assert.NotContains(t, out, "AKIA")
+		assert.NotContains(t, out, "s3cr3t")
+		assert.NotContains(t, out, "token")
+	}
+}
+
+func TestMountRequest_RedactsServiceAccountTokens(t *testing.T) {
+	req := provider.MountRequest{
+		Bucket: "bucket",
+		Options: map[string]string{
+			"csi.storage.k8s.io/serviceAccount.tokens": `{"sts.min.io":{"token":"eyJhbGciOi"}}`,
+			"csi.storage.k8s.io/pod.name":              "web-0",
+		},
+	}
+	var buf bytes.Buffer
+	logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Output(&buf), textlogger.Verbosity(4)))
+	logger.V(4).Info("S3 Mountutil Mount", "request", req)
+	assert.Contains(t, buf.String(), "web-0")
+	assert.NotContains(t, buf.String(), "eyJhbGciOi")
+	assert.Contains(t, req.Options["csi.storage.k8s.io/serviceAccount.tokens"], "eyJhbGciOi", "the request is not modified")
+}
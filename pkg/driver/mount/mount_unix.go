@@ -16,7 +16,7 @@ type UnixMountUtil struct {
 }
 
 func NewUnixMountUtil(binary string) *UnixMountUtil {
-	klog.Infof("Init Unix Mounter at %s", binary)
+	klog.InfoS("Init Unix Mounter", "binary", binary)
 	return &UnixMountUtil{
 		Mounter: mount.New(""),
 		Binary:  binary,
@@ -24,9 +24,8 @@ func NewUnixMountUtil(binary string) *UnixMountUtil {
 }
 
 func (p *UnixMountUtil) IsMounted(targetPath string) (bool, error) {
-	klog.V(4).Infof("Unix Mountutil IsMounted: called with targetPath %s", targetPath)
 	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		klog.ErrorS(err, "Unix Mountutil IsMounted: targetPath %s does not exist", targetPath)
+		klog.V(4).InfoS("Unix Mountutil IsMounted: targetPath does not exist", "targetPath", targetPath)
 		return false, nil
 	}
 
@@ -39,7 +38,8 @@ func (p *UnixMountUtil) IsMounted(targetPath string) (bool, error) {
 }
 
 func (p *UnixMountUtil) Mount(ctx context.Context, req MountRequest) error {
-	klog.V(4).Infof("Unix Mountutil Mount: called with args %+v", req)
+	logger := klog.FromContext(ctx)
+	logger.V(4).Info("Unix Mountutil Mount", "request", req)
 	if err := ensureDir(req.TargetPath); err != nil {
 		return err
 	}
@@ -53,7 +53,7 @@ func (p *UnixMountUtil) Mount(ctx context.Context, req MountRequest) error {
 	}
 	mounted, err = p.IsMounted(req.StagingTargetPath)
 	if err != nil {
-		klog.ErrorS(err, "Unix Mountutil IsMounted: staging targetPath %s does not exist", req.StagingTargetPath)
+		logger.Error(err, "Unix Mountutil IsMounted: cannot check staging targetPath", "stagingTargetPath", req.StagingTargetPath)
 		return err
 	}
 
@@ -72,7 +72,7 @@ func (p *UnixMountUtil) Mount(ctx context.Context, req MountRequest) error {
 		req.TargetPath,
 	}
 	options = append(options, args...)
-	klog.Infof("Mount options: %+v", options)
+	logger.Info("Mounting", "binary", p.Binary, "options", options)
 	cmd := ExecCommand(ctx, p.Binary, options...)
 
 	out, err := cmd.CombinedOutput()
@@ -83,13 +83,13 @@ func (p *UnixMountUtil) Mount(ctx context.Context, req MountRequest) error {
 			string(out),
 		)
 	}
-	klog.Infof("%s", string(out))
+	logger.Info("Mounted", "targetPath", req.TargetPath, "output", string(out))
 
 	return nil
 }
 
 func (p *UnixMountUtil) Unmount(ctx context.Context, targetPath string) error {
-	klog.V(4).Infof("S3 Mountutil Unmount: called with targetPath %s", targetPath)
+	klog.FromContext(ctx).V(4).Info("Unix Mountutil Unmount", "targetPath", targetPath)
 	mounted, err := p.IsMounted(targetPath)
 	if err != nil {
 		return err
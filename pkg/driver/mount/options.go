@@ -0,0 +1,176 @@
+package mount
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCacheDir holds the per-mount cache directories of volumes with the mount option "cache=disk".
+const DefaultCacheDir = "/var/cache/csi-s3"
+
+const (
+	CacheNone = "none"
+	CacheDisk = "disk"
+)
+
+// maxThreads limits the mount option max-threads, mount-s3 starts one thread per request in flight
+const maxThreads = 1024
+
+// optionValuePattern leaves no room for shell metacharacters, whitespace or further arguments.
+var optionValuePattern = regexp.MustCompile(`^[A-Za-z0-9._/-]*$`)
+
+// Tuning are performance settings of mount-s3, zero values keep its defaults.
+type Tuning struct {
+	// Cache caches object content in a directory of the node below the cache dir of the mounter
+	Cache bool
+	// MaxCacheSize in MiB
+	MaxCacheSize uint64
+	// MetadataTTL in seconds, "indefinite" or "minimal"
+	MetadataTTL string
+	MaxThreads  uint64
+}
+
+// args returns the mount-s3 arguments, cacheDir is the directory of the mount if Cache is set.
+func (t Tuning) args(cacheDir string) []string {
+	var args []string
+	if t.Cache {
+		args = append(args, "--cache", cacheDir)
+		if t.MaxCacheSize > 0 {
+			args = append(args, "--max-cache-size", strconv.FormatUint(t.MaxCacheSize, 10))
+		}
+	}
+	if t.MetadataTTL != "" {
+		args = append(args, "--metadata-ttl", t.MetadataTTL)
+	}
+	if t.MaxThreads > 0 {
+		args = append(args, "--max-threads", strconv.FormatUint(t.MaxThreads, 10))
+	}
+	return args
+}
+
+// MountOptions are the validated mountOptions of a StorageClass or PV.
+type MountOptions struct {
+	ReadOnly  bool
+	Ownership Ownership
+	Prefix    string
+	Tuning    Tuning
+}
+
+// mountOption parses the value of a single option into opts.
+type mountOption func(opts *MountOptions, value string) error
+
+/*
+mountOptions is the allowlist of the generic options a StorageClass or PV may set. Everything else, in particular
+connection settings like the endpoint or the credentials, is controlled by the driver and rejected.
+*/
+var mountOptions = map[string]mountOption{
+	"ro": flagOption(func(o *MountOptions) { o.ReadOnly = true }),
+	"rw": flagOption(func(o *MountOptions) { o.ReadOnly = false }),
+	// mount-s3 always runs with --allow-delete and --allow-overwrite, the options are accepted for portability
+	"allow-delete":    flagOption(func(*MountOptions) {}),
+	"allow-overwrite": flagOption(func(*MountOptions) {}),
+	"uid":             ownershipOption(func(o *Ownership, v string) { o.UID = v }),
+	"gid":             ownershipOption(func(o *Ownership, v string) { o.GID = v }),
+	"dir-mode":        ownershipOption(func(o *Ownership, v string) { o.DirMode = v }),
+	"file-mode":       ownershipOption(func(o *Ownership, v string) { o.FileMode = v }),
+	"prefix": func(o *MountOptions, v string) (err error) {
+		o.Prefix, err = NormalizePrefix(v)
+		return err
+	},
+	"cache": func(o *MountOptions, v string) error {
+		switch v {
+		case CacheDisk:
+			o.Tuning.Cache = true
+		case CacheNone:
+			o.Tuning.Cache = false
+		default:
+			return fmt.Errorf("must be %s or %s", CacheDisk, CacheNone)
+		}
+		return nil
+	},
+	"max-cache-size": uintOption(1, 1<<30, func(o *MountOptions, n uint64) { o.Tuning.MaxCacheSize = n }),
+	"metadata-ttl": func(o *MountOptions, v string) error {
+		if v != "indefinite" && v != "minimal" {
+			if _, err := strconv.ParseUint(v, 10, 32); err != nil {
+				return fmt.Errorf("must be seconds, indefinite or minimal")
+			}
+		}
+		o.Tuning.MetadataTTL = v
+		return nil
+	},
+	"max-threads": uintOption(1, maxThreads, func(o *MountOptions, n uint64) { o.Tuning.MaxThreads = n }),
+}
+
+// ParseMountOptions validates mountOptions like "ro", "uid=1000" or "cache=disk". A leading "--" is ignored,
+// unknown options and values with other characters than letters, digits, ".", "_", "/" and "-" are rejected.
+func ParseMountOptions(flags []string) (MountOptions, error) {
+	var opts MountOptions
+	for _, flag := range flags {
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		parse, ok := mountOptions[name]
+		if !ok {
+			return MountOptions{}, fmt.Errorf("mount option %q is not supported", flag)
+		}
+		if !optionValuePattern.MatchString(value) {
+			return MountOptions{}, fmt.Errorf("mount option %q contains invalid characters", flag)
+		}
+		if err := parse(&opts, value); err != nil {
+			return MountOptions{}, fmt.Errorf("mount option %q: %w", flag, err)
+		}
+	}
+	var err error
+	if opts.Ownership, err = opts.Ownership.normalize(); err != nil {
+		return MountOptions{}, fmt.Errorf("mount options: %w", err)
+	}
+	return opts, nil
+}
+
+// NormalizePrefix validates a key prefix and appends the trailing slash mount-s3 expects.
+func NormalizePrefix(prefix string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "", nil
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid prefix %q", prefix)
+		}
+	}
+	if strings.ContainsFunc(prefix, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", fmt.Errorf("invalid prefix %q", prefix)
+	}
+	return prefix + "/", nil
+}
+
+func flagOption(set func(*MountOptions)) mountOption {
+	return func(o *MountOptions, v string) error {
+		if v != "" {
+			return fmt.Errorf("takes no value")
+		}
+		set(o)
+		return nil
+	}
+}
+
+func ownershipOption(set func(*Ownership, string)) mountOption {
+	return func(o *MountOptions, v string) error {
+		if v == "" {
+			return fmt.Errorf("value missing")
+		}
+		set(&o.Ownership, v)
+		return nil
+	}
+}
+
+func uintOption(min, max uint64, set func(*MountOptions, uint64)) mountOption {
+	return func(o *MountOptions, v string) error {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("must be a number between %d and %d", min, max)
+		}
+		set(o, n)
+		return nil
+	}
+}
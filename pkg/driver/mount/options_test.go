@@ -0,0 +1,51 @@
+package mount_test
+
+import (
+	"testing"
+
+	provider "github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	opts, err := provider.ParseMountOptions([]string{
+		"ro", "allow-delete", "uid=1000", "--gid=2000", "file-mode=640",
+		"prefix=/team-a/data", "cache=disk", "max-cache-size=512", "metadata-ttl=60", "max-threads=32",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, provider.MountOptions{
+		ReadOnly:  true,
+		Ownership: provider.Ownership{UID: "1000", GID: "2000", FileMode: "0640"},
+		Prefix:    "team-a/data/",
+		Tuning:    provider.Tuning{Cache: true, MaxCacheSize: 512, MetadataTTL: "60", MaxThreads: 32},
+	}, opts)
+
+	opts, err = provider.ParseMountOptions(nil)
+	require.NoError(t, err)
+	assert.Equal(t, provider.MountOptions{}, opts)
+}
+
+func TestParseMountOptions_Rejected(t *testing.T) {
+	tests := map[string]string{
+		"endpoint override":  "--endpoint-url=http://attacker.local",
+		"credentials":        "profile=admin",
+		"unknown":            "noatime",
+		"shell":              "prefix=a;rm -rf /",
+		"command":            "prefix=$(id)",
+		"argument injection": "max-threads=4 --endpoint-url",
+		"newline":            "prefix=a\n--debug",
+		"value on flag":      "ro=false",
+		"prefix escapes":     "prefix=../other",
+		"invalid uid":        "uid=root",
+		"invalid cache":      "cache=/etc",
+		"threads":            "max-threads=0",
+		"ttl":                "metadata-ttl=forever",
+	}
+	for name, option := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := provider.ParseMountOptions([]string{"ro", option})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package mount
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// StorageClass parameters and volume attributes for the owner and permissions of files and directories
+	UIDKey      = "uid"
+	GIDKey      = "gid"
+	DirModeKey  = "dirMode"
+	FileModeKey = "fileMode"
+
+	// DefaultDirMode and DefaultFileMode let the group write, so fsGroup works without further options
+	DefaultDirMode  = "0775"
+	DefaultFileMode = "0664"
+)
+
+// Ownership sets the owner and permissions mount-s3 reports for files and directories.
+// Empty fields keep the defaults of mount-s3.
+type Ownership struct {
+	UID      string
+	GID      string
+	DirMode  string
+	FileMode string
+}
+
+// ParseOwnership reads the ownership from StorageClass parameters or a volume context.
+func ParseOwnership(params map[string]string) (Ownership, error) {
+	o := Ownership{
+		UID:      params[UIDKey],
+		GID:      params[GIDKey],
+		DirMode:  params[DirModeKey],
+		FileMode: params[FileModeKey],
+	}
+	return o.normalize()
+}
+
+// Merge returns o with the fields set in override replaced.
+func (o Ownership) Merge(override Ownership) Ownership {
+	if override.UID != "" {
+		o.UID = override.UID
+	}
+	if override.GID != "" {
+		o.GID = override.GID
+	}
+	if override.DirMode != "" {
+		o.DirMode = override.DirMode
+	}
+	if override.FileMode != "" {
+		o.FileMode = override.FileMode
+	}
+	return o
+}
+
+// Context adds the fields which are set to a volume context.
+func (o Ownership) Context(volumeContext map[string]string) {
+	for key, value := range map[string]string{UIDKey: o.UID, GIDKey: o.GID, DirModeKey: o.DirMode, FileModeKey: o.FileMode} {
+		if value != "" {
+			volumeContext[key] = value
+		}
+	}
+}
+
+// args returns the mount-s3 arguments, a group owner gets group writable permissions by default.
+func (o Ownership) args() []string {
+	var args []string
+	if o.UID != "" {
+		args = append(args, "--uid", o.UID)
+	}
+	dirMode, fileMode := o.DirMode, o.FileMode
+	if o.GID != "" {
+		args = append(args, "--gid", o.GID)
+		if dirMode == "" {
+			dirMode = DefaultDirMode
+		}
+		if fileMode == "" {
+			fileMode = DefaultFileMode
+		}
+	}
+	if dirMode != "" {
+		args = append(args, "--dir-mode", dirMode)
+	}
+	if fileMode != "" {
+		args = append(args, "--file-mode", fileMode)
+	}
+	return args
+}
+
+// normalize validates the fields and formats the modes as four digit octal numbers.
+func (o Ownership) normalize() (Ownership, error) {
+	var err error
+	if o.UID, err = normalizeID(UIDKey, o.UID); err != nil {
+		return Ownership{}, err
+	}
+	if o.GID, err = normalizeID(GIDKey, o.GID); err != nil {
+		return Ownership{}, err
+	}
+	if o.DirMode, err = normalizeMode(DirModeKey, o.DirMode); err != nil {
+		return Ownership{}, err
+	}
+	if o.FileMode, err = normalizeMode(FileModeKey, o.FileMode); err != nil {
+		return Ownership{}, err
+	}
+	return o, nil
+}
+
+// normalizeID accepts a numeric user or group ID, 4294967295 is reserved as "no ID".
+func normalizeID(key, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 1<<32-1 {
+		return "", fmt.Errorf("%s %q must be a numeric ID", key, value)
+	}
+	return strconv.FormatUint(id, 10), nil
+}
+
+// normalizeMode accepts octal permission bits, setuid, setgid and sticky bit are not supported by mount-s3.
+func normalizeMode(key, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return "", fmt.Errorf("%s %q must be an octal mode between 0000 and 0777", key, value)
+	}
+	return fmt.Sprintf("%04o", mode), nil
+}
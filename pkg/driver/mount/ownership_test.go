@@ -0,0 +1,47 @@
+package mount_test
+
+import (
+	"testing"
+
+	provider "github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    provider.Ownership
+		wantErr bool
+	}{
+		{name: "empty", params: nil, want: provider.Ownership{}},
+		{
+			name:   "all",
+			params: map[string]string{"uid": "1000", "gid": "2000", "dirMode": "770", "fileMode": "0640"},
+			want:   provider.Ownership{UID: "1000", GID: "2000", DirMode: "0770", FileMode: "0640"},
+		},
+		{name: "negative uid", params: map[string]string{"uid": "-1"}, wantErr: true},
+		{name: "reserved gid", params: map[string]string{"gid": "4294967295"}, wantErr: true},
+		{name: "user name", params: map[string]string{"uid": "nobody"}, wantErr: true},
+		{name: "decimal mode", params: map[string]string{"dirMode": "999"}, wantErr: true},
+		{name: "setuid", params: map[string]string{"fileMode": "4755"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.ParseOwnership(tt.params)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOwnership_Merge(t *testing.T) {
+	base := provider.Ownership{UID: "1000", GID: "1000", DirMode: "0755"}
+	got := base.Merge(provider.Ownership{GID: "2000", FileMode: "0600"})
+	assert.Equal(t, provider.Ownership{UID: "1000", GID: "2000", DirMode: "0755", FileMode: "0600"}, got)
+}
@@ -0,0 +1,137 @@
+package nodeserver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/smou/k8s-csi-s3/pkg/driver/s3err"
+	"github.com/smou/k8s-csi-s3/pkg/driver/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// EphemeralContextKey is set by kubelet for CSI ephemeral inline volumes
+	EphemeralContextKey = "csi.storage.k8s.io/ephemeral"
+
+	// volumeAttributes of an ephemeral inline volume
+	EphemeralBucketKey = "bucket"
+	EphemeralPrefixKey = "prefix"
+)
+
+// isEphemeral reports whether kubelet publishes an inline volume of the pod spec.
+func isEphemeral(volumeContext map[string]string) bool {
+	return volumeContext[EphemeralContextKey] == "true"
+}
+
+/*
+Mount s3 directly to the target path of an ephemeral inline volume. Kubelet skips NodeStageVolume for
+these volumes, bucket and prefix are taken from the volumeAttributes of the pod spec. The credentials come
+from the nodePublishSecretRef of the volume, the backend credentials are only used with EphemeralDriverCredentials.
+*/
+func (n *NodeServer) publishEphemeral(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	mounted, err := n.s3.IsMounted(req.TargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	attrs := req.GetVolumeContext()
+	bucket := attrs[EphemeralBucketKey]
+	if err := s3utils.CheckValidBucketNameStrict(bucket); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volumeAttributes.%s: %v", EphemeralBucketKey, err)
+	}
+	prefix, err := mount.NormalizePrefix(attrs[EphemeralPrefixKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volumeAttributes.%s: %v", EphemeralPrefixKey, err)
+	}
+	backend, err := n.Backends.Get(attrs[volume.BackendKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volumeAttributes.%s: %v", volume.BackendKey, err)
+	}
+	creds, err := n.ephemeralCredentials(backend, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	opts, err := mountOptions(req.GetVolumeCapability(), attrs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Prefix != "" {
+		prefix = opts.Prefix
+	}
+
+	mreq := mount.MountRequest{
+		TargetPath: req.TargetPath,
+
+		Bucket: bucket,
+		Prefix: prefix,
+		Region: attrs["region"],
+
+		AccessKey: creds.AccessKey,
+		SecretKey: creds.SecretKey,
+
+		ReadOnly:  req.GetReadonly() || opts.ReadOnly,
+		Ownership: n.podOwnership(ctx, opts.Ownership, attrs),
+		Tuning:    opts.Tuning,
+		Options:   attrs,
+	}
+	applyS3Config(&mreq, backend.S3.Current())
+
+	if err := n.mountS3(ctx, backend.Name, mreq); err != nil {
+		return nil, s3err.Status(err, codes.Internal, "")
+	}
+	n.setEphemeralMount(req.TargetPath, backend.Name)
+	n.trackPublished(req.TargetPath, backend.Name)
+	klog.FromContext(ctx).V(1).Info("Ephemeral volume published", "backend", backend.Name, "bucket", bucket, "prefix", prefix, "targetPath", req.TargetPath)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (n *NodeServer) isEphemeralMount(targetPath string) bool {
+	_, ok := n.ephemeralMount(targetPath)
+	return ok
+}
+
+// ephemeralMount returns the backend of the inline volume at targetPath.
+func (n *NodeServer) ephemeralMount(targetPath string) (string, bool) {
+	n.ephemeralMu.Lock()
+	defer n.ephemeralMu.Unlock()
+	backend, ok := n.ephemeralMounts[targetPath]
+	return backend, ok
+}
+
+// setEphemeralMount records the backend of the inline volume at targetPath, an empty backend removes it.
+func (n *NodeServer) setEphemeralMount(targetPath, backend string) {
+	n.ephemeralMu.Lock()
+	defer n.ephemeralMu.Unlock()
+	if backend == "" {
+		delete(n.ephemeralMounts, targetPath)
+		return
+	}
+	n.ephemeralMounts[targetPath] = backend
+}
+
+// ephemeralCredentials returns the keys of the node publish secret or, if allowed, of the backend.
+func (n *NodeServer) ephemeralCredentials(backend *config.Backend, secrets map[string]string) (*config.S3Credentials, error) {
+	if len(secrets) > 0 {
+		creds, err := config.CredentialsFromSecretData(secrets)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "nodePublishSecretRef: %v", err)
+		}
+		return creds, nil
+	}
+	if !n.EphemeralDriverCredentials {
+		return nil, status.Error(codes.InvalidArgument, "ephemeral volume requires a nodePublishSecretRef")
+	}
+	creds, _ := backend.Credentials.Get()
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+	}
+	return &creds, nil
+}
@@ -15,6 +15,10 @@ type FakeMountProvider struct {
 	unmountErr  error
 	lastMount   *mount.MountRequest
 	lastUnmount string
+
+	// mountStarted and unblockMount let a test hold a Mount in progress
+	mountStarted chan struct{}
+	unblockMount chan struct{}
 }
 
 func NewFakeMountProvider() *FakeMountProvider {
@@ -30,6 +34,10 @@ func (f *FakeMountProvider) IsMounted(targetPath string) (bool, error) {
 }
 
 func (f *FakeMountProvider) Mount(ctx context.Context, req mount.MountRequest) error {
+	if f.unblockMount != nil {
+		f.mountStarted <- struct{}{}
+		<-f.unblockMount
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.lastMount = &req
@@ -50,3 +58,19 @@ func (f *FakeMountProvider) Unmount(ctx context.Context, targetPath string) erro
 	delete(f.mounted, targetPath)
 	return nil
 }
+
+func (f *FakeMountProvider) LastMount() *mount.MountRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastMount
+}
+
+// TrackingMountProvider remembers its mounts like the credential files of mount-s3, which survive a restart.
+type TrackingMountProvider struct {
+	*FakeMountProvider
+}
+
+func (t TrackingMountProvider) Manages(targetPath string) bool {
+	mounted, _ := t.IsMounted(targetPath)
+	return mounted
+}
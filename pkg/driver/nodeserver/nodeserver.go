@@ -2,15 +2,29 @@ package nodeserver
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/inflight"
+	"github.com/smou/k8s-csi-s3/pkg/driver/metrics"
 	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/smou/k8s-csi-s3/pkg/driver/s3err"
+	"github.com/smou/k8s-csi-s3/pkg/driver/sts"
+	"github.com/smou/k8s-csi-s3/pkg/driver/svcacct"
+	"github.com/smou/k8s-csi-s3/pkg/driver/volume"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
+const remountTimeout = 2 * time.Minute
+
 var (
 	capabilities = []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
@@ -24,31 +38,143 @@ type NodeServer struct {
 	mount mount.Provider
 	s3    mount.Provider
 
-	NodeID    string
-	Endpoint  string
-	AccessKey string
-	SecretKey string
+	NodeID   string
+	Backends *config.Backends
+
+	// RemountOnRotation remounts staged volumes after the credentials have been rotated
+	RemountOnRotation bool
+
+	// STS creates the exchangers of service account tokens for temporary credentials (authenticationSource=serviceAccount)
+	STS         sts.ExchangerFactory
+	STSAudience string
+
+	// EphemeralDriverCredentials mounts inline volumes without nodePublishSecretRef with the credentials of the backend
+	EphemeralDriverCredentials bool
+
+	// Events reports failed mounts on the Pod, nil without the Kubernetes API
+	Events *events.Recorder
+
+	// Topology reports the zone of the node in NodeGetInfo, read from the node label Topology.Key
+	Topology config.TopologyConfig
+	// Kube reads the labels of the node, nil without the Kubernetes API
+	Kube kubernetes.Interface
+
+	// inFlight rejects concurrent operations on the same volume ID (stage) or target path (publish)
+	inFlight *inflight.Tracker
+
+	// workload identity mounts by target path, the mutex only guards the map, operations on a target path
+	// are serialized by inFlight
+	workloadMu     sync.Mutex
+	workloadMounts map[string]*workloadMount
+
+	// backend of every ephemeral inline volume, by target path, the mutex only guards the map like workloadMu
+	ephemeralMu     sync.Mutex
+	ephemeralMounts map[string]string
+
+	stagedMu sync.Mutex
+	staged   map[string]*stagedVolume
+
+	// backend of every published target path, for the volume metrics
+	publishedMu sync.Mutex
+	published   map[string]string
+
+	// credentials holders of the backends whose rotation is already observed
+	rotationMu      sync.Mutex
+	watchedRotation map[*config.CredentialsHolder]bool
+
+	// STS exchanger of every backend with the S3Config it has been built for
+	exchangersMu sync.Mutex
+	exchangers   map[string]backendExchanger
+}
+
+type backendExchanger struct {
+	config    config.S3Config
+	exchanger sts.Exchanger
+}
+
+// stagedVolume remembers the mount of a staging path and the credentials generation it uses.
+type stagedVolume struct {
+	volumeID    string
+	backend     string
+	req         mount.MountRequest
+	credentials *config.CredentialsHolder
+	generation  uint64
+}
+
+// workloadMount is a volume mounted directly to the target path with the pod's identity.
+type workloadMount struct {
+	req   mount.MountRequest
+	creds *sts.Credentials
 }
 
 func NewNodeServer(config *config.DriverConfig, mountProvider mount.Provider, s3MountProvider mount.Provider) *NodeServer {
-	return &NodeServer{
-		mount:     mountProvider,
-		s3:        s3MountProvider,
-		NodeID:    config.NodeID,
-		Endpoint:  config.S3.Endpoint,
-		AccessKey: config.S3Credentials.AccessKey,
-		SecretKey: config.S3Credentials.SecretKey,
+	audience := config.STS.Audience
+	if audience == "" {
+		audience = sts.DefaultAudience
+	}
+	n := &NodeServer{
+		mount:                      mountProvider,
+		s3:                         s3MountProvider,
+		NodeID:                     config.NodeID,
+		Backends:                   config.Backends(),
+		RemountOnRotation:          config.RemountOnCredentialRotation,
+		STS:                        sts.WebIdentityExchangers(config.STS.Duration),
+		STSAudience:                audience,
+		EphemeralDriverCredentials: config.EphemeralDriverCredentials,
+		Topology:                   config.Topology,
+		Kube:                       config.Kube.Client,
+		workloadMounts:             make(map[string]*workloadMount),
+		ephemeralMounts:            make(map[string]string),
+		staged:                     make(map[string]*stagedVolume),
+		published:                  make(map[string]string),
+		inFlight:                   inflight.NewTracker(),
+	}
+	n.Backends.Each(n.watchRotation)
+	return n
+}
+
+// backend resolves the backend profile and bucket of a volume ID.
+func (n *NodeServer) backend(volumeID string) (*config.Backend, string, error) {
+	name, bucket := volume.ParseID(volumeID)
+	b, err := n.Backends.Get(name)
+	if err != nil {
+		return nil, "", status.Errorf(codes.InvalidArgument, "volume %s: %v", volumeID, err)
 	}
+	return b, bucket, nil
 }
 
 func (n *NodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
+	resp := &csi.NodeGetInfoResponse{
 		NodeId: n.NodeID,
-	}, nil
+	}
+	if !n.Topology.Enabled() {
+		return resp, nil
+	}
+	zone, err := n.zone(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cannot read zone of node %s: %v", n.NodeID, err)
+	}
+	if zone == "" {
+		klog.FromContext(ctx).Info("Node has no zone, only volumes of backends without zone can be used", "node", n.NodeID, "label", n.Topology.Key)
+		return resp, nil
+	}
+	resp.AccessibleTopology = &csi.Topology{Segments: map[string]string{n.Topology.Key: zone}}
+	return resp, nil
+}
+
+// zone returns the configured zone or the value of the topology label of the node.
+func (n *NodeServer) zone(ctx context.Context) (string, error) {
+	if n.Topology.Zone != "" || n.Kube == nil {
+		return n.Topology.Zone, nil
+	}
+	node, err := n.Kube.CoreV1().Nodes().Get(ctx, n.NodeID, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return node.Labels[n.Topology.Key], nil
 }
 
 func (n *NodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("NodeGetCapabilities: called with args %+v", req)
 	// currently there is a single NodeServer capability according to the spec
 	var capsResponse []*csi.NodeServiceCapability
 	for _, cap := range capabilities {
@@ -65,11 +191,25 @@ func (n *NodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCa
 	return &csi.NodeGetCapabilitiesResponse{Capabilities: capsResponse}, nil
 }
 
-func (n *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).Infof("NodePublishVolume: called with args %+v", req)
+func (n *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (resp *csi.NodePublishVolumeResponse, err error) {
+	defer func() {
+		if code := status.Code(err); err != nil && code != codes.InvalidArgument && code != codes.Aborted {
+			n.Events.PodWarning(req.GetVolumeContext(), events.Reason(err, events.ReasonMountFailed),
+				fmt.Sprintf("Cannot mount volume %s: %v", req.GetVolumeId(), status.Convert(err).Message()))
+		}
+	}()
 	if req.GetTargetPath() == "" {
 		return nil, status.Error(codes.InvalidArgument, "targetPath missing")
 	}
+	// inline volumes are not staged but mounted directly to the target path
+	if isEphemeral(req.GetVolumeContext()) {
+		release, err := n.inFlight.Acquire("NodePublishVolume", req.GetTargetPath())
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return n.publishEphemeral(ctx, req)
+	}
 	if req.GetStagingTargetPath() == "" {
 		return nil, status.Error(codes.InvalidArgument, "staging targetPath missing")
 	}
@@ -80,6 +220,15 @@ func (n *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
+	release, err := n.inFlight.Acquire("NodePublishVolume", req.GetTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if sts.IsServiceAccountVolume(req.GetVolumeContext()) {
+		return n.publishWithServiceAccount(ctx, req)
+	}
 
 	mounted, err := n.mount.IsMounted(req.TargetPath)
 	if err != nil {
@@ -93,12 +242,13 @@ func (n *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	if req.GetVolumeContext() != nil {
 		region = req.VolumeContext["region"]
 	}
+	_, bucket := volume.ParseID(req.GetVolumeId())
 
 	mreq := mount.MountRequest{
 		StagingTargetPath: req.StagingTargetPath,
 		TargetPath:        req.TargetPath,
 
-		Bucket: req.GetVolumeId(),
+		Bucket: bucket,
 		Region: region,
 
 		ReadOnly: false,
@@ -106,17 +256,26 @@ func (n *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	}
 
 	if err := n.mount.Mount(ctx, mreq); err != nil {
-		return nil, status.Errorf(codes.Internal, "%v", err)
+		return nil, s3err.Status(err, codes.Internal, "")
 	}
+	n.trackPublished(req.TargetPath, backendName(req.GetVolumeId()))
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
 func (n *NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnpublishVolume: called with args %+v", req)
 	if req.GetTargetPath() == "" {
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
+	release, err := n.inFlight.Acquire("NodeUnpublishVolume", req.GetTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if n.isDirectMount(req.TargetPath) {
+		return n.unpublishDirect(ctx, req)
+	}
 
 	mounted, err := n.mount.IsMounted(req.TargetPath)
 	if err != nil {
@@ -124,13 +283,15 @@ func (n *NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpub
 	}
 
 	if !mounted {
+		n.untrackPublished(req.TargetPath)
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
 	if err := n.mount.Unmount(ctx, req.TargetPath); err != nil {
-		return nil, status.Errorf(codes.Internal, "%v", err)
+		return nil, s3err.Status(err, codes.Internal, "")
 	}
-	klog.V(1).Infof("volume %s has been unmounted.", req.VolumeId)
+	n.untrackPublished(req.TargetPath)
+	klog.FromContext(ctx).V(1).Info("Volume unpublished", "targetPath", req.TargetPath)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
@@ -140,13 +301,24 @@ Staging path is used to prepare the volume before it is published to the target
 This allows for better performance and reliability when mounting the volume to the target path.
 */
 func (n *NodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	klog.V(4).Infof("NodeStageVolume: called with args %+v", req)
+	logger := klog.FromContext(ctx)
 	if req.GetStagingTargetPath() == "" {
 		return nil, status.Error(codes.InvalidArgument, "stagingTargetPath missing")
 	}
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, "volumeId missing")
 	}
+	release, err := n.inFlight.Acquire("NodeStageVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// with the identity of the pod the bucket is mounted directly to the target path in NodePublishVolume
+	if sts.IsServiceAccountVolume(req.GetVolumeContext()) {
+		logger.V(4).Info("Volume uses service account credentials, skip staging")
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
 
 	mounted, err := n.s3.IsMounted(req.StagingTargetPath)
 	if err != nil {
@@ -156,7 +328,22 @@ func (n *NodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	if n.AccessKey == "" || n.SecretKey == "" {
+	backend, bucket, err := n.backend(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	s3Config := backend.S3.Current()
+	// the node stage secret holds the credentials of the volume, they are not rotated with the backend
+	holder := backend.Credentials
+	creds, generation := holder.Get()
+	if len(req.GetSecrets()) > 0 || svcacct.Enabled(req.GetVolumeContext()) {
+		secretCreds, err := config.CredentialsFromSecretData(req.GetSecrets())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "node stage secret: %v", err)
+		}
+		creds, generation, holder = *secretCreds, 0, nil
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
 		return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 	}
 
@@ -165,38 +352,52 @@ func (n *NodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		region = req.VolumeContext["region"]
 	}
 
-	gid := getGIDFromVolumeCapability(req.GetVolumeCapability())
+	opts, err := mountOptions(req.GetVolumeCapability(), req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
 
 	mreq := mount.MountRequest{
 		StagingTargetPath: req.StagingTargetPath,
 		TargetPath:        req.StagingTargetPath,
 
-		Bucket:   req.VolumeId,
-		Endpoint: n.Endpoint,
-		Region:   region,
+		Bucket: bucket,
+		Prefix: opts.Prefix,
+		Region: region,
 
-		AccessKey: n.AccessKey,
-		SecretKey: n.SecretKey,
+		AccessKey: creds.AccessKey,
+		SecretKey: creds.SecretKey,
 
-		ReadOnly: false,
-		GID:      gid,
-		Options:  req.VolumeContext,
+		ReadOnly:  opts.ReadOnly,
+		Ownership: opts.Ownership,
+		Tuning:    opts.Tuning,
+		Options:   req.VolumeContext,
 	}
+	applyS3Config(&mreq, s3Config)
 
-	if err := n.s3.Mount(ctx, mreq); err != nil {
-		return nil, status.Errorf(codes.Internal, "%v", err)
+	if err := n.mountS3(ctx, backend.Name, mreq); err != nil {
+		return nil, s3err.Status(err, codes.Internal, "")
 	}
 
-	klog.V(1).Infof("volume %s staged at %s", req.VolumeId, req.StagingTargetPath)
+	n.stagedMu.Lock()
+	n.staged[req.StagingTargetPath] = &stagedVolume{volumeID: req.VolumeId, backend: backend.Name, req: mreq, credentials: holder, generation: generation}
+	n.reportStaged()
+	n.stagedMu.Unlock()
+
+	logger.V(1).Info("Volume staged", "backend", backend.Name, "stagingTargetPath", req.StagingTargetPath)
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
 func (n *NodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnstageVolume: called with args %+v", req)
 	if req.GetStagingTargetPath() == "" {
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
+	release, err := n.inFlight.Acquire("NodeUnstageVolume", req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	mounted, err := n.s3.IsMounted(req.StagingTargetPath)
 	if err != nil {
@@ -206,15 +407,332 @@ func (n *NodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstage
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
-	if err := n.s3.Unmount(ctx, req.StagingTargetPath); err != nil {
-		return nil, status.Errorf(codes.Internal, "%v", err)
+	if err := n.unmountS3(ctx, backendName(req.GetVolumeId()), req.StagingTargetPath); err != nil {
+		return nil, s3err.Status(err, codes.Internal, "")
 	}
 
-	klog.V(1).Infof("volume %s unstaged from %s", req.VolumeId, req.StagingTargetPath)
+	n.stagedMu.Lock()
+	delete(n.staged, req.StagingTargetPath)
+	n.reportStaged()
+	n.stagedMu.Unlock()
+
+	klog.FromContext(ctx).V(1).Info("Volume unstaged", "stagingTargetPath", req.StagingTargetPath)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+/*
+Mount s3 directly to the target path with temporary credentials of the pod's service account.
+Kubelet republishes the volume periodically (requiresRepublish) with a fresh token, which is
+used to refresh the credentials before they expire.
+*/
+func (n *NodeServer) publishWithServiceAccount(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	logger := klog.FromContext(ctx)
+	mounted, err := n.s3.IsMounted(req.TargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	wm := n.workloadMount(req.TargetPath)
+	if mounted && wm != nil && !wm.creds.NeedsRefresh(time.Now(), sts.DefaultRefreshWindow) {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	token, err := sts.TokenForAudience(req.GetVolumeContext(), n.STSAudience)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	backend, bucket, err := n.backend(req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	s3Config := backend.S3.Current()
+	exchanger, err := n.exchanger(backend.Name, s3Config)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "backend %s: %v", backend.Name, err)
+	}
+	opts, err := mountOptions(req.GetVolumeCapability(), req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	creds, err := exchanger.Exchange(ctx, s3Config.Endpoint, token.Token)
+	if err != nil {
+		return nil, s3err.Status(err, codes.Unauthenticated, "")
+	}
+
+	mreq := mount.MountRequest{
+		StagingTargetPath: req.StagingTargetPath,
+		TargetPath:        req.TargetPath,
+
+		Bucket: bucket,
+		Prefix: opts.Prefix,
+		Region: req.VolumeContext["region"],
+
+		AccessKey:    creds.AccessKey,
+		SecretKey:    creds.SecretKey,
+		SessionToken: creds.SessionToken,
+
+		ReadOnly:  req.GetReadonly() || opts.ReadOnly,
+		Ownership: n.podOwnership(ctx, opts.Ownership, req.GetVolumeContext()),
+		Tuning:    opts.Tuning,
+		Options:   req.VolumeContext,
+	}
+	applyS3Config(&mreq, s3Config)
+
+	if mounted {
+		if wm != nil {
+			mreq = wm.req
+			mreq.AccessKey = creds.AccessKey
+			mreq.SecretKey = creds.SecretKey
+			mreq.SessionToken = creds.SessionToken
+		}
+		if err := n.refreshCredentials(mreq); err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+		n.setWorkloadMount(req.TargetPath, &workloadMount{req: mreq, creds: creds})
+		n.trackPublished(req.TargetPath, backend.Name)
+		logger.V(1).Info("Service account credentials refreshed", "targetPath", req.TargetPath, "expiration", creds.Expiration)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if err := n.mountS3(ctx, backend.Name, mreq); err != nil {
+		return nil, s3err.Status(err, codes.Internal, "")
+	}
+	n.setWorkloadMount(req.TargetPath, &workloadMount{req: mreq, creds: creds})
+	n.trackPublished(req.TargetPath, backend.Name)
+	logger.V(1).Info("Volume published with service account credentials", "backend", backend.Name, "targetPath", req.TargetPath)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// unpublishDirect unmounts a workload identity or ephemeral inline volume, which mount-s3 mounted directly to
+// the target path, and removes its credential files.
+func (n *NodeServer) unpublishDirect(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	backend := backendName(req.GetVolumeId())
+	if b, ok := n.ephemeralMount(req.TargetPath); ok {
+		backend = b
+	}
+	if err := n.unmountS3(ctx, backend, req.TargetPath); err != nil {
+		return nil, s3err.Status(err, codes.Internal, "")
+	}
+	n.setWorkloadMount(req.TargetPath, nil)
+	n.setEphemeralMount(req.TargetPath, "")
+	n.untrackPublished(req.TargetPath)
+	klog.FromContext(ctx).V(1).Info("Volume unpublished", "targetPath", req.TargetPath)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// isDirectMount reports whether mount-s3 mounted targetPath directly. The maps are empty after a restart of
+// the plugin, the provider still knows its targets from the credential files.
+func (n *NodeServer) isDirectMount(targetPath string) bool {
+	if n.isWorkloadMount(targetPath) || n.isEphemeralMount(targetPath) {
+		return true
+	}
+	t, ok := n.s3.(mount.TargetTracker)
+	return ok && t.Manages(targetPath)
+}
+
+func (n *NodeServer) isWorkloadMount(targetPath string) bool {
+	return n.workloadMount(targetPath) != nil
+}
+
+func (n *NodeServer) workloadMount(targetPath string) *workloadMount {
+	n.workloadMu.Lock()
+	defer n.workloadMu.Unlock()
+	return n.workloadMounts[targetPath]
+}
+
+// setWorkloadMount records the mount of targetPath, nil removes it.
+func (n *NodeServer) setWorkloadMount(targetPath string, wm *workloadMount) {
+	n.workloadMu.Lock()
+	defer n.workloadMu.Unlock()
+	if wm == nil {
+		delete(n.workloadMounts, targetPath)
+		return
+	}
+	n.workloadMounts[targetPath] = wm
+}
+
+// exchanger returns the STS exchanger of backend, it is rebuilt when the S3Config of the backend changes.
+func (n *NodeServer) exchanger(backend string, s3Config config.S3Config) (sts.Exchanger, error) {
+	n.exchangersMu.Lock()
+	defer n.exchangersMu.Unlock()
+	if e, ok := n.exchangers[backend]; ok && e.config == s3Config {
+		return e.exchanger, nil
+	}
+	transport, err := s3Config.Transport()
+	if err != nil {
+		return nil, err
+	}
+	if n.exchangers == nil {
+		n.exchangers = make(map[string]backendExchanger)
+	}
+	e := backendExchanger{config: s3Config, exchanger: n.STS(transport)}
+	n.exchangers[backend] = e
+	return e.exchanger, nil
+}
+
+// watchRotation observes the credentials of backend b, backends may share their credentials.
+func (n *NodeServer) watchRotation(b *config.Backend) {
+	n.rotationMu.Lock()
+	defer n.rotationMu.Unlock()
+	if n.watchedRotation[b.Credentials] {
+		return
+	}
+	if n.watchedRotation == nil {
+		n.watchedRotation = make(map[*config.CredentialsHolder]bool)
+	}
+	n.watchedRotation[b.Credentials] = true
+	holder := b.Credentials
+	holder.OnChange(func(_ config.S3Credentials, generation uint64) {
+		go n.rotateCredentials(holder, generation)
+	})
+}
+
+/*
+Update all staged volumes to the rotated credentials. The credential files are rewritten in any case,
+with RemountOnRotation the volumes are remounted one after another. Pods which already use the volume
+keep their existing mount until they are restarted. The volumes are updated outside of stagedMu, so
+NodeStageVolume and NodeUnstageVolume of other volumes do not wait for the remounts.
+*/
+func (n *NodeServer) rotateCredentials(holder *config.CredentialsHolder, generation uint64) {
+	creds, current := holder.Get()
+	if current != generation {
+		// a newer rotation is already on its way
+		return
+	}
+	logger := klog.Background().WithValues("credentialsGeneration", generation)
+	n.stagedMu.Lock()
+	pending := map[string]*stagedVolume{}
+	for path, sv := range n.staged {
+		if sv.credentials == holder && sv.generation < generation {
+			pending[path] = sv
+		}
+	}
+	n.stagedMu.Unlock()
+
+	for path, sv := range pending {
+		if !n.inFlight.TryAcquire(sv.volumeID) {
+			// staged or unstaged right now, a stage picks up the current credentials anyway
+			logger.Info("Volume busy, skip credential rotation", "bucket", sv.req.Bucket, "stagingTargetPath", path)
+			continue
+		}
+		n.rotateStaged(logger, path, sv, creds, generation)
+		n.inFlight.Release(sv.volumeID)
+	}
+}
+
+// rotateStaged updates the staged volume sv at path to creds, the volume ID of sv must be acquired.
+func (n *NodeServer) rotateStaged(logger klog.Logger, path string, sv *stagedVolume, creds config.S3Credentials, generation uint64) {
+	if !n.isStaged(path, sv) {
+		return
+	}
+	req := sv.req
+	req.AccessKey = creds.AccessKey
+	req.SecretKey = creds.SecretKey
+	if err := n.refreshCredentials(req); err != nil {
+		logger.Error(err, "Cannot refresh credentials", "bucket", req.Bucket, "stagingTargetPath", path)
+		return
+	}
+	if n.RemountOnRotation {
+		if err := n.remount(sv.backend, req); err != nil {
+			logger.Error(err, "Cannot remount volume", "bucket", req.Bucket, "stagingTargetPath", path)
+			return
+		}
+		logger.Info("Volume remounted with rotated credentials", "bucket", req.Bucket, "stagingTargetPath", path)
+	}
+	n.stagedMu.Lock()
+	defer n.stagedMu.Unlock()
+	if n.staged[path] == sv {
+		n.staged[path] = &stagedVolume{volumeID: sv.volumeID, backend: sv.backend, req: req, credentials: sv.credentials, generation: generation}
+	}
+}
+
+// isStaged reports whether sv is still the staged volume at path.
+func (n *NodeServer) isStaged(path string, sv *stagedVolume) bool {
+	n.stagedMu.Lock()
+	defer n.stagedMu.Unlock()
+	return n.staged[path] == sv
+}
+
+func (n *NodeServer) remount(backend string, req mount.MountRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remountTimeout)
+	defer cancel()
+	if err := n.unmountS3(ctx, backend, req.TargetPath); err != nil {
+		return err
+	}
+	return n.mountS3(ctx, backend, req)
+}
+
+func (n *NodeServer) mountS3(ctx context.Context, backend string, req mount.MountRequest) error {
+	start := time.Now()
+	err := n.s3.Mount(ctx, req)
+	metrics.ObserveMount(backend, metrics.OperationMount, start, err)
+	return err
+}
+
+func (n *NodeServer) unmountS3(ctx context.Context, backend, targetPath string) error {
+	start := time.Now()
+	err := n.s3.Unmount(ctx, targetPath)
+	metrics.ObserveMount(backend, metrics.OperationUnmount, start, err)
+	return err
+}
+
+// reportStaged updates the volume metrics, n.stagedMu must be held.
+func (n *NodeServer) reportStaged() {
+	counts := map[string]int{}
+	for _, sv := range n.staged {
+		counts[sv.backend]++
+	}
+	metrics.SetVolumes(n.NodeID, metrics.VolumeStaged, counts)
+}
+
+func (n *NodeServer) trackPublished(targetPath, backend string) {
+	n.publishedMu.Lock()
+	defer n.publishedMu.Unlock()
+	n.published[targetPath] = backend
+	n.reportPublished()
+}
+
+func (n *NodeServer) untrackPublished(targetPath string) {
+	n.publishedMu.Lock()
+	defer n.publishedMu.Unlock()
+	delete(n.published, targetPath)
+	n.reportPublished()
+}
+
+func (n *NodeServer) reportPublished() {
+	counts := map[string]int{}
+	for _, backend := range n.published {
+		counts[backend]++
+	}
+	metrics.SetVolumes(n.NodeID, metrics.VolumePublished, counts)
+}
+
+// backendName returns the backend of a volume ID for metrics and logs.
+func backendName(volumeID string) string {
+	if name, _ := volume.ParseID(volumeID); name != "" {
+		return name
+	}
+	return config.DefaultBackend
+}
+
+// refreshCredentials rewrites the credential files of an active mount if the provider supports it.
+func (n *NodeServer) refreshCredentials(req mount.MountRequest) error {
+	if r, ok := n.s3.(mount.CredentialRefresher); ok {
+		return r.RefreshCredentials(req)
+	}
+	return nil
+}
+
+// applyS3Config sets the connection settings of the backend for the bucket of req.
+func applyS3Config(req *mount.MountRequest, cfg config.S3Config) {
+	req.Endpoint = cfg.Endpoint
+	req.CABundle = cfg.TLS.CAFile
+	req.VirtualHostedStyle = cfg.VirtualHostedStyle(req.Bucket)
+	req.HTTPProxy = cfg.Proxy.HTTPProxy
+	req.HTTPSProxy = cfg.Proxy.HTTPSProxy
+	req.NoProxy = cfg.Proxy.NoProxy
+}
+
 func getGIDFromVolumeCapability(volCap *csi.VolumeCapability) string {
 	if volCap != nil {
 		mountCap := volCap.GetMount()
@@ -4,13 +4,22 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
 	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
 	"github.com/smou/k8s-csi-s3/pkg/driver/nodeserver"
+	"github.com/smou/k8s-csi-s3/pkg/driver/sts"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 func newTestNodeServer(mount *FakeMountProvider) *nodeserver.NodeServer {
@@ -66,6 +75,29 @@ func TestNodePublishVolume_Success(t *testing.T) {
 
 }
 
+func TestNodePublishVolume_MountErrorRecordsPodEvent(t *testing.T) {
+	mount := NewFakeMountProvider()
+	mount.mountErr = errors.New("mount failed: fuse: device not found")
+	ns := newTestNodeServer(mount)
+	recorder := record.NewFakeRecorder(10)
+	ns.Events = events.NewRecorderFor(recorder)
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-bucket",
+		StagingTargetPath: "/mnt/stage",
+		TargetPath:        "/mnt/test",
+		VolumeContext: map[string]string{
+			events.PodNameKey:      "app-0",
+			events.PodNamespaceKey: "default",
+		},
+	}
+
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	require.Error(t, err)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning FUSEUnavailable Cannot mount volume test-bucket")
+}
+
 func TestNodePublishVolume_Idempotent(t *testing.T) {
 	mountProvider := NewFakeMountProvider()
 	ns := newTestNodeServer(mountProvider)
@@ -172,6 +204,34 @@ func TestNodeStageVolume_Idempotent(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestNodeStageVolume_ConcurrentAborted(t *testing.T) {
+	mp := NewFakeMountProvider()
+	mp.mountStarted = make(chan struct{})
+	mp.unblockMount = make(chan struct{})
+	ns := newTestNodeServer(mp)
+
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+	}
+	done := make(chan error)
+	go func() {
+		_, err := ns.NodeStageVolume(context.Background(), req)
+		done <- err
+	}()
+	<-mp.mountStarted
+
+	_, err := ns.NodeStageVolume(context.Background(), req)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	close(mp.unblockMount)
+	require.NoError(t, <-done)
+
+	// the first call released the volume, a retry succeeds
+	_, err = ns.NodeStageVolume(context.Background(), req)
+	require.NoError(t, err)
+}
+
 func TestNodeStageVolume_MountError(t *testing.T) {
 	mp := NewFakeMountProvider()
 	mp.mountErr = errors.New("mount failed")
@@ -187,6 +247,20 @@ func TestNodeStageVolume_MountError(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestNodeStageVolume_MountErrorCode(t *testing.T) {
+	mp := NewFakeMountProvider()
+	mp.mountErr = errors.New("mount failed: exit status 1 output=Error: HeadBucket failed: Forbidden (403)")
+
+	ns := newTestNodeServer(mp)
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
 func TestNodeUnstageVolume_Success(t *testing.T) {
 	mp := NewFakeMountProvider()
 	mp.mounted["/staging/path"] = true
@@ -233,3 +307,536 @@ func TestNodeUnstageVolume_UnmountError(t *testing.T) {
 	_, err := ns.NodeUnstageVolume(context.Background(), req)
 	require.Error(t, err)
 }
+
+func serviceAccountPublishRequest(token string) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-bucket",
+		StagingTargetPath: "/mnt/stage",
+		TargetPath:        "/mnt/test",
+		VolumeContext: map[string]string{
+			"region":                    "us-east-1",
+			sts.AuthenticationSourceKey: sts.AuthenticationSourceServiceAccount,
+			sts.TokensContextKey:        `{"sts.min.io":{"token":"` + token + `","expirationTimestamp":"2030-01-01T00:00:00Z"}}`,
+		},
+	}
+}
+
+func TestNodeStageVolume_ServiceAccountSkipsStaging(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+		VolumeContext: map[string]string{
+			sts.AuthenticationSourceKey: sts.AuthenticationSourceServiceAccount,
+		},
+	}
+
+	_, err := ns.NodeStageVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Nil(t, mp.lastMount)
+}
+
+func TestNodePublishVolume_ServiceAccount(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	exchanger := NewFakeExchanger(time.Hour)
+	ns.STS = exchanger.New
+
+	_, err := ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token"))
+	require.NoError(t, err)
+
+	require.NotNil(t, mp.lastMount)
+	assert.Equal(t, "/mnt/test", mp.lastMount.TargetPath)
+	assert.Equal(t, "https://minio.local", mp.lastMount.Endpoint)
+	assert.Equal(t, "tmp-access", mp.lastMount.AccessKey)
+	assert.Equal(t, "tmp-session", mp.lastMount.SessionToken)
+	assert.Equal(t, "sa-token", exchanger.lastToken)
+	assert.Equal(t, "https://minio.local", exchanger.lastEndpoint)
+	assert.True(t, exchanger.lastTLS)
+
+	// republish with valid credentials does not exchange again
+	_, err = ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token-2"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, exchanger.calls)
+
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-bucket",
+		TargetPath: "/mnt/test",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/test", mp.lastUnmount)
+	mounted, _ := mp.IsMounted("/mnt/test")
+	assert.False(t, mounted)
+}
+
+func TestNodePublishVolume_ServiceAccountRefresh(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	exchanger := NewFakeExchanger(time.Minute)
+	ns.STS = exchanger.New
+
+	_, err := ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token"))
+	require.NoError(t, err)
+
+	// credentials expire within the refresh window, republish exchanges the new token
+	_, err = ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token-2"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, exchanger.calls)
+	assert.Equal(t, "sa-token-2", exchanger.lastToken)
+}
+
+func TestNodeUnpublishVolume_ServiceAccountAfterRestart(t *testing.T) {
+	bind := NewFakeMountProvider()
+	s3 := TrackingMountProvider{NewFakeMountProvider()}
+	cfg := &config.DriverConfig{
+		NodeID:        "node-1",
+		S3:            config.S3Config{Endpoint: "https://minio.local"},
+		S3Credentials: config.S3Credentials{AccessKey: "access", SecretKey: "secret"},
+	}
+	ns := nodeserver.NewNodeServer(cfg, bind, s3)
+	ns.STS = NewFakeExchanger(time.Hour).New
+	_, err := ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token"))
+	require.NoError(t, err)
+
+	// the restarted plugin knows nothing about the mount, mount-s3 still has to clean up its credentials
+	restarted := nodeserver.NewNodeServer(cfg, bind, s3)
+	_, err = restarted.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-bucket",
+		TargetPath: "/mnt/test",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/test", s3.lastUnmount)
+	assert.Empty(t, bind.lastUnmount)
+}
+
+func TestNodePublishVolume_ServiceAccountMissingToken(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	ns.STS = NewFakeExchanger(time.Hour).New
+
+	req := serviceAccountPublishRequest("sa-token")
+	delete(req.VolumeContext, sts.TokensContextKey)
+
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Nil(t, mp.lastMount)
+}
+
+func TestNodePublishVolume_ServiceAccountExchangeError(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	exchanger := NewFakeExchanger(time.Hour)
+	exchanger.exchangeOK = false
+	ns.STS = exchanger.New
+
+	_, err := ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token"))
+	require.Error(t, err)
+	assert.Nil(t, mp.lastMount)
+}
+
+func TestNodePublishVolume_ServiceAccountDoesNotBlockOtherTargets(t *testing.T) {
+	mp := NewFakeMountProvider()
+	mp.mountStarted = make(chan struct{})
+	mp.unblockMount = make(chan struct{})
+	ns := newTestNodeServer(mp)
+	ns.STS = NewFakeExchanger(time.Hour).New
+
+	done := make(chan error)
+	go func() {
+		_, err := ns.NodePublishVolume(context.Background(), serviceAccountPublishRequest("sa-token"))
+		done <- err
+	}()
+	<-mp.mountStarted
+
+	// a slow mount-s3 of one pod must not stall the other targets of the node
+	unpublished := make(chan error)
+	go func() {
+		_, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "other", TargetPath: "/mnt/other"})
+		unpublished <- err
+	}()
+	select {
+	case err := <-unpublished:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("NodeUnpublishVolume blocked by a mount of another target")
+	}
+
+	close(mp.unblockMount)
+	require.NoError(t, <-done)
+}
+
+func ephemeralPublishRequest(secrets map[string]string) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:   "csi-0123456789abcdef",
+		TargetPath: "/mnt/inline",
+		VolumeContext: map[string]string{
+			nodeserver.EphemeralContextKey: "true",
+			nodeserver.EphemeralBucketKey:  "job-data",
+			nodeserver.EphemeralPrefixKey:  "/runs/42",
+		},
+		Secrets: secrets,
+	}
+}
+
+func TestNodePublishVolume_Ephemeral(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	req := ephemeralPublishRequest(map[string]string{"MINIO_ACCESSKEY": "job-access", "MINIO_SECRETKEY": "job-secret"})
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+
+	require.NotNil(t, mp.lastMount)
+	assert.Equal(t, "/mnt/inline", mp.lastMount.TargetPath)
+	assert.Empty(t, mp.lastMount.StagingTargetPath)
+	assert.Equal(t, "job-data", mp.lastMount.Bucket)
+	assert.Equal(t, "runs/42/", mp.lastMount.Prefix)
+	assert.Equal(t, "https://minio.local", mp.lastMount.Endpoint)
+	assert.Equal(t, "job-access", mp.lastMount.AccessKey)
+	assert.Equal(t, "job-secret", mp.lastMount.SecretKey)
+
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   req.VolumeId,
+		TargetPath: "/mnt/inline",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/inline", mp.lastUnmount)
+	mounted, _ := mp.IsMounted("/mnt/inline")
+	assert.False(t, mounted)
+}
+
+func TestNodePublishVolume_EphemeralDoesNotBlockOtherTargets(t *testing.T) {
+	mp := NewFakeMountProvider()
+	mp.mountStarted = make(chan struct{})
+	mp.unblockMount = make(chan struct{})
+	ns := newTestNodeServer(mp)
+
+	done := make(chan error)
+	go func() {
+		_, err := ns.NodePublishVolume(context.Background(),
+			ephemeralPublishRequest(map[string]string{"MINIO_ACCESSKEY": "job-access", "MINIO_SECRETKEY": "job-secret"}))
+		done <- err
+	}()
+	<-mp.mountStarted
+
+	unpublished := make(chan error)
+	go func() {
+		_, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "other", TargetPath: "/mnt/other"})
+		unpublished <- err
+	}()
+	select {
+	case err := <-unpublished:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("NodeUnpublishVolume blocked by a mount of another target")
+	}
+
+	close(mp.unblockMount)
+	require.NoError(t, <-done)
+}
+
+func TestNodeUnpublishVolume_EphemeralAfterRestart(t *testing.T) {
+	bind := NewFakeMountProvider()
+	s3 := TrackingMountProvider{NewFakeMountProvider()}
+	cfg := &config.DriverConfig{
+		NodeID:        "node-1",
+		S3:            config.S3Config{Endpoint: "https://minio.local"},
+		S3Credentials: config.S3Credentials{AccessKey: "access", SecretKey: "secret"},
+	}
+	req := ephemeralPublishRequest(map[string]string{"MINIO_ACCESSKEY": "job-access", "MINIO_SECRETKEY": "job-secret"})
+	_, err := nodeserver.NewNodeServer(cfg, bind, s3).NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+
+	restarted := nodeserver.NewNodeServer(cfg, bind, s3)
+	_, err = restarted.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   req.VolumeId,
+		TargetPath: "/mnt/inline",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/inline", s3.lastUnmount)
+	assert.Empty(t, bind.lastUnmount)
+}
+
+func TestNodePublishVolume_EphemeralDriverCredentials(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	// without nodePublishSecretRef only if explicitly enabled
+	_, err := ns.NodePublishVolume(context.Background(), ephemeralPublishRequest(nil))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Nil(t, mp.lastMount)
+
+	ns.EphemeralDriverCredentials = true
+	_, err = ns.NodePublishVolume(context.Background(), ephemeralPublishRequest(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "access", mp.lastMount.AccessKey)
+}
+
+func TestNodePublishVolume_EphemeralInvalidAttributes(t *testing.T) {
+	tests := map[string]map[string]string{
+		"missing bucket":  {nodeserver.EphemeralBucketKey: ""},
+		"invalid bucket":  {nodeserver.EphemeralBucketKey: "Job_Data"},
+		"prefix escapes":  {nodeserver.EphemeralPrefixKey: "runs/../other"},
+		"unknown backend": {"backend": "missing"},
+	}
+	for name, attrs := range tests {
+		t.Run(name, func(t *testing.T) {
+			mp := NewFakeMountProvider()
+			ns := newTestNodeServer(mp)
+
+			req := ephemeralPublishRequest(map[string]string{"MINIO_ACCESSKEY": "a", "MINIO_SECRETKEY": "s"})
+			for k, v := range attrs {
+				req.VolumeContext[k] = v
+			}
+			_, err := ns.NodePublishVolume(context.Background(), req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Nil(t, mp.lastMount)
+		})
+	}
+}
+
+func TestNodeStageVolume_Ownership(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags:       []string{"uid=1000", "file-mode=640"},
+				VolumeMountGroup: "3000",
+			}},
+		},
+		// StorageClass parameters, the mountOptions take precedence
+		VolumeContext: map[string]string{"uid": "500", "dirMode": "0770"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, mount.Ownership{UID: "1000", GID: "3000", DirMode: "0770", FileMode: "0640"}, mp.lastMount.Ownership)
+}
+
+func TestNodeStageVolume_InvalidOwnership(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+		VolumeContext:     map[string]string{"fileMode": "rw-r--r--"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Nil(t, mp.lastMount)
+}
+
+func TestNodeStageVolume_MountOptions(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	stage := func(flags ...string) error {
+		_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          "bucket-1",
+			StagingTargetPath: "/staging/path",
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}},
+			},
+		})
+		return err
+	}
+
+	// a tenant cannot override the connection settings
+	err := stage("--endpoint-url=http://attacker.local")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Nil(t, mp.lastMount)
+
+	require.NoError(t, stage("ro", "prefix=logs", "cache=disk", "max-threads=16"))
+	assert.True(t, mp.lastMount.ReadOnly)
+	assert.Equal(t, "logs/", mp.lastMount.Prefix)
+	assert.Equal(t, mount.Tuning{Cache: true, MaxThreads: 16}, mp.lastMount.Tuning)
+}
+
+func TestNodePublishVolume_EphemeralOwnerFromPod(t *testing.T) {
+	uid := int64(1001)
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	ns.Kube = fake.NewClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-0", Namespace: "batch"},
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{RunAsUser: &uid},
+			Containers:      []corev1.Container{{Name: "job"}, {Name: "sidecar"}},
+		},
+	})
+
+	req := ephemeralPublishRequest(map[string]string{"MINIO_ACCESSKEY": "a", "MINIO_SECRETKEY": "s"})
+	req.VolumeContext[events.PodNameKey] = "job-0"
+	req.VolumeContext[events.PodNamespaceKey] = "batch"
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "1001", mp.lastMount.Ownership.UID)
+}
+
+func TestNodeStageVolume_CredentialRotation(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	ns.RemountOnRotation = true
+
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+	}
+	_, err := ns.NodeStageVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "access", mp.LastMount().AccessKey)
+
+	ns.Backends.Default().Credentials.Set(config.S3Credentials{AccessKey: "rotated", SecretKey: "rotated-secret"})
+
+	assert.Eventually(t, func() bool {
+		m := mp.LastMount()
+		return m.AccessKey == "rotated" && m.SecretKey == "rotated-secret"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "/staging/path", mp.LastMount().TargetPath)
+	mounted, _ := mp.IsMounted("/staging/path")
+	assert.True(t, mounted)
+}
+
+func TestNodeStageVolume_RotationDoesNotBlockUnstage(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	ns.RemountOnRotation = true
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/rotated",
+	})
+	require.NoError(t, err)
+	// mounted with its own secret, not affected by the rotation
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-2",
+		StagingTargetPath: "/staging/other",
+		Secrets:           map[string]string{"MINIO_ACCESSKEY": "volume-access", "MINIO_SECRETKEY": "volume-secret"},
+	})
+	require.NoError(t, err)
+
+	mp.mountStarted = make(chan struct{})
+	mp.unblockMount = make(chan struct{})
+	ns.Backends.Default().Credentials.Set(config.S3Credentials{AccessKey: "rotated", SecretKey: "rotated-secret"})
+	<-mp.mountStarted
+
+	unstaged := make(chan error)
+	go func() {
+		_, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+			VolumeId:          "bucket-2",
+			StagingTargetPath: "/staging/other",
+		})
+		unstaged <- err
+	}()
+	select {
+	case err := <-unstaged:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("NodeUnstageVolume blocked by the remount of another volume")
+	}
+	close(mp.unblockMount)
+	assert.Eventually(t, func() bool { return mp.LastMount().AccessKey == "rotated" }, time.Second, 10*time.Millisecond)
+}
+
+func TestNodeStageVolume_UsesRotatedCredentials(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	ns.Backends.Default().Credentials.Set(config.S3Credentials{AccessKey: "rotated", SecretKey: "rotated-secret"})
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", mp.LastMount().AccessKey)
+}
+
+func TestNodeStageVolume_Backend(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	ns.Backends = config.NewBackends(map[string]config.BackendSpec{
+		config.DefaultBackend: {S3: config.S3Config{Endpoint: "https://minio.local"}},
+		"fast": {
+			S3:                config.S3Config{Endpoint: "https://minio-fast.local", TLS: config.TLSConfig{CAFile: "/etc/csi-s3/tls/ca.crt"}},
+			CredentialsSecret: "fast-secret",
+		},
+	}, map[string]config.S3Credentials{
+		config.DefaultBackend: {AccessKey: "access", SecretKey: "secret"},
+		"fast":                {AccessKey: "fast-access", SecretKey: "fast-secret"},
+	})
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "fast/csi-bucket-1",
+		StagingTargetPath: "/staging/path",
+	})
+	require.NoError(t, err)
+	m := mp.LastMount()
+	assert.Equal(t, "csi-bucket-1", m.Bucket)
+	assert.Equal(t, "https://minio-fast.local", m.Endpoint)
+	assert.Equal(t, "fast-access", m.AccessKey)
+	assert.Equal(t, "/etc/csi-s3/tls/ca.crt", m.CABundle)
+
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "unknown/csi-bucket-1",
+		StagingTargetPath: "/staging/other",
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestNodeGetInfo_Topology(t *testing.T) {
+	ns := newTestNodeServer(NewFakeMountProvider())
+	ns.Topology = config.TopologyConfig{Key: config.DefaultTopologyKey}
+	ns.Kube = fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-1",
+		Labels: map[string]string{config.DefaultTopologyKey: "zone-a"},
+	}})
+
+	resp, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{config.DefaultTopologyKey: "zone-a"}, resp.AccessibleTopology.GetSegments())
+
+	// a configured zone takes precedence over the label
+	ns.Topology.Zone = "zone-b"
+	resp, err = ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "zone-b", resp.AccessibleTopology.GetSegments()[config.DefaultTopologyKey])
+}
+
+func TestNodeStageVolume_PerVolumeCredentials(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+	ns.RemountOnRotation = true
+
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+		VolumeContext:     map[string]string{"perVolumeCredentials": "true"},
+		Secrets:           map[string]string{"MINIO_ACCESSKEY": "volume-access", "MINIO_SECRETKEY": "volume-secret"},
+	}
+	_, err := ns.NodeStageVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "volume-access", mp.LastMount().AccessKey)
+	assert.Equal(t, "volume-secret", mp.LastMount().SecretKey)
+
+	// the credentials of the volume are not rotated with those of the backend
+	ns.Backends.Default().Credentials.Set(config.S3Credentials{AccessKey: "rotated", SecretKey: "rotated-secret"})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "volume-access", mp.LastMount().AccessKey)
+}
+
+func TestNodeStageVolume_PerVolumeCredentialsMissingSecret(t *testing.T) {
+	mp := NewFakeMountProvider()
+	ns := newTestNodeServer(mp)
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "bucket-1",
+		StagingTargetPath: "/staging/path",
+		VolumeContext:     map[string]string{"perVolumeCredentials": "true"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Nil(t, mp.LastMount(), "never falls back to the credentials of the backend")
+}
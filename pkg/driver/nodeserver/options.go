@@ -0,0 +1,80 @@
+package nodeserver
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/smou/k8s-csi-s3/pkg/driver/events"
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+/*
+Resolve the mount options of a volume. The mountOptions of the PV or StorageClass are validated against an
+allowlist and override owner and permissions of the volume context (StorageClass parameters or volume
+attributes), the fsGroup of the pod (VolumeMountGroup) is the default group.
+*/
+func mountOptions(volCap *csi.VolumeCapability, volumeContext map[string]string) (mount.MountOptions, error) {
+	opts, err := mount.ParseMountOptions(volCap.GetMount().GetMountFlags())
+	if err != nil {
+		return mount.MountOptions{}, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	owner, err := mount.ParseOwnership(volumeContext)
+	if err != nil {
+		return mount.MountOptions{}, status.Errorf(codes.InvalidArgument, "volume context: %v", err)
+	}
+	opts.Ownership = owner.Merge(opts.Ownership)
+	if opts.Ownership.GID == "" {
+		group, err := mount.ParseOwnership(map[string]string{mount.GIDKey: getGIDFromVolumeCapability(volCap)})
+		if err != nil {
+			return mount.MountOptions{}, status.Errorf(codes.InvalidArgument, "volumeMountGroup: %v", err)
+		}
+		opts.Ownership.GID = group.GID
+	}
+	return opts, nil
+}
+
+/*
+Use the user of the pod as owner if the volume sets none. Only volumes which are mounted directly to the
+target path belong to a single pod, staged volumes are shared by all pods on the node. The pod is read
+from the pod info kubelet passes with podInfoOnMount, without it the mount-s3 default is kept.
+*/
+func (n *NodeServer) podOwnership(ctx context.Context, o mount.Ownership, volumeContext map[string]string) mount.Ownership {
+	name, namespace := volumeContext[events.PodNameKey], volumeContext[events.PodNamespaceKey]
+	if o.UID != "" || n.Kube == nil || name == "" {
+		return o
+	}
+	pod, err := n.Kube.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		klog.FromContext(ctx).V(2).Info("Cannot read pod, uid of the mount not derived", "pod", klog.KRef(namespace, name), "err", err)
+		return o
+	}
+	if uid := runAsUser(pod); uid != nil {
+		o.UID = strconv.FormatInt(*uid, 10)
+	}
+	return o
+}
+
+// runAsUser returns the user all containers of pod run as, nil if it is not set or differs between containers.
+func runAsUser(pod *corev1.Pod) *int64 {
+	var podUID, uid *int64
+	if sc := pod.Spec.SecurityContext; sc != nil {
+		podUID = sc.RunAsUser
+	}
+	for _, c := range pod.Spec.Containers {
+		containerUID := podUID
+		if c.SecurityContext != nil && c.SecurityContext.RunAsUser != nil {
+			containerUID = c.SecurityContext.RunAsUser
+		}
+		if containerUID == nil || (uid != nil && *uid != *containerUID) {
+			return nil
+		}
+		uid = containerUID
+	}
+	return uid
+}
@@ -0,0 +1,54 @@
+package nodeserver_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/sts"
+)
+
+type FakeExchanger struct {
+	mu sync.Mutex
+
+	calls        int
+	validFor     time.Duration
+	lastToken    string
+	lastEndpoint string
+	lastTLS      bool
+	exchangeOK   bool
+}
+
+func NewFakeExchanger(validFor time.Duration) *FakeExchanger {
+	return &FakeExchanger{
+		validFor:   validFor,
+		exchangeOK: true,
+	}
+}
+
+// New implements sts.ExchangerFactory, all endpoints share f.
+func (f *FakeExchanger) New(transport http.RoundTripper) sts.Exchanger {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastTLS = transport != nil
+	return f
+}
+
+func (f *FakeExchanger) Exchange(ctx context.Context, endpoint, token string) (*sts.Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	f.lastToken = token
+	f.lastEndpoint = endpoint
+	if !f.exchangeOK {
+		return nil, errors.New("AccessDenied")
+	}
+	return &sts.Credentials{
+		AccessKey:    "tmp-access",
+		SecretKey:    "tmp-secret",
+		SessionToken: "tmp-session",
+		Expiration:   time.Now().Add(f.validFor),
+	}, nil
+}
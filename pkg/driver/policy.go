@@ -0,0 +1,72 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/minio"
+)
+
+// PolicySpecs returns the policy spec of every backend for the current config, sorted by backend.
+// sharedBuckets are mounted by the nodes of every backend in addition to the provisioned buckets.
+func (d *Driver) PolicySpecs(sharedBuckets []string) []policy.Spec {
+	backends := d.Config.Backends()
+	var specs []policy.Spec
+	for _, name := range backends.Names() {
+		b, err := backends.Get(name)
+		if err != nil {
+			continue
+		}
+		specs = append(specs, policy.Spec{
+			Backend:       b.Name,
+			BucketPrefix:  b.S3.Current().BucketPrefix,
+			SharedBuckets: sharedBuckets,
+			Features: policy.Features{
+				// the controller tags new buckets whenever it can read the cluster ID
+				Tagging:           true,
+				GarbageCollection: d.Config.GC.Interval > 0,
+			},
+		})
+	}
+	return specs
+}
+
+// ApplyPolicies creates the policies of specs with the admin credentials on the endpoint of each backend.
+func (d *Driver) ApplyPolicies(ctx context.Context, specs []policy.Spec, admin config.S3Credentials, createUsers bool) ([]policy.User, error) {
+	if admin.AccessKey == "" || admin.SecretKey == "" {
+		return nil, errors.New("admin credentials missing")
+	}
+	backends := d.Config.Backends()
+	var users []policy.User
+	for _, spec := range specs {
+		b, err := backends.Get(spec.Backend)
+		if err != nil {
+			return users, err
+		}
+		cfg := b.S3.Current()
+		transport, err := cfg.Transport()
+		if err != nil {
+			return users, err
+		}
+		client, err := minio.NewAdminClient(&store.StoreConfig{
+			EndpointURL: cfg.Endpoint,
+			Region:      cfg.Region,
+			AccessKey:   admin.AccessKey,
+			SecretKey:   admin.SecretKey,
+			Transport:   transport,
+		})
+		if err != nil {
+			return users, fmt.Errorf("backend %s: %w", spec.Backend, err)
+		}
+		created, err := policy.Apply(ctx, client, spec, createUsers)
+		users = append(users, created...)
+		if err != nil {
+			return users, fmt.Errorf("backend %s: %w", spec.Backend, err)
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+)
+
+const (
+	// AccessParameterKey is the StorageClass parameter and volume context key of the bucket policy
+	AccessParameterKey = "accessPolicy"
+
+	// AccessPrivate removes any bucket policy, the default
+	AccessPrivate = "private"
+	// AccessPublicRead allows anonymous downloads of all objects
+	AccessPublicRead = "public-read"
+	// AccessPublicReadPrefix allows anonymous downloads of the objects below a prefix, public-read-prefix:<p>
+	AccessPublicReadPrefix = "public-read-prefix:"
+	// AccessConfigMap reads a custom bucket policy from the key ConfigMapKey of a ConfigMap in the namespace
+	// of the driver, configMap:<name>
+	AccessConfigMap = "configMap:"
+
+	// ConfigMapKey holds the policy JSON, BucketPlaceholder is replaced with the name of the bucket
+	ConfigMapKey      = "policy.json"
+	BucketPlaceholder = "${bucket}"
+)
+
+// Access is the parsed accessPolicy parameter.
+type Access struct {
+	// Value is the parameter as given, reported in the volume context
+	Value string
+	// Prefix of public-read-prefix, ends with a slash
+	Prefix string
+	// ConfigMap of a custom policy
+	ConfigMap string
+}
+
+// ParseAccess validates the accessPolicy parameter, an empty value is private.
+func ParseAccess(value string) (Access, error) {
+	a := Access{Value: value}
+	switch {
+	case value == "" || value == AccessPrivate:
+		a.Value = AccessPrivate
+	case value == AccessPublicRead:
+	case strings.HasPrefix(value, AccessPublicReadPrefix):
+		prefix, err := mount.NormalizePrefix(strings.TrimPrefix(value, AccessPublicReadPrefix))
+		if err != nil || prefix == "" {
+			return Access{}, fmt.Errorf("invalid %s %q: prefix missing or invalid", AccessParameterKey, value)
+		}
+		a.Prefix = prefix
+	case strings.HasPrefix(value, AccessConfigMap):
+		a.ConfigMap = strings.TrimPrefix(value, AccessConfigMap)
+		if a.ConfigMap == "" {
+			return Access{}, fmt.Errorf("invalid %s %q: ConfigMap name missing", AccessParameterKey, value)
+		}
+	default:
+		return Access{}, fmt.Errorf("invalid %s %q, expected %s, %s, %s<prefix> or %s<name>", AccessParameterKey, value,
+			AccessPrivate, AccessPublicRead, AccessPublicReadPrefix, AccessConfigMap)
+	}
+	return a, nil
+}
+
+// Private reports whether the bucket has no policy.
+func (a Access) Private() bool {
+	return a.Value == AccessPrivate
+}
+
+// Document returns the bucket policy of the predefined access policies. Anonymous users may only download
+// objects, listing the bucket stays private.
+func (a Access) Document(bucket string) Document {
+	objects := bucketARN(bucket) + "/" + a.Prefix + "*"
+	statement := allow([]string{"s3:GetObject"}, objects)
+	statement.Principal = map[string][]string{"AWS": {"*"}}
+	return document([]Statement{statement})
+}
+
+// Custom returns the custom policy raw with BucketPlaceholder replaced by bucket. It must be valid JSON.
+func Custom(raw, bucket string) (string, error) {
+	doc := strings.ReplaceAll(raw, BucketPlaceholder, bucket)
+	if !json.Valid([]byte(doc)) {
+		return "", fmt.Errorf("%s is not valid JSON", ConfigMapKey)
+	}
+	return doc, nil
+}
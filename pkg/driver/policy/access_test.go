@@ -0,0 +1,44 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+)
+
+func TestParseAccess(t *testing.T) {
+	a, err := policy.ParseAccess("")
+	require.NoError(t, err)
+	assert.True(t, a.Private())
+	assert.Equal(t, "private", a.Value)
+
+	a, err = policy.ParseAccess("public-read-prefix:assets")
+	require.NoError(t, err)
+	assert.Equal(t, "assets/", a.Prefix)
+	doc := a.Document("csi-pvc-1")
+	require.Len(t, doc.Statement, 1)
+	assert.Equal(t, map[string][]string{"AWS": {"*"}}, doc.Statement[0].Principal)
+	assert.Equal(t, []string{"s3:GetObject"}, doc.Statement[0].Action)
+	assert.Equal(t, []string{"arn:aws:s3:::csi-pvc-1/assets/*"}, doc.Statement[0].Resource)
+
+	a, err = policy.ParseAccess("configMap:assets-policy")
+	require.NoError(t, err)
+	assert.Equal(t, "assets-policy", a.ConfigMap)
+
+	for _, invalid := range []string{"public", "public-read-prefix:", "public-read-prefix:../x", "configMap:"} {
+		_, err := policy.ParseAccess(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCustom(t *testing.T) {
+	doc, err := policy.Custom(`{"Resource": ["arn:aws:s3:::${bucket}/*"]}`, "csi-pvc-1")
+	require.NoError(t, err)
+	assert.Equal(t, `{"Resource": ["arn:aws:s3:::csi-pvc-1/*"]}`, doc)
+
+	_, err = policy.Custom(`{"Resource":`, "csi-pvc-1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/minio/madmin-go/v3"
+	"k8s.io/klog/v2"
+)
+
+// Admin is the part of the MinIO admin API used to apply the policies.
+type Admin interface {
+	AddCannedPolicy(ctx context.Context, policyName string, policy []byte) error
+	GetUserInfo(ctx context.Context, name string) (madmin.UserInfo, error)
+	AddUser(ctx context.Context, accessKey, secretKey string) error
+	AttachPolicy(ctx context.Context, r madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error)
+}
+
+// User is a MinIO user the policy of the same name is attached to.
+type User struct {
+	Backend   string
+	AccessKey string
+	// SecretKey is only set for users created by Apply, existing users keep their secret key
+	SecretKey string
+}
+
+// Apply creates or updates the controller and node policies of spec. With createUsers a user named like
+// each policy is created if missing and the policy is attached to it.
+func Apply(ctx context.Context, admin Admin, spec Spec, createUsers bool) ([]User, error) {
+	logger := klog.FromContext(ctx).WithValues("backend", spec.Backend)
+	policies := []struct {
+		name string
+		doc  Document
+	}{
+		{spec.ControllerPolicy(), spec.Controller()},
+		{spec.NodePolicy(), spec.Node()},
+	}
+	var users []User
+	for _, p := range policies {
+		if err := admin.AddCannedPolicy(ctx, p.name, p.doc.JSON()); err != nil {
+			return users, fmt.Errorf("cannot create policy %s: %w", p.name, err)
+		}
+		logger.Info("Policy applied", "policy", p.name)
+		if !createUsers {
+			continue
+		}
+		user, err := ensureUser(ctx, admin, p.name)
+		if err != nil {
+			return users, err
+		}
+		user.Backend = spec.Backend
+		users = append(users, user)
+		logger.Info("Policy attached", "policy", p.name, "user", user.AccessKey, "created", user.SecretKey != "")
+	}
+	return users, nil
+}
+
+// ensureUser creates the user name with a random secret key if it does not exist and attaches the policy name.
+func ensureUser(ctx context.Context, admin Admin, name string) (User, error) {
+	user := User{AccessKey: name}
+	if _, err := admin.GetUserInfo(ctx, name); err != nil {
+		if madmin.ToErrorResponse(err).Code != "XMinioAdminNoSuchUser" {
+			return user, fmt.Errorf("cannot read user %s: %w", name, err)
+		}
+		user.SecretKey = rand.Text()
+		if err := admin.AddUser(ctx, name, user.SecretKey); err != nil {
+			return User{}, fmt.Errorf("cannot create user %s: %w", name, err)
+		}
+	}
+	_, err := admin.AttachPolicy(ctx, madmin.PolicyAssociationReq{Policies: []string{name}, User: name})
+	// an attached policy is reported as error
+	if err != nil && madmin.ToErrorResponse(err).Code != "XMinioAdminPolicyChangeAlreadyApplied" {
+		return user, fmt.Errorf("cannot attach policy %s to user %s: %w", name, name, err)
+	}
+	return user, nil
+}
@@ -0,0 +1,160 @@
+// Package policy renders the minimal MinIO policies of the controller and the node plugin and applies them
+// through the MinIO admin API.
+package policy
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/health"
+)
+
+const (
+	// ControllerName and NodeName are the names of the policies and users of the default backend
+	ControllerName = "csi-s3-controller"
+	NodeName       = "csi-s3-node"
+
+	// VolumePrefix is the name prefix of the volumes created by the external-provisioner
+	VolumePrefix = "pvc"
+)
+
+// objectActions are needed by mount-s3 to read and write objects.
+var objectActions = []string{"s3:AbortMultipartUpload", "s3:DeleteObject", "s3:GetObject", "s3:PutObject"}
+
+// Document is an IAM policy document as understood by MinIO.
+type Document struct {
+	Version   string      `json:"Version"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement allows Action on Resource, to Principal in bucket policies.
+type Statement struct {
+	Effect    string                         `json:"Effect"`
+	Principal map[string][]string            `json:"Principal,omitempty"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// JSON renders the document indented, as expected by mc admin policy create.
+func (d Document) JSON() []byte {
+	out, _ := json.MarshalIndent(d, "", "    ")
+	return out
+}
+
+// Features of the driver which need additional permissions.
+type Features struct {
+	// Tagging of new buckets with their owner
+	Tagging bool
+	// GarbageCollection lists all buckets to find orphans
+	GarbageCollection bool
+	// ServiceAccounts of single volumes are created by the controller, they can use at most the permissions
+	// of the controller
+	ServiceAccounts bool
+	// AccessPolicies of StorageClasses with accessPolicy are set on the buckets
+	AccessPolicies bool
+}
+
+// Spec describes the buckets of one backend.
+type Spec struct {
+	// Backend is the name of the backend profile
+	Backend      string
+	BucketPrefix string
+	// SharedBuckets are mounted by the nodes in addition to the provisioned buckets, e.g. by static or
+	// ephemeral inline volumes with the driver credentials. * matches any sequence of characters.
+	SharedBuckets []string
+	Features      Features
+}
+
+// ControllerPolicy returns the name of the controller policy, suffixed with the backend if it is not the default.
+func (s Spec) ControllerPolicy() string {
+	return policyName(ControllerName, s.Backend)
+}
+
+// NodePolicy returns the name of the node policy, suffixed with the backend if it is not the default.
+func (s Spec) NodePolicy() string {
+	return policyName(NodeName, s.Backend)
+}
+
+func policyName(name, backend string) string {
+	if backend == "" || backend == config.DefaultBackend {
+		return name
+	}
+	return name + "-" + backend
+}
+
+// BucketPattern matches the names of the buckets created by CreateVolume.
+func (s Spec) BucketPattern() string {
+	if s.BucketPrefix == "" {
+		return VolumePrefix + "-*"
+	}
+	return s.BucketPrefix + "-*"
+}
+
+// Controller returns the policy of the controller, which creates, tags and deletes the buckets. It is granted
+// access to the objects only to pass it on to the service accounts of the volumes.
+func (s Spec) Controller() Document {
+	var statements []Statement
+	if s.Features.GarbageCollection {
+		statements = append(statements, allow([]string{"s3:ListAllMyBuckets"}, "arn:aws:s3:::*"))
+	}
+	// HeadBucket of the health check
+	statements = append(statements, allow([]string{"s3:ListBucket"}, bucketARN(health.ProbeBucket)))
+
+	actions := []string{"s3:CreateBucket", "s3:DeleteBucket", "s3:ForceDeleteBucket", "s3:ListBucket"}
+	if s.Features.Tagging {
+		actions = append(actions, "s3:GetBucketTagging", "s3:PutBucketTagging")
+	}
+	if s.Features.AccessPolicies {
+		actions = append(actions, "s3:DeleteBucketPolicy", "s3:PutBucketPolicy")
+	}
+	statements = append(statements, allow(actions, bucketARN(s.BucketPattern())))
+	if s.Features.ServiceAccounts {
+		statements = append(statements, allow(objectActions, bucketARN(s.BucketPattern())+"/*"))
+	}
+	return document(statements)
+}
+
+// Node returns the policy of the node plugin, which reads and writes the objects of the mounted buckets.
+func (s Spec) Node() Document {
+	buckets := append([]string{s.BucketPattern()}, s.SharedBuckets...)
+	slices.Sort(buckets)
+	buckets = slices.Compact(buckets)
+	var bucketARNs, objectARNs []string
+	for _, b := range buckets {
+		bucketARNs = append(bucketARNs, bucketARN(b))
+		objectARNs = append(objectARNs, bucketARN(b)+"/*")
+	}
+	return document([]Statement{
+		allow([]string{"s3:ListBucket"}, bucketARNs...),
+		allow(objectActions, objectARNs...),
+	})
+}
+
+// Volume returns the inline policy of the service account of a single volume: the objects of bucket,
+// limited to prefix if it is not empty.
+func Volume(bucket, prefix string) Document {
+	list := allow([]string{"s3:ListBucket"}, bucketARN(bucket))
+	objects := bucketARN(bucket) + "/*"
+	if prefix != "" {
+		list.Condition = map[string]map[string][]string{"StringLike": {"s3:prefix": {prefix + "*"}}}
+		objects = bucketARN(bucket) + "/" + prefix + "*"
+	}
+	return document([]Statement{
+		list,
+		allow(objectActions, objects),
+	})
+}
+
+func document(statements []Statement) Document {
+	return Document{Version: "2012-10-17", Statement: statements}
+}
+
+func allow(actions []string, resources ...string) Statement {
+	return Statement{Effect: "Allow", Action: actions, Resource: resources}
+}
+
+func bucketARN(bucket string) string {
+	return "arn:aws:s3:::" + bucket
+}
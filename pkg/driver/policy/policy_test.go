@@ -0,0 +1,87 @@
+package policy_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/storetest"
+)
+
+func TestSpec_Controller(t *testing.T) {
+	spec := policy.Spec{Backend: "default", BucketPrefix: "csi"}
+	doc := spec.Controller()
+	require.Len(t, doc.Statement, 2)
+	assert.Equal(t, []string{"arn:aws:s3:::csi-s3-health-probe"}, doc.Statement[0].Resource)
+	assert.Equal(t, []string{"arn:aws:s3:::csi-*"}, doc.Statement[1].Resource)
+	assert.Contains(t, doc.Statement[1].Action, "s3:ForceDeleteBucket")
+	assert.NotContains(t, doc.Statement[1].Action, "s3:PutBucketTagging")
+	for _, s := range doc.Statement {
+		assert.NotContains(t, s.Action, "s3:GetObject", "the controller never reads objects")
+	}
+
+	spec.Features = policy.Features{Tagging: true, GarbageCollection: true}
+	doc = spec.Controller()
+	require.Len(t, doc.Statement, 3)
+	assert.Equal(t, []string{"s3:ListAllMyBuckets"}, doc.Statement[0].Action)
+	assert.Contains(t, doc.Statement[2].Action, "s3:PutBucketTagging")
+
+	// service accounts inherit at most the permissions of the controller
+	spec.Features = policy.Features{ServiceAccounts: true}
+	doc = spec.Controller()
+	require.Len(t, doc.Statement, 3)
+	assert.Equal(t, []string{"arn:aws:s3:::csi-*/*"}, doc.Statement[2].Resource)
+	assert.Contains(t, doc.Statement[2].Action, "s3:GetObject")
+
+	spec.Features = policy.Features{AccessPolicies: true}
+	doc = spec.Controller()
+	assert.Contains(t, doc.Statement[1].Action, "s3:PutBucketPolicy")
+}
+
+func TestVolume(t *testing.T) {
+	doc := policy.Volume("csi-pvc-1", "")
+	require.Len(t, doc.Statement, 2)
+	assert.Nil(t, doc.Statement[0].Condition)
+	assert.Equal(t, []string{"arn:aws:s3:::csi-pvc-1/*"}, doc.Statement[1].Resource)
+}
+
+func TestSpec_Node(t *testing.T) {
+	spec := policy.Spec{Backend: "fast", SharedBuckets: []string{"static-*", "pvc-*"}}
+	assert.Equal(t, "csi-s3-node-fast", spec.NodePolicy())
+	doc := spec.Node()
+	require.Len(t, doc.Statement, 2)
+	assert.Equal(t, []string{"arn:aws:s3:::pvc-*", "arn:aws:s3:::static-*"}, doc.Statement[0].Resource)
+	assert.Equal(t, []string{"arn:aws:s3:::pvc-*/*", "arn:aws:s3:::static-*/*"}, doc.Statement[1].Resource)
+	assert.NotContains(t, doc.Statement[1].Action, "s3:DeleteBucket")
+
+	var parsed map[string]any
+	require.NoError(t, json.Unmarshal(doc.JSON(), &parsed))
+	assert.Equal(t, "2012-10-17", parsed["Version"])
+}
+
+func TestApply(t *testing.T) {
+	admin := storetest.NewAdmin()
+	require.NoError(t, admin.AddUser(context.Background(), "csi-s3-controller", "existing"))
+	spec := policy.Spec{Backend: "default", BucketPrefix: "csi"}
+
+	users, err := policy.Apply(context.Background(), admin, spec, true)
+	require.NoError(t, err)
+	assert.Contains(t, admin.Policies(), "csi-s3-controller")
+	assert.Contains(t, admin.Policies(), "csi-s3-node")
+	require.Len(t, users, 2)
+	// existing users keep their secret key
+	assert.Empty(t, users[0].SecretKey)
+	assert.Equal(t, "existing", admin.Users()["csi-s3-controller"])
+	assert.NotEmpty(t, users[1].SecretKey)
+	assert.Equal(t, users[1].SecretKey, admin.Users()["csi-s3-node"])
+	assert.Equal(t, []string{"csi-s3-node"}, admin.Attached("csi-s3-node"))
+
+	// applying again only updates the policies
+	users, err = policy.Apply(context.Background(), admin, spec, true)
+	require.NoError(t, err)
+	assert.Empty(t, users[1].SecretKey)
+}
@@ -0,0 +1,243 @@
+// Package s3err classifies errors of the S3 API and of mount-s3 and maps them to CSI gRPC codes.
+package s3err
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/minio/minio-go/v7"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kind is the class of an error.
+type Kind int
+
+const (
+	Unknown Kind = iota
+	AccessDenied
+	AlreadyExists
+	NotFound
+	QuotaExceeded
+	Unavailable
+	FUSEUnavailable
+)
+
+// S3 error codes of MinIO and AWS.
+var s3Codes = map[string]Kind{
+	"AccessDenied":                   AccessDenied,
+	"AllAccessDisabled":              AccessDenied,
+	"InvalidAccessKeyId":             AccessDenied,
+	"SignatureDoesNotMatch":          AccessDenied,
+	"ExpiredToken":                   AccessDenied,
+	"InvalidToken":                   AccessDenied,
+	"BucketAlreadyOwnedByYou":        AlreadyExists,
+	"BucketAlreadyExists":            AlreadyExists,
+	"NoSuchBucket":                   NotFound,
+	"QuotaExceeded":                  QuotaExceeded,
+	"TooManyBuckets":                 QuotaExceeded,
+	"XMinioAdminBucketQuotaExceeded": QuotaExceeded,
+	"XMinioStorageFull":              QuotaExceeded,
+	"SlowDown":                       Unavailable,
+	"SlowDownRead":                   Unavailable,
+	"SlowDownWrite":                  Unavailable,
+	"Throttling":                     Unavailable,
+	"ThrottlingException":            Unavailable,
+	"RequestLimitExceeded":           Unavailable,
+	"ServiceUnavailable":             Unavailable,
+	"XMinioServerNotInitialized":     Unavailable,
+	"RequestTimeout":                 Unavailable,
+	"InternalError":                  Unavailable,
+}
+
+// grpcKinds classifies errors which already carry a gRPC status.
+var grpcKinds = map[codes.Code]Kind{
+	codes.PermissionDenied:  AccessDenied,
+	codes.AlreadyExists:     AlreadyExists,
+	codes.NotFound:          NotFound,
+	codes.ResourceExhausted: QuotaExceeded,
+	codes.Unavailable:       Unavailable,
+}
+
+// retryableCodes are S3 error codes of transient failures, a retry may succeed.
+var retryableCodes = map[string]bool{
+	"SlowDown":                   true,
+	"SlowDownRead":               true,
+	"SlowDownWrite":              true,
+	"Throttling":                 true,
+	"ThrottlingException":        true,
+	"RequestLimitExceeded":       true,
+	"RequestTimeout":             true,
+	"InternalError":              true,
+	"ServiceUnavailable":         true,
+	"XMinioServerNotInitialized": true,
+}
+
+// messagePatterns classify errors without an S3 error code, mainly the stderr of mount-s3
+// which is part of the mount error. They are matched against the lower case message in order.
+// Quota and throttling are only recognized by their error codes, a message mentioning a quota
+// is no proof that it has been exceeded.
+var messagePatterns = []struct {
+	pattern string
+	kind    Kind
+}{
+	{"fuse: device not found", FUSEUnavailable},
+	{"/dev/fuse", FUSEUnavailable},
+	{"access denied", AccessDenied},
+	{"accessdenied", AccessDenied},
+	{"invalidaccesskeyid", AccessDenied},
+	{"signaturedoesnotmatch", AccessDenied},
+	{"forbidden (403)", AccessDenied},
+	{"nosuchbucket", NotFound},
+	{"the specified bucket does not exist", NotFound},
+	{"quotaexceeded", QuotaExceeded},
+	{"toomanybuckets", QuotaExceeded},
+	{"xminiostoragefull", QuotaExceeded},
+	{"slowdown", Unavailable},
+	{"throttling", Unavailable},
+	{"connection refused", Unavailable},
+	{"dispatch failure", Unavailable},
+	{"timed out", Unavailable},
+	{"dns error", Unavailable},
+	{"no route to host", Unavailable},
+	{"service unavailable", Unavailable},
+}
+
+// Classify returns the class of err. S3 error codes take precedence over network errors and messages.
+func Classify(err error) Kind {
+	if err == nil {
+		return Unknown
+	}
+	// already classified as gRPC status, e.g. by the circuit breaker
+	if st, ok := status.FromError(err); ok {
+		if kind, ok := grpcKinds[st.Code()]; ok {
+			return kind
+		}
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		if kind, ok := s3Codes[resp.Code]; ok {
+			return kind
+		}
+		switch resp.StatusCode {
+		case http.StatusForbidden:
+			return AccessDenied
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return Unavailable
+		}
+	}
+	if isNetworkError(err) {
+		return Unavailable
+	}
+	msg := strings.ToLower(err.Error())
+	for _, p := range messagePatterns {
+		if strings.Contains(msg, p.pattern) {
+			return p.kind
+		}
+	}
+	return Unknown
+}
+
+// Retryable reports whether err is transient, like an unreachable or overloaded backend.
+// Errors of the request itself, e.g. AccessDenied or NoSuchBucket, fail the same way again.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) && (retryableCodes[resp.Code] || resp.StatusCode >= http.StatusInternalServerError) {
+		return true
+	}
+	return Classify(err) == Unavailable
+}
+
+// isNetworkError reports refused and reset connections, temporary DNS failures and timeouts of the connection.
+// Certificate and TLS errors fail the same way again, the deadline of the request is no failure of the backend.
+func isNetworkError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalidCert      x509.CertificateInvalidError
+		verification     *tls.CertificateVerificationError
+		recordHeader     tls.RecordHeaderError
+		alert            tls.AlertError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalidCert) ||
+		errors.As(err, &verification) || errors.As(err, &recordHeader) || errors.As(err, &alert) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (errors.Is(opErr.Err, syscall.ECONNREFUSED) || errors.Is(opErr.Err, syscall.ECONNRESET)) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	// *url.Error only passes on Timeout of the error it wraps, context.DeadlineExceeded implements it as well
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if e == context.DeadlineExceeded {
+			return false
+		}
+		if _, ok := e.(*url.Error); ok {
+			continue
+		}
+		if netErr, ok := e.(net.Error); ok && netErr.Timeout() {
+			return true
+		}
+	}
+	return false
+}
+
+// Code returns the gRPC code of kind, fallback for Unknown.
+func (k Kind) Code(fallback codes.Code) codes.Code {
+	switch k {
+	case AccessDenied:
+		return codes.PermissionDenied
+	case AlreadyExists:
+		return codes.AlreadyExists
+	case NotFound:
+		return codes.NotFound
+	case QuotaExceeded:
+		return codes.ResourceExhausted
+	case Unavailable:
+		return codes.Unavailable
+	case FUSEUnavailable:
+		return codes.FailedPrecondition
+	default:
+		return fallback
+	}
+}
+
+// Code classifies err and returns its gRPC code, fallback if the class is unknown.
+func Code(err error, fallback codes.Code) codes.Code {
+	return Classify(err).Code(fallback)
+}
+
+// Status converts err into a gRPC status error with the classified code. The message is
+// prefixed with msg if it is not empty. Status errors are returned unchanged.
+func Status(err error, fallback codes.Code, msg string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	text := err.Error()
+	if msg != "" {
+		text = msg + ": " + text
+	}
+	return status.Error(Code(err, fallback), text)
+}
+
+// IsNotFound reports whether err means that the bucket does not exist.
+func IsNotFound(err error) bool {
+	return Classify(err) == NotFound
+}
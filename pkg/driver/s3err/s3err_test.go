@@ -0,0 +1,97 @@
+package s3err_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/s3err"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind s3err.Kind
+		code codes.Code
+	}{
+		{"nil", nil, s3err.Unknown, codes.Internal},
+		{"access denied", minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403}, s3err.AccessDenied, codes.PermissionDenied},
+		{"invalid access key", minio.ErrorResponse{Code: "InvalidAccessKeyId", StatusCode: 403}, s3err.AccessDenied, codes.PermissionDenied},
+		{"forbidden without code", minio.ErrorResponse{StatusCode: 403}, s3err.AccessDenied, codes.PermissionDenied},
+		{"owned by you", minio.ErrorResponse{Code: "BucketAlreadyOwnedByYou", StatusCode: 409}, s3err.AlreadyExists, codes.AlreadyExists},
+		{"already exists", minio.ErrorResponse{Code: "BucketAlreadyExists", StatusCode: 409}, s3err.AlreadyExists, codes.AlreadyExists},
+		{"quota", minio.ErrorResponse{Code: "XMinioAdminBucketQuotaExceeded"}, s3err.QuotaExceeded, codes.ResourceExhausted},
+		{"too many buckets", minio.ErrorResponse{Code: "TooManyBuckets"}, s3err.QuotaExceeded, codes.ResourceExhausted},
+		{"slow down", minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}, s3err.Unavailable, codes.Unavailable},
+		{"throttling", minio.ErrorResponse{Code: "Throttling", StatusCode: 400}, s3err.Unavailable, codes.Unavailable},
+		{"too many requests", minio.ErrorResponse{StatusCode: 429}, s3err.Unavailable, codes.Unavailable},
+		{"no such bucket", minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: 404}, s3err.NotFound, codes.NotFound},
+		{"wrapped", fmt.Errorf("create: %w", minio.ErrorResponse{Code: "NoSuchBucket"}), s3err.NotFound, codes.NotFound},
+		{"service unavailable", minio.ErrorResponse{StatusCode: 503}, s3err.Unavailable, codes.Unavailable},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, s3err.Unavailable, codes.Unavailable},
+		{"connection reset", &url.Error{Op: "Get", URL: "https://minio", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, s3err.Unavailable, codes.Unavailable},
+		{"dns temporary", &net.DNSError{Err: "server misbehaving", Name: "minio", IsTemporary: true}, s3err.Unavailable, codes.Unavailable},
+		{"dns timeout", &net.DNSError{Err: "i/o timeout", Name: "minio", IsTimeout: true}, s3err.Unavailable, codes.Unavailable},
+		{"dns not found", &net.DNSError{Err: "no such host", Name: "minio", IsNotFound: true}, s3err.Unknown, codes.Internal},
+		{"read timeout", &url.Error{Op: "Get", URL: "https://minio", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}}, s3err.Unavailable, codes.Unavailable},
+		{"deadline", context.DeadlineExceeded, s3err.Unknown, codes.Internal},
+		{"deadline of the request", &url.Error{Op: "Get", URL: "https://minio", Err: context.DeadlineExceeded}, s3err.Unknown, codes.Internal},
+		{"unknown authority", &url.Error{Op: "Get", URL: "https://minio", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, s3err.Unknown, codes.Internal},
+		{"hostname", &url.Error{Op: "Get", URL: "https://minio", Err: x509.HostnameError{Certificate: &x509.Certificate{}, Host: "minio"}}, s3err.Unknown, codes.Internal},
+		{"tls record header", &url.Error{Op: "Get", URL: "https://minio", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}, s3err.Unknown, codes.Internal},
+		{"mount access denied", errors.New("mount failed: exit status 1 output=Error: Failed to create S3 client\nCaused by: HeadBucket failed for bucket b: Forbidden (403)"), s3err.AccessDenied, codes.PermissionDenied},
+		{"mount no such bucket", errors.New("mount failed: exit status 1 output=Error: initial ListObjectsV2 failed for bucket b\nCaused by: Service error: The specified bucket does not exist"), s3err.NotFound, codes.NotFound},
+		{"mount no fuse", errors.New("mount failed: exit status 1 output=fuse: device not found, try 'modprobe fuse' first"), s3err.FUSEUnavailable, codes.FailedPrecondition},
+		{"mount dispatch failure", errors.New("mount failed: exit status 1 output=Error: dispatch failure: io error: connection refused"), s3err.Unavailable, codes.Unavailable},
+		{"mount quota", errors.New("mount failed: exit status 1 output=Error: PutObject failed\nCaused by: Service error: XMinioAdminBucketQuotaExceeded"), s3err.QuotaExceeded, codes.ResourceExhausted},
+		{"mount slow down", errors.New("mount failed: exit status 1 output=Error: Service error: SlowDown: Please reduce your request rate"), s3err.Unavailable, codes.Unavailable},
+		{"quota in a message", errors.New("bucket quota-reports not found in cache"), s3err.Unknown, codes.Internal},
+		{"unknown", errors.New("boom"), s3err.Unknown, codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.kind, s3err.Classify(tt.err))
+			assert.Equal(t, tt.code, s3err.Code(tt.err, codes.Internal))
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	err := s3err.Status(minio.ErrorResponse{Code: "AccessDenied", Message: "Access Denied."}, codes.Internal, "failed to create bucket b")
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.PermissionDenied, st.Code())
+	assert.Equal(t, "failed to create bucket b: Access Denied.", st.Message())
+
+	// errors which are already classified are kept
+	aborted := status.Error(codes.Aborted, "busy")
+	assert.Equal(t, aborted, s3err.Status(aborted, codes.Internal, "ignored"))
+
+	assert.NoError(t, s3err.Status(nil, codes.Internal, ""))
+}
+
+func TestRetryable(t *testing.T) {
+	assert.True(t, s3err.Retryable(minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}))
+	assert.True(t, s3err.Retryable(minio.ErrorResponse{Code: "InternalError", StatusCode: 500}))
+	assert.True(t, s3err.Retryable(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}))
+	assert.True(t, s3err.Retryable(status.Error(codes.Unavailable, "circuit breaker open")))
+	assert.False(t, s3err.Retryable(minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403}))
+	assert.False(t, s3err.Retryable(minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: 404}))
+	assert.False(t, s3err.Retryable(context.Canceled))
+	assert.False(t, s3err.Retryable(context.DeadlineExceeded))
+	assert.False(t, s3err.Retryable(&url.Error{Op: "Get", URL: "https://minio", Err: x509.UnknownAuthorityError{}}))
+	assert.False(t, s3err.Retryable(nil))
+}
@@ -0,0 +1,20 @@
+package minio
+
+import (
+	"github.com/minio/madmin-go/v3"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+)
+
+// NewAdminClient creates a client of the MinIO admin API with the endpoint, TLS and proxy of config
+func NewAdminClient(config *store.StoreConfig) (*madmin.AdminClient, error) {
+	creds := credentials.NewStaticV4(config.AccessKey, config.SecretKey, "")
+	if config.Credentials != nil {
+		creds = newSourceCredentials(config.Credentials)
+	}
+	return madmin.NewWithOptions(config.Endpoint(), &madmin.Options{
+		Creds:     creds,
+		Secure:    config.UseTLS(),
+		Transport: config.Transport,
+	})
+}
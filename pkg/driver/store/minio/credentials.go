@@ -0,0 +1,38 @@
+package minio
+
+import (
+	"sync/atomic"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+)
+
+// sourceProvider is a credentials.Provider which reads from a shared store.CredentialsSource.
+// The cached value of minio-go expires as soon as the source has been rotated.
+type sourceProvider struct {
+	source     store.CredentialsSource
+	generation atomic.Uint64
+}
+
+func newSourceCredentials(source store.CredentialsSource) *credentials.Credentials {
+	return credentials.New(&sourceProvider{source: source})
+}
+
+func (p *sourceProvider) Retrieve() (credentials.Value, error) {
+	accessKey, secretKey, generation := p.source.Current()
+	p.generation.Store(generation)
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (p *sourceProvider) RetrieveWithCredContext(_ *credentials.CredContext) (credentials.Value, error) {
+	return p.Retrieve()
+}
+
+func (p *sourceProvider) IsExpired() bool {
+	_, _, generation := p.source.Current()
+	return generation != p.generation.Load()
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	"github.com/smou/k8s-csi-s3/pkg/driver/store"
 	"k8s.io/klog/v2"
 )
@@ -15,11 +16,17 @@ type Store struct {
 }
 
 func NewStore(config *store.StoreConfig) (*Store, error) {
-	klog.Infof("Init MinioStore | '%s' | '%s'", config.EndpointURL, config.Region)
+	klog.InfoS("Init MinioStore", "endpoint", config.EndpointURL, "region", config.Region)
+	creds := credentials.NewStaticV4(config.AccessKey, config.SecretKey, "")
+	if config.Credentials != nil {
+		creds = newSourceCredentials(config.Credentials)
+	}
 	client, err := minio.New(config.Endpoint(), &minio.Options{
-		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
-		Secure: config.UseTLS(),
-		Region: config.Region,
+		Creds:        creds,
+		Secure:       config.UseTLS(),
+		Region:       config.Region,
+		Transport:    config.Transport,
+		BucketLookup: bucketLookup(config.AddressingStyle),
 	})
 	if err != nil {
 		return nil, err
@@ -30,8 +37,20 @@ func NewStore(config *store.StoreConfig) (*Store, error) {
 	}, nil
 }
 
+// bucketLookup translates the addressing style, path-style is the default
+func bucketLookup(style string) minio.BucketLookupType {
+	switch style {
+	case "virtual":
+		return minio.BucketLookupDNS
+	case "auto":
+		return minio.BucketLookupAuto
+	default:
+		return minio.BucketLookupPath
+	}
+}
+
 func (s *Store) BucketExists(ctx context.Context, name string) (bool, error) {
-	klog.Infof("BucketExists? '%s'", name)
+	klog.FromContext(ctx).V(4).Info("BucketExists", "bucket", name)
 	exists, err := s.Client.BucketExists(ctx, name)
 	if err != nil {
 		return false, err
@@ -40,7 +59,7 @@ func (s *Store) BucketExists(ctx context.Context, name string) (bool, error) {
 }
 
 func (s *Store) CreateBucket(ctx context.Context, name string) error {
-	klog.Infof("CreateBucket '%s'", name)
+	klog.FromContext(ctx).Info("CreateBucket", "bucket", name)
 	exists, err := s.BucketExists(ctx, name)
 	if err != nil {
 		return err
@@ -50,13 +69,18 @@ func (s *Store) CreateBucket(ctx context.Context, name string) error {
 		return nil
 	}
 
-	return s.Client.MakeBucket(ctx, name, minio.MakeBucketOptions{
+	err = s.Client.MakeBucket(ctx, name, minio.MakeBucketOptions{
 		Region: s.Region,
 	})
+	// created concurrently (e.g. by a second controller after a leader change), but owned by us
+	if err != nil && minio.ToErrorResponse(err).Code == "BucketAlreadyOwnedByYou" {
+		return nil
+	}
+	return err
 }
 
 func (s *Store) DeleteBucket(ctx context.Context, name string) error {
-	klog.Infof("DeleteBucket '%s'", name)
+	klog.FromContext(ctx).Info("DeleteBucket", "bucket", name)
 	exists, err := s.BucketExists(ctx, name)
 	if err != nil {
 		return err
@@ -69,3 +93,51 @@ func (s *Store) DeleteBucket(ctx context.Context, name string) error {
 		ForceDelete: true,
 	})
 }
+
+func (s *Store) ListBuckets(ctx context.Context) ([]store.Bucket, error) {
+	klog.FromContext(ctx).V(4).Info("ListBuckets")
+	infos, err := s.Client.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]store.Bucket, 0, len(infos))
+	for _, info := range infos {
+		buckets = append(buckets, store.Bucket{Name: info.Name, CreationDate: info.CreationDate})
+	}
+	return buckets, nil
+}
+
+func (s *Store) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	klog.FromContext(ctx).V(4).Info("BucketTags", "bucket", name)
+	t, err := s.Client.GetBucketTagging(ctx, name)
+	// a bucket without tags returns NoSuchTagSet instead of an empty set
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchTagSet" {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t.ToMap(), nil
+}
+
+func (s *Store) SetBucketTags(ctx context.Context, name string, bucketTags map[string]string) error {
+	klog.FromContext(ctx).V(4).Info("SetBucketTags", "bucket", name)
+	if len(bucketTags) == 0 {
+		return s.Client.RemoveBucketTagging(ctx, name)
+	}
+	t, err := tags.NewTags(bucketTags, false)
+	if err != nil {
+		return err
+	}
+	return s.Client.SetBucketTagging(ctx, name, t)
+}
+
+func (s *Store) SetBucketPolicy(ctx context.Context, name, policy string) error {
+	klog.FromContext(ctx).V(4).Info("SetBucketPolicy", "bucket", name, "remove", policy == "")
+	err := s.Client.SetBucketPolicy(ctx, name, policy)
+	// removing a missing policy is not an error
+	if err != nil && policy == "" && minio.ToErrorResponse(err).Code == minio.NoSuchBucketPolicy {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Pool holds one BucketStore per backend profile
+type Pool struct {
+	mu     sync.RWMutex
+	stores map[string]*SwappableStore
+}
+
+func NewPool() *Pool {
+	return &Pool{stores: make(map[string]*SwappableStore)}
+}
+
+// Add registers the BucketStore of the backend, an existing one is replaced
+func (p *Pool) Add(backend string, s BucketStore) *SwappableStore {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.stores[backend]; ok {
+		existing.Swap(s)
+		return existing
+	}
+	w := NewSwappableStore(s)
+	p.stores[backend] = w
+	return w
+}
+
+// Get returns the BucketStore of the backend
+func (p *Pool) Get(backend string) (BucketStore, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s, ok := p.stores[backend]
+	if !ok {
+		return nil, fmt.Errorf("no BucketStore for backend %q", backend)
+	}
+	return s, nil
+}
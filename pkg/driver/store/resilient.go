@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/s3err"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Defaults of the ResilienceConfig
+const (
+	DefaultMaxAttempts      = 4
+	DefaultInitialBackoff   = 200 * time.Millisecond
+	DefaultMaxBackoff       = 5 * time.Second
+	DefaultOperationTimeout = 10 * time.Second
+	DefaultFailureThreshold = 5
+	DefaultOpenDuration     = 30 * time.Second
+)
+
+// ResilienceConfig controls the retries, timeouts and circuit breaker of a ResilientStore
+type ResilienceConfig struct {
+	// MaxAttempts is the number of attempts per call including the first one, 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is doubled per attempt up to MaxBackoff, the wait is random in [backoff/2, backoff]
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// OperationTimeout limits every single attempt, 0 keeps only the deadline of the request
+	OperationTimeout time.Duration
+	// FailureThreshold consecutive transient errors open the circuit breaker, 0 disables it
+	FailureThreshold int
+	// OpenDuration is how long the breaker stays open, afterwards a single probe call is let through
+	OpenDuration time.Duration
+}
+
+// DefaultResilienceConfig returns the defaults
+func DefaultResilienceConfig() ResilienceConfig {
+	return ResilienceConfig{
+		MaxAttempts:      DefaultMaxAttempts,
+		InitialBackoff:   DefaultInitialBackoff,
+		MaxBackoff:       DefaultMaxBackoff,
+		OperationTimeout: DefaultOperationTimeout,
+		FailureThreshold: DefaultFailureThreshold,
+		OpenDuration:     DefaultOpenDuration,
+	}
+}
+
+// Validate rejects nonsensical values
+func (c ResilienceConfig) Validate() error {
+	switch {
+	case c.MaxAttempts < 1:
+		return fmt.Errorf("maxAttempts must be at least 1, got %d", c.MaxAttempts)
+	case c.InitialBackoff < 0 || c.MaxBackoff < 0 || c.OperationTimeout < 0 || c.OpenDuration < 0:
+		return fmt.Errorf("durations must not be negative")
+	case c.MaxBackoff < c.InitialBackoff:
+		return fmt.Errorf("maxBackoff %v is less than initialBackoff %v", c.MaxBackoff, c.InitialBackoff)
+	case c.FailureThreshold < 0:
+		return fmt.Errorf("failureThreshold must not be negative, got %d", c.FailureThreshold)
+	}
+	return nil
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// ResilientStore retries transient errors with exponential backoff and jitter and protects the
+// backend with a circuit breaker. Errors of the request itself (AccessDenied, NoSuchBucket, ...)
+// are returned immediately and do not count for the breaker.
+type ResilientStore struct {
+	backend string
+	next    BucketStore
+	config  ResilienceConfig
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// probing is set while a probe call runs in the half-open state
+	probing bool
+}
+
+// NewResilientStore decorates next, backend shows up in logs and errors
+func NewResilientStore(backend string, next BucketStore, config ResilienceConfig) *ResilientStore {
+	return &ResilientStore{backend: backend, next: next, config: config}
+}
+
+func (s *ResilientStore) BucketExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	err := s.do(ctx, "BucketExists", func(ctx context.Context) error {
+		var err error
+		exists, err = s.next.BucketExists(ctx, name)
+		return err
+	})
+	return exists, err
+}
+
+func (s *ResilientStore) CreateBucket(ctx context.Context, name string) error {
+	return s.do(ctx, "CreateBucket", func(ctx context.Context) error {
+		return s.next.CreateBucket(ctx, name)
+	})
+}
+
+func (s *ResilientStore) DeleteBucket(ctx context.Context, name string) error {
+	return s.do(ctx, "DeleteBucket", func(ctx context.Context) error {
+		return s.next.DeleteBucket(ctx, name)
+	})
+}
+
+func (s *ResilientStore) ListBuckets(ctx context.Context) ([]Bucket, error) {
+	var buckets []Bucket
+	err := s.do(ctx, "ListBuckets", func(ctx context.Context) error {
+		var err error
+		buckets, err = s.next.ListBuckets(ctx)
+		return err
+	})
+	return buckets, err
+}
+
+func (s *ResilientStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	var tags map[string]string
+	err := s.do(ctx, "BucketTags", func(ctx context.Context) error {
+		var err error
+		tags, err = s.next.BucketTags(ctx, name)
+		return err
+	})
+	return tags, err
+}
+
+func (s *ResilientStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	return s.do(ctx, "SetBucketTags", func(ctx context.Context) error {
+		return s.next.SetBucketTags(ctx, name, tags)
+	})
+}
+
+func (s *ResilientStore) SetBucketPolicy(ctx context.Context, name, policy string) error {
+	return s.do(ctx, "SetBucketPolicy", func(ctx context.Context) error {
+		return s.next.SetBucketPolicy(ctx, name, policy)
+	})
+}
+
+// State returns the state of the circuit breaker (closed, open or half-open)
+func (s *ResilientStore) State() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.String()
+}
+
+func (s *ResilientStore) do(ctx context.Context, operation string, call func(context.Context) error) error {
+	logger := klog.FromContext(ctx)
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err := s.allow(); err != nil {
+			return err
+		}
+		err = s.attempt(ctx, call)
+		s.record(ctx, err)
+		if err == nil || !s3err.Retryable(err) || attempt >= s.config.MaxAttempts {
+			return err
+		}
+		wait := s.backoff(attempt)
+		logger.V(2).Info("Retrying S3 operation", "backend", s.backend, "operation", operation,
+			"attempt", attempt, "backoff", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *ResilientStore) attempt(ctx context.Context, call func(context.Context) error) error {
+	if s.config.OperationTimeout <= 0 {
+		return call(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, s.config.OperationTimeout)
+	defer cancel()
+	err := call(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return &attemptTimeoutError{backend: s.backend, timeout: s.config.OperationTimeout, err: err}
+	}
+	return err
+}
+
+// attemptTimeoutError is the OperationTimeout of a single attempt. Unlike the deadline of the request it
+// indicates a slow backend, so it is Unavailable and retried.
+type attemptTimeoutError struct {
+	backend string
+	timeout time.Duration
+	err     error
+}
+
+func (e *attemptTimeoutError) Error() string {
+	return fmt.Sprintf("backend %s: attempt timed out after %v: %v", e.backend, e.timeout, e.err)
+}
+
+func (e *attemptTimeoutError) Unwrap() error { return e.err }
+
+func (e *attemptTimeoutError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+// backoff doubles InitialBackoff per attempt, the jitter spreads concurrent retries
+func (s *ResilientStore) backoff(attempt int) time.Duration {
+	d := s.config.InitialBackoff
+	for i := 1; i < attempt && d < s.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, s.config.MaxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// allow rejects calls while the breaker is open
+func (s *ResilientStore) allow() error {
+	if s.config.FailureThreshold <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.state {
+	case breakerOpen:
+		if time.Since(s.openedAt) < s.config.OpenDuration {
+			return s.openError()
+		}
+		s.state = breakerHalfOpen
+		s.probing = true
+		return nil
+	case breakerHalfOpen:
+		if s.probing {
+			return s.openError()
+		}
+		s.probing = true
+	}
+	return nil
+}
+
+func (s *ResilientStore) openError() error {
+	retryAfter := s.config.OpenDuration - time.Since(s.openedAt)
+	return status.Errorf(codes.Unavailable, "backend %s: circuit breaker open after %d consecutive failures, retry in %v",
+		s.backend, s.failures, retryAfter.Round(time.Second))
+}
+
+// record counts transient errors, only they indicate a failing backend
+func (s *ResilientStore) record(ctx context.Context, err error) {
+	if s.config.FailureThreshold <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probing = false
+	// canceled requests say nothing about the backend
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err == nil || !s3err.Retryable(err) {
+		if s.state != breakerClosed {
+			klog.FromContext(ctx).Info("Circuit breaker closed", "backend", s.backend)
+		}
+		s.state = breakerClosed
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.state == breakerHalfOpen || s.failures >= s.config.FailureThreshold {
+		if s.state != breakerOpen {
+			klog.FromContext(ctx).Info("Circuit breaker opened", "backend", s.backend, "failures", s.failures, "openDuration", s.config.OpenDuration, "err", err)
+		}
+		s.state = breakerOpen
+		s.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,158 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/storetest"
+)
+
+var (
+	errUnavailable  = minio.ErrorResponse{Code: "ServiceUnavailable", StatusCode: 503}
+	errAccessDenied = minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403}
+)
+
+func testResilienceConfig() store.ResilienceConfig {
+	return store.ResilienceConfig{
+		MaxAttempts:      3,
+		InitialBackoff:   time.Millisecond,
+		MaxBackoff:       2 * time.Millisecond,
+		OperationTimeout: time.Second,
+		FailureThreshold: 5,
+		OpenDuration:     50 * time.Millisecond,
+	}
+}
+
+func TestResilientStore_RetriesTransientErrors(t *testing.T) {
+	fake := storetest.NewFaultyStore(errUnavailable, errUnavailable)
+	s := store.NewResilientStore("default", fake, testResilienceConfig())
+
+	require.NoError(t, s.CreateBucket(context.Background(), "b"))
+	assert.Equal(t, 3, fake.Calls())
+}
+
+func TestResilientStore_GivesUpAfterMaxAttempts(t *testing.T) {
+	fake := storetest.NewFaultyStore(errUnavailable, errUnavailable, errUnavailable, errUnavailable)
+	s := store.NewResilientStore("default", fake, testResilienceConfig())
+
+	err := s.DeleteBucket(context.Background(), "b")
+	assert.Equal(t, errUnavailable, err)
+	assert.Equal(t, 3, fake.Calls())
+}
+
+func TestResilientStore_DoesNotRetryPermanentErrors(t *testing.T) {
+	fake := storetest.NewFaultyStore(errAccessDenied)
+	s := store.NewResilientStore("default", fake, testResilienceConfig())
+
+	_, err := s.BucketExists(context.Background(), "b")
+	assert.Equal(t, errAccessDenied, err)
+	assert.Equal(t, 1, fake.Calls())
+}
+
+func TestResilientStore_OperationTimeout(t *testing.T) {
+	fake := storetest.NewFaultyStore()
+	fake.Delay = time.Second
+	cfg := testResilienceConfig()
+	cfg.OperationTimeout = 10 * time.Millisecond
+	s := store.NewResilientStore("default", fake, cfg)
+
+	start := time.Now()
+	err := s.CreateBucket(context.Background(), "b")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, fake.Calls())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestResilientStore_StopsWhenRequestIsCanceled(t *testing.T) {
+	fake := storetest.NewFaultyStore(errUnavailable, errUnavailable)
+	cfg := testResilienceConfig()
+	cfg.InitialBackoff = time.Second
+	cfg.MaxBackoff = time.Second
+	s := store.NewResilientStore("default", fake, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.CreateBucket(ctx, "b")
+	assert.Equal(t, errUnavailable, err)
+	assert.Equal(t, 1, fake.Calls())
+}
+
+func TestResilientStore_CircuitBreaker(t *testing.T) {
+	faults := make([]error, 6)
+	for i := range faults {
+		faults[i] = errUnavailable
+	}
+	fake := storetest.NewFaultyStore(faults...)
+	cfg := testResilienceConfig()
+	cfg.MaxAttempts = 1
+	cfg.FailureThreshold = 3
+	s := store.NewResilientStore("default", fake, cfg)
+	ctx := context.Background()
+
+	for range 3 {
+		require.Error(t, s.CreateBucket(ctx, "b"))
+	}
+	assert.Equal(t, "open", s.State())
+
+	// open: the backend is not called anymore
+	err := s.CreateBucket(ctx, "b")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, fake.Calls())
+
+	// after OpenDuration a probe call runs, the breaker opens again if it fails
+	time.Sleep(cfg.OpenDuration)
+	require.Error(t, s.CreateBucket(ctx, "b"))
+	assert.Equal(t, 4, fake.Calls())
+	assert.Equal(t, "open", s.State())
+
+	// a successful probe call closes it
+	fake.ClearFaults()
+	time.Sleep(cfg.OpenDuration)
+	require.NoError(t, s.CreateBucket(ctx, "b"))
+	assert.Equal(t, "closed", s.State())
+}
+
+func TestResilientStore_PermanentErrorsDoNotOpenBreaker(t *testing.T) {
+	fake := storetest.NewFaultyStore(errAccessDenied, errAccessDenied, errAccessDenied)
+	cfg := testResilienceConfig()
+	cfg.FailureThreshold = 2
+	s := store.NewResilientStore("default", fake, cfg)
+
+	for range 3 {
+		require.Error(t, s.DeleteBucket(context.Background(), "b"))
+	}
+	assert.Equal(t, "closed", s.State())
+}
+
+func TestResilienceConfig_Validate(t *testing.T) {
+	assert.NoError(t, store.DefaultResilienceConfig().Validate())
+
+	cfg := store.DefaultResilienceConfig()
+	cfg.MaxAttempts = 0
+	assert.Error(t, cfg.Validate())
+
+	cfg = store.DefaultResilienceConfig()
+	cfg.MaxBackoff = cfg.InitialBackoff / 2
+	assert.Error(t, cfg.Validate())
+}
+
+func TestResilientStore_DoesNotRetryDeadlineOfRequest(t *testing.T) {
+	fake := storetest.NewFaultyStore()
+	fake.Delay = time.Second
+	s := store.NewResilientStore("default", fake, testResilienceConfig())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := s.CreateBucket(ctx, "b")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, fake.Calls())
+}
@@ -2,8 +2,13 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"net/url"
+	"time"
 
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
 	"k8s.io/klog/v2"
 )
 
@@ -16,6 +21,29 @@ type BucketStore interface {
 
 	// DeleteBucket löscht den Bucket, falls er existiert
 	DeleteBucket(ctx context.Context, name string) error
+
+	// ListBuckets lists all buckets visible with the credentials
+	ListBuckets(ctx context.Context) ([]Bucket, error)
+
+	// BucketTags returns the tags of the bucket, empty if it has none
+	BucketTags(ctx context.Context, name string) (map[string]string, error)
+
+	// SetBucketTags replaces all tags of the bucket
+	SetBucketTags(ctx context.Context, name string, tags map[string]string) error
+
+	// SetBucketPolicy replaces the bucket policy, an empty policy removes it
+	SetBucketPolicy(ctx context.Context, name, policy string) error
+}
+
+// Bucket is an entry of ListBuckets
+type Bucket struct {
+	Name         string
+	CreationDate time.Time
+}
+
+// CredentialsSource returns the current credentials, the generation changes with every rotation
+type CredentialsSource interface {
+	Current() (accessKey, secretKey string, generation uint64)
 }
 
 type StoreConfig struct {
@@ -23,6 +51,26 @@ type StoreConfig struct {
 	Region      string
 	AccessKey   string
 	SecretKey   string
+
+	// Credentials overrides AccessKey/SecretKey if set
+	Credentials CredentialsSource
+
+	// Transport with custom TLS and proxy settings, the default transport otherwise
+	Transport http.RoundTripper
+
+	// AddressingStyle is path, virtual or auto
+	AddressingStyle string
+}
+
+// String returns the config without secrets
+func (c StoreConfig) String() string {
+	return fmt.Sprintf("{EndpointURL:%s Region:%s AccessKey:%s SecretKey:%s AddressingStyle:%s}",
+		c.EndpointURL, c.Region, logging.Redact(c.AccessKey), logging.Redact(c.SecretKey), c.AddressingStyle)
+}
+
+// LogValue implements slog.LogValuer, also without secrets
+func (c StoreConfig) LogValue() slog.Value {
+	return slog.StringValue(c.String())
 }
 
 func (c *StoreConfig) UseTLS() bool {
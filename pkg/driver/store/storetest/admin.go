@@ -0,0 +1,117 @@
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/minio/madmin-go/v3"
+)
+
+// Admin is an in-memory MinIO admin API with canned policies, users and service accounts.
+type Admin struct {
+	mu       sync.Mutex
+	policies map[string][]byte
+	users    map[string]string
+	attached map[string][]string
+	accounts map[string]madmin.AddServiceAccountReq
+	next     int
+}
+
+func NewAdmin() *Admin {
+	return &Admin{
+		policies: make(map[string][]byte),
+		users:    make(map[string]string),
+		attached: make(map[string][]string),
+		accounts: make(map[string]madmin.AddServiceAccountReq),
+	}
+}
+
+// Policies returns the canned policies by name.
+func (a *Admin) Policies() map[string][]byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.policies)
+}
+
+// Users returns the secret keys of the users by access key.
+func (a *Admin) Users() map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.users)
+}
+
+// Attached returns the policies attached to user.
+func (a *Admin) Attached(user string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.attached[user])
+}
+
+// ServiceAccounts returns the requests of the service accounts by access key.
+func (a *Admin) ServiceAccounts() map[string]madmin.AddServiceAccountReq {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.accounts)
+}
+
+func (a *Admin) AddCannedPolicy(ctx context.Context, policyName string, policy []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policies[policyName] = policy
+	return nil
+}
+
+func (a *Admin) GetUserInfo(ctx context.Context, name string) (madmin.UserInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.users[name]; !ok {
+		return madmin.UserInfo{}, madmin.ErrorResponse{Code: "XMinioAdminNoSuchUser"}
+	}
+	return madmin.UserInfo{Status: madmin.AccountEnabled}, nil
+}
+
+func (a *Admin) AddUser(ctx context.Context, accessKey, secretKey string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users[accessKey] = secretKey
+	return nil
+}
+
+func (a *Admin) AttachPolicy(ctx context.Context, r madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range r.Policies {
+		if slices.Contains(a.attached[r.User], p) {
+			return madmin.PolicyAssociationResp{}, madmin.ErrorResponse{Code: "XMinioAdminPolicyChangeAlreadyApplied"}
+		}
+		a.attached[r.User] = append(a.attached[r.User], p)
+	}
+	return madmin.PolicyAssociationResp{PoliciesAttached: r.Policies}, nil
+}
+
+// AddServiceAccount creates the access keys sa-1, sa-2, ... with the secret keys secret-sa-1, ...
+func (a *Admin) AddServiceAccount(ctx context.Context, opts madmin.AddServiceAccountReq) (madmin.Credentials, error) {
+	if len(opts.Policy) > 0 && !json.Valid(opts.Policy) {
+		return madmin.Credentials{}, madmin.ErrorResponse{Code: "XMinioMalformedIAMPolicy"}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next++
+	accessKey := fmt.Sprintf("sa-%d", a.next)
+	a.accounts[accessKey] = opts
+	return madmin.Credentials{AccessKey: accessKey, SecretKey: "secret-" + accessKey}, nil
+}
+
+func (a *Admin) DeleteServiceAccount(ctx context.Context, serviceAccount string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.accounts[serviceAccount]; !ok {
+		return madmin.ErrorResponse{Code: "XMinioAdminServiceAccountNotFound"}
+	}
+	delete(a.accounts, serviceAccount)
+	return nil
+}
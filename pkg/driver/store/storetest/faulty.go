@@ -0,0 +1,86 @@
+package storetest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+)
+
+// FaultyStore returns the errors of faults one after another, then success.
+type FaultyStore struct {
+	mu     sync.Mutex
+	faults []error
+	calls  int
+	// Delay delays every call, the context of the call cancels it. It is set before the first call.
+	Delay time.Duration
+}
+
+func NewFaultyStore(faults ...error) *FaultyStore {
+	return &FaultyStore{faults: faults}
+}
+
+func (f *FaultyStore) call(ctx context.Context) error {
+	f.mu.Lock()
+	f.calls++
+	var err error
+	if len(f.faults) > 0 {
+		err, f.faults = f.faults[0], f.faults[1:]
+	}
+	delay := f.Delay
+	f.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
+// ClearFaults drops the remaining faults, all following calls succeed.
+func (f *FaultyStore) ClearFaults() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+}
+
+// Calls returns the number of calls so far.
+func (f *FaultyStore) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *FaultyStore) BucketExists(ctx context.Context, name string) (bool, error) {
+	if err := f.call(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *FaultyStore) CreateBucket(ctx context.Context, name string) error {
+	return f.call(ctx)
+}
+
+func (f *FaultyStore) DeleteBucket(ctx context.Context, name string) error {
+	return f.call(ctx)
+}
+
+func (f *FaultyStore) ListBuckets(ctx context.Context) ([]store.Bucket, error) {
+	return nil, f.call(ctx)
+}
+
+func (f *FaultyStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	return nil, f.call(ctx)
+}
+
+func (f *FaultyStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	return f.call(ctx)
+}
+
+func (f *FaultyStore) SetBucketPolicy(ctx context.Context, name, policy string) error {
+	return f.call(ctx)
+}
@@ -0,0 +1,189 @@
+// Package storetest provides in-memory fakes of the BucketStore and of the MinIO admin API for tests.
+package storetest
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+)
+
+// Stores hands out one in-memory Store per backend, unknown backends get an empty Store.
+type Stores struct {
+	mu     sync.Mutex
+	stores map[string]*Store
+}
+
+func NewStores() *Stores {
+	return &Stores{stores: make(map[string]*Store)}
+}
+
+func (f *Stores) Get(backend string) (store.BucketStore, error) {
+	return f.Store(backend), nil
+}
+
+// Store returns the Store of backend.
+func (f *Stores) Store(backend string) *Store {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.stores[backend]
+	if !ok {
+		s = NewStore()
+		f.stores[backend] = s
+	}
+	return s
+}
+
+// Set makes backend use s, e.g. to share one Store between backends with the same endpoint.
+func (f *Stores) Set(backend string, s *Store) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stores[backend] = s
+}
+
+// Store holds buckets with their tags and policies in memory.
+type Store struct {
+	mu          sync.Mutex
+	tags        map[string]map[string]string
+	policies    map[string]string
+	deleted     []string
+	deleteErr   error
+	policyCalls int
+}
+
+func NewStore() *Store {
+	return &Store{tags: make(map[string]map[string]string), policies: make(map[string]string)}
+}
+
+// AddBucket creates the bucket name with tags, the tags of an existing bucket are replaced.
+func (s *Store) AddBucket(name string, tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags[name] = maps.Clone(tags)
+	if s.tags[name] == nil {
+		s.tags[name] = map[string]string{}
+	}
+}
+
+// HasBucket reports whether the bucket name exists.
+func (s *Store) HasBucket(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tags[name]
+	return ok
+}
+
+// Tags returns a copy of the tags of the bucket name.
+func (s *Store) Tags(name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.tags[name])
+}
+
+// Policy returns the policy of the bucket name and whether it has one.
+func (s *Store) Policy(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy, ok := s.policies[name]
+	return policy, ok
+}
+
+// PolicyCalls returns the number of SetBucketPolicy calls.
+func (s *Store) PolicyCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policyCalls
+}
+
+// Deleted returns the deleted buckets in the order of deletion.
+func (s *Store) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+// FailDelete makes DeleteBucket fail with err, nil lets it succeed again.
+func (s *Store) FailDelete(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteErr = err
+}
+
+func (s *Store) BucketExists(ctx context.Context, name string) (bool, error) {
+	return s.HasBucket(name), nil
+}
+
+func (s *Store) CreateBucket(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tags[name]; !ok {
+		s.tags[name] = map[string]string{}
+	}
+	return nil
+}
+
+func (s *Store) DeleteBucket(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	delete(s.tags, name)
+	delete(s.policies, name)
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+func (s *Store) ListBuckets(ctx context.Context) ([]store.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buckets := make([]store.Bucket, 0, len(s.tags))
+	for name := range s.tags {
+		buckets = append(buckets, store.Bucket{Name: name})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
+
+func (s *Store) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags, ok := s.tags[name]
+	if !ok {
+		return nil, noSuchBucket(name)
+	}
+	return maps.Clone(tags), nil
+}
+
+func (s *Store) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tags[name]; !ok {
+		return noSuchBucket(name)
+	}
+	s.tags[name] = maps.Clone(tags)
+	return nil
+}
+
+func (s *Store) SetBucketPolicy(ctx context.Context, name, policy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policyCalls++
+	if _, ok := s.tags[name]; !ok {
+		return noSuchBucket(name)
+	}
+	if policy == "" {
+		delete(s.policies, name)
+	} else {
+		s.policies[name] = policy
+	}
+	return nil
+}
+
+func noSuchBucket(name string) error {
+	return minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: 404, BucketName: name, Message: "The specified bucket does not exist"}
+}
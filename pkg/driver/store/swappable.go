@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SwappableStore delegates to a BucketStore which can be replaced at runtime
+type SwappableStore struct {
+	current atomic.Pointer[BucketStore]
+}
+
+func NewSwappableStore(s BucketStore) *SwappableStore {
+	w := &SwappableStore{}
+	w.Swap(s)
+	return w
+}
+
+// Swap replaces the BucketStore for all following calls
+func (w *SwappableStore) Swap(s BucketStore) {
+	w.current.Store(&s)
+}
+
+func (w *SwappableStore) Current() BucketStore {
+	return *w.current.Load()
+}
+
+func (w *SwappableStore) BucketExists(ctx context.Context, name string) (bool, error) {
+	return w.Current().BucketExists(ctx, name)
+}
+
+func (w *SwappableStore) CreateBucket(ctx context.Context, name string) error {
+	return w.Current().CreateBucket(ctx, name)
+}
+
+func (w *SwappableStore) DeleteBucket(ctx context.Context, name string) error {
+	return w.Current().DeleteBucket(ctx, name)
+}
+
+func (w *SwappableStore) ListBuckets(ctx context.Context) ([]Bucket, error) {
+	return w.Current().ListBuckets(ctx)
+}
+
+func (w *SwappableStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	return w.Current().BucketTags(ctx, name)
+}
+
+func (w *SwappableStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	return w.Current().SetBucketTags(ctx, name, tags)
+}
+
+func (w *SwappableStore) SetBucketPolicy(ctx context.Context, name, policy string) error {
+	return w.Current().SetBucketPolicy(ctx, name, policy)
+}
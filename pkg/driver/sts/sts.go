@@ -0,0 +1,179 @@
+// Package sts exchanges Kubernetes service account tokens for temporary MinIO credentials.
+package sts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/smou/k8s-csi-s3/pkg/driver/logging"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TokensContextKey is set by kubelet if the CSIDriver requests service account tokens.
+	TokensContextKey = "csi.storage.k8s.io/serviceAccount.tokens"
+
+	// AuthenticationSourceKey selects how a volume authenticates against S3.
+	AuthenticationSourceKey = "authenticationSource"
+	// AuthenticationSourceServiceAccount mounts the volume with the identity of the pod.
+	AuthenticationSourceServiceAccount = "serviceAccount"
+
+	DefaultAudience      = "sts.min.io"
+	DefaultDuration      = time.Hour
+	DefaultRefreshWindow = 5 * time.Minute
+	// DefaultTimeout limits a single exchange, the STS client of minio-go takes no context
+	DefaultTimeout = 30 * time.Second
+)
+
+// ServiceAccountToken is a single entry of the tokens passed by kubelet.
+type ServiceAccountToken struct {
+	Token               string    `json:"token"`
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+}
+
+// String prints the token redacted.
+func (t ServiceAccountToken) String() string {
+	return fmt.Sprintf("{Token:%s ExpirationTimestamp:%v}", logging.Redact(t.Token), t.ExpirationTimestamp)
+}
+
+// LogValue implements slog.LogValuer and redacts the token.
+func (t ServiceAccountToken) LogValue() slog.Value {
+	return slog.StringValue(t.String())
+}
+
+// Credentials are temporary credentials returned by the STS endpoint.
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Expiration   time.Time
+}
+
+// String prints the credentials redacted.
+func (c Credentials) String() string {
+	return fmt.Sprintf("{AccessKey:%s SecretKey:%s SessionToken:%s Expiration:%v}",
+		logging.Redact(c.AccessKey), logging.Redact(c.SecretKey), logging.Redact(c.SessionToken), c.Expiration)
+}
+
+// LogValue implements slog.LogValuer and redacts the credentials.
+func (c Credentials) LogValue() slog.Value {
+	return slog.StringValue(c.String())
+}
+
+// NeedsRefresh reports whether the credentials expire within window.
+func (c *Credentials) NeedsRefresh(now time.Time, window time.Duration) bool {
+	if c == nil {
+		return true
+	}
+	if c.Expiration.IsZero() {
+		return false
+	}
+	return now.Add(window).After(c.Expiration)
+}
+
+// Exchanger exchanges a web identity token for temporary credentials.
+type Exchanger interface {
+	Exchange(ctx context.Context, endpoint, token string) (*Credentials, error)
+}
+
+// ExchangerFactory returns the Exchanger of an endpoint, transport carries its TLS settings.
+type ExchangerFactory func(transport http.RoundTripper) Exchanger
+
+// WebIdentityExchangers returns the factory of WebIdentityExchangers which request credentials valid for duration.
+func WebIdentityExchangers(duration time.Duration) ExchangerFactory {
+	return func(transport http.RoundTripper) Exchanger {
+		return NewWebIdentityExchanger(duration, transport)
+	}
+}
+
+// IsServiceAccountVolume reports whether the volume uses the pod identity instead of static keys.
+func IsServiceAccountVolume(volumeContext map[string]string) bool {
+	return volumeContext[AuthenticationSourceKey] == AuthenticationSourceServiceAccount
+}
+
+// TokenForAudience returns the service account token for audience from the volume context.
+func TokenForAudience(volumeContext map[string]string, audience string) (*ServiceAccountToken, error) {
+	raw := volumeContext[TokensContextKey]
+	if raw == "" {
+		return nil, fmt.Errorf("%s missing in volume context, check tokenRequests of the CSIDriver", TokensContextKey)
+	}
+	tokens := map[string]ServiceAccountToken{}
+	if err := json.Unmarshal([]byte(raw), &tokens); err != nil {
+		return nil, fmt.Errorf("cannot parse service account tokens: %w", err)
+	}
+	token, ok := tokens[audience]
+	if !ok || token.Token == "" {
+		return nil, fmt.Errorf("no service account token for audience %q", audience)
+	}
+	return &token, nil
+}
+
+// WebIdentityExchanger calls AssumeRoleWithWebIdentity on the MinIO STS endpoint.
+type WebIdentityExchanger struct {
+	Duration time.Duration
+	client   *http.Client
+}
+
+// NewWebIdentityExchanger sends the requests through transport, nil uses the default transport.
+func NewWebIdentityExchanger(duration time.Duration, transport http.RoundTripper) *WebIdentityExchanger {
+	if duration <= 0 {
+		duration = DefaultDuration
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &WebIdentityExchanger{
+		Duration: duration,
+		client:   &http.Client{Transport: transport, Timeout: DefaultTimeout},
+	}
+}
+
+func (e *WebIdentityExchanger) Exchange(ctx context.Context, endpoint, token string) (*Credentials, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity not attempted: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity not attempted: %w", context.DeadlineExceeded)
+	}
+	klog.FromContext(ctx).V(4).Info("STS AssumeRoleWithWebIdentity", "endpoint", endpoint)
+	creds, err := credentials.NewSTSWebIdentity(endpoint, func() (*credentials.WebIdentityToken, error) {
+		return &credentials.WebIdentityToken{
+			Token:  token,
+			Expiry: int(e.Duration.Seconds()),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		value credentials.Value
+		err   error
+	}
+	done := make(chan result, 1)
+	// the request cannot be canceled, it ends at the latest after the Timeout of the client
+	go func() {
+		v, err := creds.GetWithContext(&credentials.CredContext{Client: e.client})
+		done <- result{v, err}
+	}()
+	var r result
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity failed: %w", ctx.Err())
+	case r = <-done:
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity failed: %w", r.err)
+	}
+	v := r.value
+	return &Credentials{
+		AccessKey:    v.AccessKeyID,
+		SecretKey:    v.SecretAccessKey,
+		SessionToken: v.SessionToken,
+		Expiration:   v.Expiration,
+	}, nil
+}
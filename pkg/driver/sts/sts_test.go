@@ -0,0 +1,115 @@
+package sts_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeSTS(t *testing.T, expiration time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "sa-token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`)
+			return
+		}
+		assert.Equal(t, "3600", r.Form.Get("DurationSeconds"))
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>tmp-access</AccessKeyId>
+      <SecretAccessKey>tmp-secret</SecretAccessKey>
+      <SessionToken>tmp-session</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, expiration.UTC().Format(time.RFC3339))
+	}))
+}
+
+func TestWebIdentityExchanger_Exchange(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	srv := newFakeSTS(t, expiration)
+	defer srv.Close()
+
+	e := sts.NewWebIdentityExchanger(time.Hour, nil)
+	creds, err := e.Exchange(context.Background(), srv.URL, "sa-token")
+	require.NoError(t, err)
+	assert.Equal(t, "tmp-access", creds.AccessKey)
+	assert.Equal(t, "tmp-secret", creds.SecretKey)
+	assert.Equal(t, "tmp-session", creds.SessionToken)
+	assert.True(t, expiration.Equal(creds.Expiration))
+
+	_, err = e.Exchange(context.Background(), srv.URL, "wrong-token")
+	assert.Error(t, err)
+}
+
+func TestWebIdentityExchanger_ExpiredContext(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+	e := sts.NewWebIdentityExchanger(time.Hour, srv.Client().Transport)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := e.Exchange(ctx, srv.URL, "sa-token")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = e.Exchange(ctx, srv.URL, "sa-token")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, calls.Load())
+}
+
+func TestWebIdentityExchanger_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	e := sts.NewWebIdentityExchanger(time.Hour, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := e.Exchange(ctx, srv.URL, "sa-token")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCredentials_NeedsRefresh(t *testing.T) {
+	now := time.Now()
+	var missing *sts.Credentials
+	assert.True(t, missing.NeedsRefresh(now, time.Minute))
+	assert.False(t, (&sts.Credentials{}).NeedsRefresh(now, time.Minute))
+	assert.False(t, (&sts.Credentials{Expiration: now.Add(time.Hour)}).NeedsRefresh(now, 5*time.Minute))
+	assert.True(t, (&sts.Credentials{Expiration: now.Add(time.Minute)}).NeedsRefresh(now, 5*time.Minute))
+}
+
+func TestTokenForAudience(t *testing.T) {
+	ctx := map[string]string{
+		sts.TokensContextKey: `{"sts.min.io":{"token":"sa-token","expirationTimestamp":"2026-01-01T00:00:00Z"}}`,
+	}
+	token, err := sts.TokenForAudience(ctx, "sts.min.io")
+	require.NoError(t, err)
+	assert.Equal(t, "sa-token", token.Token)
+
+	_, err = sts.TokenForAudience(ctx, "other")
+	assert.Error(t, err)
+
+	_, err = sts.TokenForAudience(map[string]string{}, "sts.min.io")
+	assert.Error(t, err)
+}
@@ -0,0 +1,145 @@
+// Package svcacct creates a MinIO service account per volume, so a node can only access the buckets of the
+// volumes it mounts. The credentials are stored in a Secret which the StorageClass references as node stage secret.
+package svcacct
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/minio/madmin-go/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+)
+
+const (
+	// ParameterKey is the StorageClass parameter and volume context key enabling per-volume credentials
+	ParameterKey = "perVolumeCredentials"
+
+	// SecretPrefix is prepended to the PV name, the StorageClass references csi-s3-${pv.name}
+	SecretPrefix = "csi-s3-"
+
+	labelBackend = "csi-s3/backend"
+	labelBucket  = "csi-s3/bucket"
+	// accessKeyKey is an annotation, so DeleteVolume can revoke the account without reading the secret key
+	accessKeyKey = "csi-s3/access-key"
+)
+
+// Enabled reports whether the parameters or the volume context request per-volume credentials.
+func Enabled(params map[string]string) bool {
+	enabled, _ := strconv.ParseBool(params[ParameterKey])
+	return enabled
+}
+
+// SecretName returns the name of the Secret of the volume volumeName.
+func SecretName(volumeName string) string {
+	return SecretPrefix + volumeName
+}
+
+// Admin is the part of the MinIO admin API used to manage service accounts.
+type Admin interface {
+	AddServiceAccount(ctx context.Context, opts madmin.AddServiceAccountReq) (madmin.Credentials, error)
+	DeleteServiceAccount(ctx context.Context, serviceAccount string) error
+}
+
+// Manager creates and revokes the service accounts of the volumes.
+type Manager struct {
+	Kube kubernetes.Interface
+	// Namespace of the Secrets, the namespace of the driver
+	Namespace string
+	// Admin returns the admin client of a backend, authenticated as the controller, which becomes the parent
+	// of the service accounts
+	Admin func(backend string) (Admin, error)
+}
+
+// Ensure creates the service account of the volume volumeName scoped to bucket and prefix and stores its
+// credentials in the Secret SecretName(volumeName). An existing Secret is kept, so retries are idempotent.
+func (m *Manager) Ensure(ctx context.Context, backend, bucket, prefix, volumeName string) error {
+	if m == nil || m.Kube == nil || m.Namespace == "" {
+		return errors.New("per-volume credentials need the Kubernetes API and the namespace of the driver")
+	}
+	logger := klog.FromContext(ctx).WithValues("secret", SecretName(volumeName))
+	secrets := m.Kube.CoreV1().Secrets(m.Namespace)
+	if _, err := secrets.Get(ctx, SecretName(volumeName), metav1.GetOptions{}); err == nil {
+		return nil
+	} else if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("cannot read secret: %w", err)
+	}
+
+	admin, err := m.Admin(backend)
+	if err != nil {
+		return err
+	}
+	inline, err := json.Marshal(policy.Volume(bucket, prefix))
+	if err != nil {
+		return err
+	}
+	creds, err := admin.AddServiceAccount(ctx, madmin.AddServiceAccountReq{
+		Policy:      inline,
+		Description: "csi-s3 volume " + volumeName,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create service account: %w", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        SecretName(volumeName),
+			Namespace:   m.Namespace,
+			Labels:      map[string]string{labelBackend: backend, labelBucket: bucket},
+			Annotations: map[string]string{accessKeyKey: creds.AccessKey},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: config.S3Credentials{AccessKey: creds.AccessKey, SecretKey: creds.SecretKey}.SecretData(),
+	}
+	if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		// without the Secret nobody could revoke the account later
+		if delErr := admin.DeleteServiceAccount(ctx, creds.AccessKey); delErr != nil {
+			logger.Error(delErr, "Cannot delete service account", "accessKey", creds.AccessKey)
+		}
+		return fmt.Errorf("cannot create secret: %w", err)
+	}
+	logger.Info("Service account created", "accessKey", creds.AccessKey)
+	return nil
+}
+
+// Revoke deletes the service accounts and Secrets of bucket on backend. Volumes without service account
+// have no Secret, so Revoke is a no-op for them.
+func (m *Manager) Revoke(ctx context.Context, backend, bucket string) error {
+	if m == nil || m.Kube == nil || m.Namespace == "" {
+		return nil
+	}
+	logger := klog.FromContext(ctx)
+	secrets := m.Kube.CoreV1().Secrets(m.Namespace)
+	list, err := secrets.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{labelBackend: backend, labelBucket: bucket}).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot list secrets: %w", err)
+	}
+	for _, secret := range list.Items {
+		if accessKey := secret.Annotations[accessKeyKey]; accessKey != "" {
+			admin, err := m.Admin(backend)
+			if err != nil {
+				return err
+			}
+			err = admin.DeleteServiceAccount(ctx, accessKey)
+			if err != nil && madmin.ToErrorResponse(err).Code != "XMinioAdminServiceAccountNotFound" {
+				return fmt.Errorf("cannot delete service account %s: %w", accessKey, err)
+			}
+			logger.Info("Service account revoked", "accessKey", accessKey, "secret", secret.Name)
+		}
+		if err := secrets.Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("cannot delete secret %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package svcacct_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/policy"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/storetest"
+	"github.com/smou/k8s-csi-s3/pkg/driver/svcacct"
+)
+
+func newManager() (*svcacct.Manager, *storetest.Admin) {
+	admin := storetest.NewAdmin()
+	return &svcacct.Manager{
+		Kube:      fake.NewClientset(),
+		Namespace: "csi-s3",
+		Admin:     func(string) (svcacct.Admin, error) { return admin, nil },
+	}, admin
+}
+
+func TestEnsure(t *testing.T) {
+	m, admin := newManager()
+	ctx := context.Background()
+
+	require.NoError(t, m.Ensure(ctx, "default", "csi-pvc-1", "team-a/", "pvc-1"))
+	secret, err := m.Kube.CoreV1().Secrets("csi-s3").Get(ctx, "csi-s3-pvc-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "sa-1", string(secret.Data["MINIO_ACCESSKEY"]))
+	assert.Equal(t, "secret-sa-1", string(secret.Data["MINIO_SECRETKEY"]))
+
+	var doc policy.Document
+	require.NoError(t, json.Unmarshal(admin.ServiceAccounts()["sa-1"].Policy, &doc))
+	require.Len(t, doc.Statement, 2)
+	assert.Equal(t, []string{"arn:aws:s3:::csi-pvc-1"}, doc.Statement[0].Resource)
+	assert.Equal(t, []string{"team-a/*"}, doc.Statement[0].Condition["StringLike"]["s3:prefix"])
+	assert.Equal(t, []string{"arn:aws:s3:::csi-pvc-1/team-a/*"}, doc.Statement[1].Resource)
+
+	// a retried CreateVolume keeps the account
+	require.NoError(t, m.Ensure(ctx, "default", "csi-pvc-1", "team-a/", "pvc-1"))
+	assert.Len(t, admin.ServiceAccounts(), 1)
+}
+
+func TestRevoke(t *testing.T) {
+	m, admin := newManager()
+	ctx := context.Background()
+	require.NoError(t, m.Ensure(ctx, "default", "csi-pvc-1", "", "pvc-1"))
+	require.NoError(t, m.Ensure(ctx, "default", "csi-pvc-2", "", "pvc-2"))
+
+	require.NoError(t, m.Revoke(ctx, "default", "csi-pvc-1"))
+	assert.NotContains(t, admin.ServiceAccounts(), "sa-1")
+	assert.Contains(t, admin.ServiceAccounts(), "sa-2")
+	_, err := m.Kube.CoreV1().Secrets("csi-s3").Get(ctx, "csi-s3-pvc-1", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the account is gone already, e.g. removed by hand
+	_, err = m.Kube.CoreV1().Secrets("csi-s3").Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "csi-s3-pvc-3", Namespace: "csi-s3",
+		Labels:      map[string]string{"csi-s3/backend": "default", "csi-s3/bucket": "csi-pvc-3"},
+		Annotations: map[string]string{"csi-s3/access-key": "sa-9"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, m.Revoke(ctx, "default", "csi-pvc-3"))
+
+	// volumes without service account
+	require.NoError(t, m.Revoke(ctx, "default", "csi-pvc-4"))
+	var nilManager *svcacct.Manager
+	assert.NoError(t, nilManager.Revoke(ctx, "default", "csi-pvc-4"))
+}
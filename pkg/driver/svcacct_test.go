@@ -0,0 +1,43 @@
+package driver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/storetest"
+	"github.com/smou/k8s-csi-s3/pkg/driver/svcacct"
+)
+
+func TestCreateVolume_PerVolumeCredentials(t *testing.T) {
+	srv, _ := newZonedControllerServer("")
+	params := map[string]string{svcacct.ParameterKey: "true"}
+
+	_, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, params))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "without the Kubernetes API")
+
+	admin := storetest.NewAdmin()
+	kube := fake.NewClientset()
+	srv.ServiceAccounts = &svcacct.Manager{
+		Kube:      kube,
+		Namespace: "csi-s3",
+		Admin:     func(string) (svcacct.Admin, error) { return admin, nil },
+	}
+	resp, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, params))
+	require.NoError(t, err)
+	assert.Equal(t, "true", resp.GetVolume().GetVolumeContext()[svcacct.ParameterKey])
+	assert.Len(t, admin.ServiceAccounts(), 1)
+	_, err = kube.CoreV1().Secrets("csi-s3").Get(context.Background(), "csi-s3-pvc-1", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	_, err = srv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	require.NoError(t, err)
+	assert.Empty(t, admin.ServiceAccounts())
+}
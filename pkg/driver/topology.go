@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"slices"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// selectBackend picks the backend of a new volume. An explicit backend parameter wins, otherwise the
+// backend of the first preferred, then requisite zone is used. The default backend is the fallback.
+func (srv *ControllerServer) selectBackend(req *csi.CreateVolumeRequest) (*config.Backend, error) {
+	requirement := req.GetAccessibilityRequirements()
+	if name := req.GetParameters()[volume.BackendKey]; name != "" || srv.TopologyKey == "" || requirement == nil {
+		backend, err := srv.Backends.Get(name)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if zone := backend.S3.Current().Zone; srv.TopologyKey != "" && zone != "" && !srv.zoneAllowed(requirement, zone) {
+			return nil, status.Errorf(codes.ResourceExhausted, "backend %s in zone %s is not accessible from the requested topology", backend.Name, zone)
+		}
+		return backend, nil
+	}
+	for _, topology := range slices.Concat(requirement.GetPreferred(), requirement.GetRequisite()) {
+		if backend, ok := srv.Backends.ForZone(topology.GetSegments()[srv.TopologyKey]); ok {
+			return backend, nil
+		}
+	}
+	// a backend without zone is accessible from all nodes
+	if backend := srv.Backends.Default(); backend.S3.Current().Zone == "" {
+		return backend, nil
+	}
+	return nil, status.Errorf(codes.ResourceExhausted, "no backend for the zones of the requested topology")
+}
+
+// zoneAllowed reports whether zone is one of the requested topologies, any zone is allowed without requirement.
+func (srv *ControllerServer) zoneAllowed(requirement *csi.TopologyRequirement, zone string) bool {
+	topologies := slices.Concat(requirement.GetRequisite(), requirement.GetPreferred())
+	if len(topologies) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(topologies, func(t *csi.Topology) bool {
+		return t.GetSegments()[srv.TopologyKey] == zone
+	})
+}
+
+// accessibleTopology returns the zone of the backend, nil if its volumes are accessible from all nodes.
+func (srv *ControllerServer) accessibleTopology(backend *config.Backend) []*csi.Topology {
+	zone := backend.S3.Current().Zone
+	if srv.TopologyKey == "" || zone == "" {
+		return nil
+	}
+	return []*csi.Topology{{Segments: map[string]string{srv.TopologyKey: zone}}}
+}
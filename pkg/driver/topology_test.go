@@ -0,0 +1,97 @@
+package driver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+	"github.com/smou/k8s-csi-s3/pkg/driver"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store/storetest"
+)
+
+const zoneKey = config.DefaultTopologyKey
+
+func newZonedControllerServer(defaultZone string) (*driver.ControllerServer, *storetest.Stores) {
+	backends := config.NewBackends(map[string]config.BackendSpec{
+		config.DefaultBackend: {S3: config.S3Config{Endpoint: "https://minio.local", Region: "us-east-1", Zone: defaultZone}},
+		"zone-a":              {S3: config.S3Config{Endpoint: "https://minio-a.local", Region: "us-east-1", Zone: "a"}},
+		"zone-b":              {S3: config.S3Config{Endpoint: "https://minio-b.local", Region: "us-east-1", Zone: "b"}},
+	}, map[string]config.S3Credentials{config.DefaultBackend: {AccessKey: "access", SecretKey: "secret"}})
+	stores := storetest.NewStores()
+	srv := driver.NewControllerServer(&config.DriverConfig{
+		BackendRegistry: backends,
+		Topology:        config.TopologyConfig{Key: zoneKey},
+	}, stores)
+	return srv, stores
+}
+
+func createVolumeRequest(requirement *csi.TopologyRequirement, params map[string]string) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name: "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		}},
+		Parameters:                params,
+		AccessibilityRequirements: requirement,
+	}
+}
+
+func zones(names ...string) []*csi.Topology {
+	var topologies []*csi.Topology
+	for _, name := range names {
+		topologies = append(topologies, &csi.Topology{Segments: map[string]string{zoneKey: name}})
+	}
+	return topologies
+}
+
+func TestCreateVolume_PreferredZone(t *testing.T) {
+	srv, stores := newZonedControllerServer("")
+
+	resp, err := srv.CreateVolume(context.Background(), createVolumeRequest(&csi.TopologyRequirement{
+		Requisite: zones("a", "b"),
+		Preferred: zones("b", "a"),
+	}, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "zone-b/pvc-1", resp.Volume.VolumeId)
+	assert.Equal(t, zones("b"), resp.Volume.AccessibleTopology)
+	assert.True(t, stores.Store("zone-b").HasBucket("pvc-1"))
+}
+
+func TestCreateVolume_ZoneWithoutBackendUsesDefault(t *testing.T) {
+	srv, _ := newZonedControllerServer("")
+
+	resp, err := srv.CreateVolume(context.Background(), createVolumeRequest(&csi.TopologyRequirement{
+		Requisite: zones("c"),
+	}, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "pvc-1", resp.Volume.VolumeId)
+	assert.Empty(t, resp.Volume.AccessibleTopology)
+}
+
+func TestCreateVolume_NoBackendForZone(t *testing.T) {
+	srv, _ := newZonedControllerServer("x")
+
+	_, err := srv.CreateVolume(context.Background(), createVolumeRequest(&csi.TopologyRequirement{
+		Requisite: zones("c"),
+	}, nil))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestCreateVolume_ExplicitBackendOutsideRequestedZones(t *testing.T) {
+	srv, _ := newZonedControllerServer("")
+
+	_, err := srv.CreateVolume(context.Background(), createVolumeRequest(&csi.TopologyRequirement{
+		Requisite: zones("b"),
+	}, map[string]string{"backend": "zone-a"}))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	resp, err := srv.CreateVolume(context.Background(), createVolumeRequest(nil, map[string]string{"backend": "zone-a"}))
+	require.NoError(t, err)
+	assert.Equal(t, zones("a"), resp.Volume.AccessibleTopology)
+}
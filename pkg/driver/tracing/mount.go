@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+)
+
+// tracedProvider starts a span for every mount and unmount of a mount.Provider.
+type tracedProvider struct {
+	name string
+	next mount.Provider
+}
+
+// InstrumentProvider wraps p to trace its mounts, name distinguishes the providers in the span names.
+// The result implements mount.CredentialRefresher if p does, and mount.TargetTracker in any case.
+func InstrumentProvider(name string, p mount.Provider) mount.Provider {
+	t := &tracedProvider{name: name, next: p}
+	if r, ok := p.(mount.CredentialRefresher); ok {
+		return &tracedRefresher{tracedProvider: t, refresher: r}
+	}
+	return t
+}
+
+func (p *tracedProvider) Mount(ctx context.Context, req mount.MountRequest) (err error) {
+	ctx, span := Start(ctx, p.name+".Mount", AttrBucket.String(req.Bucket), AttrTargetPath.String(req.TargetPath))
+	defer func() { End(span, err) }()
+	return p.next.Mount(ctx, req)
+}
+
+func (p *tracedProvider) Unmount(ctx context.Context, targetPath string) (err error) {
+	ctx, span := Start(ctx, p.name+".Unmount", AttrTargetPath.String(targetPath))
+	defer func() { End(span, err) }()
+	return p.next.Unmount(ctx, targetPath)
+}
+
+func (p *tracedProvider) IsMounted(targetPath string) (bool, error) {
+	return p.next.IsMounted(targetPath)
+}
+
+// Manages forwards to p if it is a mount.TargetTracker, other providers manage no targets.
+func (p *tracedProvider) Manages(targetPath string) bool {
+	t, ok := p.next.(mount.TargetTracker)
+	return ok && t.Manages(targetPath)
+}
+
+type tracedRefresher struct {
+	*tracedProvider
+	refresher mount.CredentialRefresher
+}
+
+func (p *tracedRefresher) RefreshCredentials(req mount.MountRequest) error {
+	return p.refresher.RefreshCredentials(req)
+}
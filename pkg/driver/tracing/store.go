@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+)
+
+// tracedStore starts a span for every call of a BucketStore.
+type tracedStore struct {
+	backend string
+	next    store.BucketStore
+}
+
+// InstrumentBucketStore wraps s to trace its calls for backend.
+func InstrumentBucketStore(backend string, s store.BucketStore) store.BucketStore {
+	return &tracedStore{backend: backend, next: s}
+}
+
+func (s *tracedStore) BucketExists(ctx context.Context, name string) (exists bool, err error) {
+	ctx, span := Start(ctx, "BucketStore.BucketExists", AttrBackend.String(s.backend), AttrBucket.String(name))
+	defer func() { End(span, err) }()
+	return s.next.BucketExists(ctx, name)
+}
+
+func (s *tracedStore) CreateBucket(ctx context.Context, name string) (err error) {
+	ctx, span := Start(ctx, "BucketStore.CreateBucket", AttrBackend.String(s.backend), AttrBucket.String(name))
+	defer func() { End(span, err) }()
+	return s.next.CreateBucket(ctx, name)
+}
+
+func (s *tracedStore) DeleteBucket(ctx context.Context, name string) (err error) {
+	ctx, span := Start(ctx, "BucketStore.DeleteBucket", AttrBackend.String(s.backend), AttrBucket.String(name))
+	defer func() { End(span, err) }()
+	return s.next.DeleteBucket(ctx, name)
+}
+
+func (s *tracedStore) ListBuckets(ctx context.Context) (buckets []store.Bucket, err error) {
+	ctx, span := Start(ctx, "BucketStore.ListBuckets", AttrBackend.String(s.backend))
+	defer func() { End(span, err) }()
+	return s.next.ListBuckets(ctx)
+}
+
+func (s *tracedStore) BucketTags(ctx context.Context, name string) (tags map[string]string, err error) {
+	ctx, span := Start(ctx, "BucketStore.BucketTags", AttrBackend.String(s.backend), AttrBucket.String(name))
+	defer func() { End(span, err) }()
+	return s.next.BucketTags(ctx, name)
+}
+
+func (s *tracedStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) (err error) {
+	ctx, span := Start(ctx, "BucketStore.SetBucketTags", AttrBackend.String(s.backend), AttrBucket.String(name))
+	defer func() { End(span, err) }()
+	return s.next.SetBucketTags(ctx, name, tags)
+}
+
+func (s *tracedStore) SetBucketPolicy(ctx context.Context, name, policy string) (err error) {
+	ctx, span := Start(ctx, "BucketStore.SetBucketPolicy", AttrBackend.String(s.backend), AttrBucket.String(name))
+	defer func() { End(span, err) }()
+	return s.next.SetBucketPolicy(ctx, name, policy)
+}
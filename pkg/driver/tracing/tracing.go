@@ -0,0 +1,147 @@
+// Package tracing exports OpenTelemetry traces of the CSI calls, the S3 operations and the mounts.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const instrumentationName = "github.com/smou/k8s-csi-s3"
+
+// Exporters of --tracingExporter.
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Attribute keys of the spans.
+const (
+	AttrVolumeID   = attribute.Key("csi.volume_id")
+	AttrNodeID     = attribute.Key("csi.node_id")
+	AttrBackend    = attribute.Key("s3.backend")
+	AttrBucket     = attribute.Key("s3.bucket")
+	AttrTargetPath = attribute.Key("mount.target_path")
+)
+
+// Config selects the exporter, tracing is disabled if Exporter is empty.
+type Config struct {
+	Exporter string
+	// Endpoint of the OTLP collector (host:port), the OTEL_EXPORTER_OTLP_* variables apply if empty
+	Endpoint string
+	Insecure bool
+	// SampleRatio of the traces started by the driver, 1 samples everything
+	SampleRatio float64
+	// Writer of the stdout exporter, os.Stdout if nil
+	Writer io.Writer
+}
+
+// Setup installs the global TracerProvider. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	case ExporterStdout:
+		w := cfg.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q: expected %s or %s", cfg.Exporter, ExporterOTLP, ExporterStdout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s exporter: %w", cfg.Exporter, err)
+	}
+
+	v := version.GetVersion()
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(v.DriverName),
+		semconv.ServiceVersion(v.DriverVersion),
+	))
+	if err != nil {
+		return nil, err
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the volume attributes of ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if a, ok := ctx.Value(attributesKey{}).([]attribute.KeyValue); ok {
+		attrs = append(attrs, a...)
+	}
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type attributesKey struct{}
+
+// WithAttributes adds attributes to all spans started from ctx.
+func WithAttributes(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	if a, ok := ctx.Value(attributesKey{}).([]attribute.KeyValue); ok {
+		attrs = append(append([]attribute.KeyValue{}, a...), attrs...)
+	}
+	return context.WithValue(ctx, attributesKey{}, attrs)
+}
+
+type volumeIDGetter interface {
+	GetVolumeId() string
+}
+
+// UnaryServerInterceptor sets the volume and node attributes on the span of the gRPC call
+// and passes them on to the spans of the S3 operations and mounts.
+func UnaryServerInterceptor(nodeID string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		attrs := []attribute.KeyValue{AttrNodeID.String(nodeID)}
+		if r, ok := req.(volumeIDGetter); ok && r.GetVolumeId() != "" {
+			attrs = append(attrs, AttrVolumeID.String(r.GetVolumeId()))
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attrs...)
+		return handler(WithAttributes(ctx, attrs...), req)
+	}
+}
@@ -0,0 +1,138 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/smou/k8s-csi-s3/pkg/driver/mount"
+	"github.com/smou/k8s-csi-s3/pkg/driver/store"
+	"github.com/smou/k8s-csi-s3/pkg/driver/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+)
+
+type fakeStore struct {
+	err error
+}
+
+func (f *fakeStore) BucketExists(ctx context.Context, name string) (bool, error) { return true, f.err }
+func (f *fakeStore) CreateBucket(ctx context.Context, name string) error         { return f.err }
+func (f *fakeStore) DeleteBucket(ctx context.Context, name string) error         { return f.err }
+func (f *fakeStore) ListBuckets(ctx context.Context) ([]store.Bucket, error)     { return nil, f.err }
+func (f *fakeStore) BucketTags(ctx context.Context, name string) (map[string]string, error) {
+	return nil, f.err
+}
+func (f *fakeStore) SetBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	return f.err
+}
+func (f *fakeStore) SetBucketPolicy(ctx context.Context, name, policy string) error { return f.err }
+
+type fakeProvider struct{}
+
+func (fakeProvider) Mount(ctx context.Context, req mount.MountRequest) error { return nil }
+func (fakeProvider) Unmount(ctx context.Context, targetPath string) error    { return nil }
+func (fakeProvider) IsMounted(targetPath string) (bool, error)               { return false, nil }
+func (fakeProvider) RefreshCredentials(req mount.MountRequest) error         { return nil }
+func (fakeProvider) Manages(targetPath string) bool                          { return targetPath == "/target" }
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	attrs := map[attribute.Key]string{}
+	for _, a := range span.Attributes() {
+		attrs[a.Key] = a.Value.Emit()
+	}
+	return attrs
+}
+
+func TestInstrumentBucketStore(t *testing.T) {
+	recorder := record(t)
+	ctx := tracing.WithAttributes(context.Background(), tracing.AttrVolumeID.String("eu/bucket"))
+	s := tracing.InstrumentBucketStore("eu", &fakeStore{err: errors.New("Access Denied.")})
+
+	require.Error(t, s.CreateBucket(ctx, "bucket"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "BucketStore.CreateBucket", spans[0].Name())
+	assert.Equal(t, otelcodes.Error, spans[0].Status().Code)
+	attrs := attributes(spans[0])
+	assert.Equal(t, "eu", attrs[tracing.AttrBackend])
+	assert.Equal(t, "bucket", attrs[tracing.AttrBucket])
+	assert.Equal(t, "eu/bucket", attrs[tracing.AttrVolumeID])
+}
+
+func TestInstrumentProvider(t *testing.T) {
+	recorder := record(t)
+	p := tracing.InstrumentProvider("S3Mount", fakeProvider{})
+
+	_, ok := p.(mount.CredentialRefresher)
+	assert.True(t, ok, "CredentialRefresher must be kept")
+	tracker, ok := p.(mount.TargetTracker)
+	require.True(t, ok, "TargetTracker must be kept")
+	assert.True(t, tracker.Manages("/target"))
+	require.NoError(t, p.Mount(context.Background(), mount.MountRequest{Bucket: "bucket", TargetPath: "/target"}))
+	require.NoError(t, p.Unmount(context.Background(), "/target"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "S3Mount.Mount", spans[0].Name())
+	assert.Equal(t, "/target", attributes(spans[0])[tracing.AttrTargetPath])
+	assert.Equal(t, "S3Mount.Unmount", spans[1].Name())
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	recorder := record(t)
+	s := tracing.InstrumentBucketStore("default", &fakeStore{})
+	interceptor := tracing.UnaryServerInterceptor("node-1")
+
+	_, err := interceptor(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "bucket"}, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req any) (any, error) {
+			return nil, s.DeleteBucket(ctx, "bucket")
+		})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	attrs := attributes(spans[0])
+	assert.Equal(t, "node-1", attrs[tracing.AttrNodeID])
+	assert.Equal(t, "bucket", attrs[tracing.AttrVolumeID])
+}
+
+func TestSetup_Stdout(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	var out bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterStdout, Writer: &out})
+	require.NoError(t, err)
+
+	_, span := tracing.Start(context.Background(), "test", tracing.AttrBucket.String("bucket"))
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Contains(t, out.String(), `"Name":"test"`)
+	assert.Contains(t, out.String(), `"s3.bucket"`)
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = tracing.Setup(context.Background(), tracing.Config{Exporter: "jaeger"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+// Package volume encodes the S3 backend profile of a volume into its volume ID.
+package volume
+
+import (
+	"strings"
+
+	"github.com/smou/k8s-csi-s3/pkg/config"
+)
+
+// separator cannot be part of a bucket name
+const separator = "/"
+
+// BackendKey is the StorageClass parameter and volume context key selecting the backend profile.
+const BackendKey = "backend"
+
+// NewID returns the volume ID of bucket on backend. Volumes of the default backend are identified
+// by the bucket name only, so volumes created before backend profiles existed keep working.
+func NewID(backend, bucket string) string {
+	if backend == "" || backend == config.DefaultBackend {
+		return bucket
+	}
+	return backend + separator + bucket
+}
+
+// ParseID splits a volume ID into backend and bucket. The backend is empty for the default backend.
+func ParseID(id string) (backend, bucket string) {
+	if b, bucket, ok := strings.Cut(id, separator); ok {
+		return b, bucket
+	}
+	return "", id
+}
@@ -0,0 +1,22 @@
+package volume_test
+
+import (
+	"testing"
+
+	"github.com/smou/k8s-csi-s3/pkg/driver/volume"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestID(t *testing.T) {
+	assert.Equal(t, "pvc-1", volume.NewID("", "pvc-1"))
+	assert.Equal(t, "pvc-1", volume.NewID("default", "pvc-1"))
+	assert.Equal(t, "fast/csi-pvc-1", volume.NewID("fast", "csi-pvc-1"))
+
+	backend, bucket := volume.ParseID("fast/csi-pvc-1")
+	assert.Equal(t, "fast", backend)
+	assert.Equal(t, "csi-pvc-1", bucket)
+
+	backend, bucket = volume.ParseID("pvc-1")
+	assert.Empty(t, backend)
+	assert.Equal(t, "pvc-1", bucket)
+}